@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// configCmd runs the config subcommands:
+//
+//	config validate [file]   checks the config server mode would load, or the
+//	                         given file, reporting every problem found
+//	config print-defaults    prints the defaults of all settings as YAML
+func configCmd(args []string) {
+	if len(args) == 0 {
+		fail(nil, "Too few arguments: expecting 'config validate [file]' or 'config print-defaults'.")
+	}
+	switch args[0] {
+	case "validate":
+		if len(args) > 2 {
+			fail(nil, "Too many arguments.")
+		}
+		var path string
+		if len(args) == 2 {
+			path = args[1]
+		}
+		_, path, err := config.Load(path)
+		if path == "" {
+			path = "defaults, environment and flags (no config file)"
+		}
+		if err != nil {
+			fail(err, "Invalid config in %s", path)
+		}
+		fmt.Printf("Config in %s is valid.\n", path)
+	case "print-defaults":
+		if len(args) > 1 {
+			fail(nil, "Too many arguments.")
+		}
+		b, err := defaultsYAML()
+		if err != nil {
+			fail(err, "Failed to print defaults")
+		}
+		os.Stdout.Write(b)
+	default:
+		fail(nil, "Unknown config command %q: expecting 'validate' or 'print-defaults'.", args[0])
+	}
+}
+
+// defaultsYAML renders the default config as YAML, with the keys and in the
+// order of the config file.
+func defaultsYAML() ([]byte, error) {
+	b, err := json.Marshal(config.Defaults())
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML, parsed in flow style, which is restyled as blocks
+	var node yaml.Node
+	if err = yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+	var block func(n *yaml.Node)
+	block = func(n *yaml.Node) {
+		n.Style = 0
+		for _, c := range n.Content {
+			block(c)
+		}
+	}
+	block(&node)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err = enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -5,9 +5,12 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	gateway "github.com/LCY2013/http-to-grpc-gateway"
 	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/openapi"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/registry/local"
 	"github.com/LCY2013/http-to-grpc-gateway/internal/server"
 	"io"
 	"os"
@@ -22,7 +25,6 @@ import (
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
-	reflectpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/descriptorpb"
 
@@ -69,8 +71,8 @@ func main() {
 	if (*config.Key == "") != (*config.Cert == "") {
 		fail(nil, "The -cert and -key arguments must be used together and both be present.")
 	}
-	if *config.Format != "json" && *config.Format != "text" {
-		fail(nil, "The -format option must be 'json' or 'text'.")
+	if *config.Format != "json" && *config.Format != "text" && *config.Format != "binary" {
+		fail(nil, "The -format option must be 'json', 'text' or 'binary'.")
 	}
 	if *config.EmitDefaults && *config.Format != "json" {
 		warn("The -emit-defaults is only used when using json format.")
@@ -82,7 +84,7 @@ func main() {
 		fail(nil, "Too few arguments.")
 	}
 	var target string
-	if args[0] != "list" && args[0] != "describe" && args[0] != "server" {
+	if args[0] != "list" && args[0] != "describe" && args[0] != "openapi" && args[0] != "server" && args[0] != "config" {
 		target = args[0]
 		args = args[1:]
 	}
@@ -104,24 +106,32 @@ func main() {
 		config.Reflection.Val = false
 	}
 
-	var list, describe, invokeCmd bool
+	var list, describe, openAPI, invokeCmd bool
 	if args[0] == "list" {
 		list = true
 		args = args[1:]
 	} else if args[0] == "describe" {
 		describe = true
 		args = args[1:]
+	} else if args[0] == "openapi" {
+		openAPI = true
+		args = args[1:]
 	} else if args[0] == "server" {
 		args = args[1:]
+		// returns once the server has shut down
 		server.Run(args)
+		return
+	} else if args[0] == "config" {
+		configCmd(args[1:])
+		return
 	} else {
 		invokeCmd = true
 	}
 
-	cmd(args, list, describe, invokeCmd, target, verbosityLevel)
+	cmd(args, list, describe, openAPI, invokeCmd, target, verbosityLevel)
 }
 
-func cmd(args []string, list, describe, invoke bool, target string, verbosityLevel int) {
+func cmd(args []string, list, describe, openAPI, invoke bool, target string, verbosityLevel int) {
 	var symbol string
 	if invoke {
 		if len(args) == 0 {
@@ -131,10 +141,10 @@ func cmd(args []string, list, describe, invoke bool, target string, verbosityLev
 		args = args[1:]
 	} else {
 		if *config.Data != "" {
-			warn("The -d argument is not used with 'list' or 'describe' verb.")
+			warn("The -d argument is not used with 'list', 'describe' or 'openapi' verb.")
 		}
 		if len(config.RpcHeaders) > 0 {
-			warn("The -rpc-header argument is not used with 'list' or 'describe' verb.")
+			warn("The -rpc-header argument is not used with 'list', 'describe' or 'openapi' verb.")
 		}
 		if len(args) > 0 {
 			symbol = args[0]
@@ -292,7 +302,11 @@ func cmd(args []string, list, describe, invoke bool, target string, verbosityLev
 		md := gateway.MetadataFromHeaders(append(config.AddlHeaders, config.ReflHeaders...))
 		refCtx := metadata.NewOutgoingContext(ctx, md)
 		cc = dial()
-		refClient = grpcreflect.NewClientV1Alpha(refCtx, reflectpb.NewServerReflectionClient(cc))
+		var version gateway.ReflectionVersion
+		refClient, version = gateway.NewReflectionClient(refCtx, cc)
+		if verbosityLevel > 0 {
+			fmt.Printf("\nUsing server reflection %s\n", version)
+		}
 		reflSource := gateway.DescriptorSourceFromServer(ctx, refClient)
 		if fileSource != nil {
 			descSource = config.CompositeSource{Reflection: reflSource, File: fileSource}
@@ -457,6 +471,39 @@ func cmd(args []string, list, describe, invoke bool, target string, verbosityLev
 			fail(err, "Failed to write config.Protoset to %s", *config.ProtosetOut)
 		}
 
+	} else if openAPI {
+		opts := openapi.Options{
+			Version: config.Version,
+			// without a local registry, the gateway is used with the http
+			// registry, which needs the Addr header
+			AddrHeader: len(local.Services()) == 0,
+			Envelope:   "ack",
+		}
+		if config.Version == config.NoVersion {
+			opts.Version = ""
+		}
+		if conf := config.Conf(); conf != nil {
+			if conf.Envelope.Name != "" {
+				opts.Envelope = conf.Envelope.Name
+			}
+			jsonOpts := conf.JSON
+			opts.UseProtoNames = jsonOpts.UseProtoNames != nil && *jsonOpts.UseProtoNames
+			opts.EnumsAsInts = jsonOpts.EnumsAsInts != nil && *jsonOpts.EnumsAsInts
+			opts.Int64AsNumbers = jsonOpts.Int64AsNumbers != nil && *jsonOpts.Int64AsNumbers
+		}
+		if symbol != "" {
+			opts.Services = []string{symbol}
+		}
+		doc, err := openapi.Generate(descSource, opts)
+		if err != nil {
+			fail(err, "Failed to generate OpenAPI document")
+		}
+		out, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			fail(err, "Failed to generate OpenAPI document")
+		}
+		fmt.Println(string(out))
+
 	} else {
 		// Invoke an RPC
 		if cc == nil {
@@ -3,13 +3,15 @@
 
 package main
 
+import "github.com/LCY2013/http-to-grpc-gateway/internal/config"
+
 var (
-	unix = flags.Bool("unix", false, prettify(`
+	unix = config.Flags.Bool("unix", false, config.Prettify(`
 		Indicates that the run address is the path to a Unix domain socket.`))
 )
 
 func init() {
-	isUnixSocket = func() bool {
+	config.IsUnixSocket = func() bool {
 		return *unix
 	}
 }
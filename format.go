@@ -4,11 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"github.com/LCY2013/http-to-grpc-gateway/internal/ack"
 	"io"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -19,6 +20,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 // RequestParser processes input into messages.
@@ -127,6 +129,104 @@ func (f *textRequestParser) NumRequests() int {
 	return f.requestCount
 }
 
+// DefaultMaxMessageSize bounds the size of the messages read by binary
+// request parsers, unless FormatOptions give another. It mirrors the default
+// maximum message size of grpc-go.
+const DefaultMaxMessageSize = 4 * 1024 * 1024
+
+type binaryRequestParser struct {
+	r            *bufio.Reader
+	maxSize      uint64
+	requestCount int
+}
+
+// NewBinaryRequestParser returns a RequestParser that reads data in the
+// protobuf binary format from the given reader.
+//
+// Each message must be prefixed with its size, encoded as a varint. This is
+// the same length-delimited framing produced by writeDelimitedTo in other
+// protobuf runtimes, so multiple messages can simply be concatenated.
+//
+// If the given reader has no data, the returned parser will return io.EOF on
+// the very first call. Messages larger than DefaultMaxMessageSize are
+// rejected with a ResourceExhausted status error.
+func NewBinaryRequestParser(in io.Reader) RequestParser {
+	return newBinaryRequestParser(in, DefaultMaxMessageSize)
+}
+
+func newBinaryRequestParser(in io.Reader, maxSize int) RequestParser {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	return &binaryRequestParser{r: bufio.NewReader(in), maxSize: uint64(maxSize)}
+}
+
+func (f *binaryRequestParser) Next(m proto.Message) error {
+	size, err := binary.ReadUvarint(f.r)
+	if err != nil {
+		// a clean EOF can only happen before the first byte of the prefix
+		return err
+	}
+	// the size comes from the client, so it is checked before allocating
+	if size > f.maxSize {
+		return status.Errorf(codes.ResourceExhausted, "message of %d bytes exceeds the limit of %d bytes", size, f.maxSize)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(f.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	f.requestCount++
+
+	return proto.Unmarshal(b, m)
+}
+
+func (f *binaryRequestParser) NumRequests() int {
+	return f.requestCount
+}
+
+// singleBinaryRequestParser reads a single message in the protobuf binary
+// format, without a size prefix: the whole input is the message.
+type singleBinaryRequestParser struct {
+	r            io.Reader
+	maxSize      int64
+	requestCount int
+}
+
+func newSingleBinaryRequestParser(in io.Reader, maxSize int) RequestParser {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	return &singleBinaryRequestParser{r: in, maxSize: int64(maxSize)}
+}
+
+func (f *singleBinaryRequestParser) Next(m proto.Message) error {
+	if f.requestCount > 0 {
+		return io.EOF
+	}
+	b, err := io.ReadAll(io.LimitReader(f.r, f.maxSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) > f.maxSize {
+		return status.Errorf(codes.ResourceExhausted, "message exceeds the limit of %d bytes", f.maxSize)
+	}
+	if len(b) == 0 {
+		return io.EOF
+	}
+
+	f.requestCount++
+
+	return proto.Unmarshal(b, m)
+}
+
+func (f *singleBinaryRequestParser) NumRequests() int {
+	return f.requestCount
+}
+
 // Formatter translates messages into string representations.
 type Formatter func(proto.Message) (string, error)
 
@@ -143,6 +243,203 @@ func NewJSONFormatter(emitDefaults bool, resolver jsonpb.AnyResolver) Formatter
 	return marshaler.MarshalToString
 }
 
+// NewJSONFormatterWithOptions is like NewJSONFormatter but honors all of the
+// JSON-related fields of the given options.
+func NewJSONFormatterWithOptions(opts FormatOptions, resolver jsonpb.AnyResolver) Formatter {
+	marshaler := jsonpb.Marshaler{
+		EmitDefaults: opts.EmitJSONDefaultFields,
+		OrigName:     opts.UseProtoNames,
+		EnumsAsInts:  opts.EnumsAsInts,
+		AnyResolver:  resolver,
+	}
+	indent := "  "
+	if opts.CompactJSON {
+		indent = ""
+	}
+	if !opts.Int64AsNumbers {
+		marshaler.Indent = indent
+		return marshaler.MarshalToString
+	}
+	return func(m proto.Message) (string, error) {
+		str, err := marshaler.MarshalToString(m)
+		if err != nil {
+			return "", err
+		}
+		md, err := messageDescriptorOf(m)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		rw := int64Rewriter{dec: json.NewDecoder(strings.NewReader(str)), out: &buf}
+		rw.dec.UseNumber()
+		if err := rw.value(&jsonKind{msg: md}); err != nil {
+			return "", err
+		}
+		if indent == "" {
+			return buf.String(), nil
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, buf.Bytes(), "", indent); err != nil {
+			return "", err
+		}
+		return indented.String(), nil
+	}
+}
+
+func messageDescriptorOf(m proto.Message) (*desc.MessageDescriptor, error) {
+	if dm, ok := m.(*dynamic.Message); ok {
+		return dm.GetMessageDescriptor(), nil
+	}
+	return desc.LoadMessageDescriptorForMessage(m)
+}
+
+// jsonKind describes the JSON value expected at some point in a message's
+// JSON representation, as far as it matters for rendering 64-bit integers.
+// A nil kind means the value is copied as-is.
+type jsonKind struct {
+	// msg is set for objects whose keys are the fields of a message
+	msg *desc.MessageDescriptor
+	// mapValue is set for objects that represent map fields
+	mapValue *jsonKind
+	// elem is set for arrays that represent repeated fields
+	elem *jsonKind
+	// int64 is set for 64-bit integers, which are rendered as strings
+	int64 bool
+}
+
+func jsonKindOfField(fd *desc.FieldDescriptor) *jsonKind {
+	if fd.IsMap() {
+		return &jsonKind{mapValue: jsonKindOfValue(fd.GetMapValueType())}
+	}
+	k := jsonKindOfValue(fd)
+	if fd.IsRepeated() && k != nil {
+		return &jsonKind{elem: k}
+	}
+	return k
+}
+
+func jsonKindOfValue(fd *desc.FieldDescriptor) *jsonKind {
+	switch fd.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return &jsonKind{int64: true}
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		md := fd.GetMessageType()
+		switch md.GetFullyQualifiedName() {
+		case "google.protobuf.Int64Value", "google.protobuf.UInt64Value":
+			return &jsonKind{int64: true}
+		}
+		if strings.HasPrefix(md.GetFullyQualifiedName(), "google.protobuf.") {
+			// well-known types have special JSON representations
+			return nil
+		}
+		return &jsonKind{msg: md}
+	}
+	return nil
+}
+
+// int64Rewriter copies a JSON document, turning the quoted 64-bit integers
+// that the proto3 JSON mapping produces into plain numbers.
+type int64Rewriter struct {
+	dec *json.Decoder
+	out *bytes.Buffer
+}
+
+func (rw *int64Rewriter) value(k *jsonKind) error {
+	tok, err := rw.dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			var elem *jsonKind
+			if k != nil {
+				elem = k.elem
+			}
+			rw.out.WriteByte('[')
+			for i := 0; rw.dec.More(); i++ {
+				if i > 0 {
+					rw.out.WriteByte(',')
+				}
+				if err := rw.value(elem); err != nil {
+					return err
+				}
+			}
+			rw.out.WriteByte(']')
+		} else {
+			rw.out.WriteByte('{')
+			for i := 0; rw.dec.More(); i++ {
+				keyTok, err := rw.dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := keyTok.(string)
+				if i > 0 {
+					rw.out.WriteByte(',')
+				}
+				if err := rw.writeString(key); err != nil {
+					return err
+				}
+				rw.out.WriteByte(':')
+				var vk *jsonKind
+				if k != nil && k.msg != nil {
+					fd := k.msg.FindFieldByJSONName(key)
+					if fd == nil {
+						fd = k.msg.FindFieldByName(key)
+					}
+					if fd != nil {
+						vk = jsonKindOfField(fd)
+					}
+				} else if k != nil {
+					vk = k.mapValue
+				}
+				if err := rw.value(vk); err != nil {
+					return err
+				}
+			}
+			rw.out.WriteByte('}')
+		}
+		// consume closing delimiter
+		_, err := rw.dec.Token()
+		return err
+	case string:
+		if k != nil && k.int64 {
+			if _, err := strconv.ParseInt(t, 10, 64); err == nil {
+				rw.out.WriteString(t)
+				return nil
+			}
+			if _, err := strconv.ParseUint(t, 10, 64); err == nil {
+				rw.out.WriteString(t)
+				return nil
+			}
+		}
+		return rw.writeString(t)
+	case json.Number:
+		rw.out.WriteString(t.String())
+	case bool:
+		rw.out.WriteString(strconv.FormatBool(t))
+	case nil:
+		rw.out.WriteString("null")
+	}
+	return nil
+}
+
+func (rw *int64Rewriter) writeString(s string) error {
+	enc := json.NewEncoder(rw.out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	// remove the newline added by Encode
+	rw.out.Truncate(rw.out.Len() - 1)
+	return nil
+}
+
 // NewTextFormatter returns a formatter that returns strings in the protobuf
 // text format. If includeSeparator is true then, when invoked to format
 // multiple messages, all messages after the first one will be prefixed with the
@@ -198,7 +495,35 @@ func (tf *textFormatter) format(m proto.Message) (string, error) {
 	return str, nil
 }
 
-// Format of request data. The allowed values are 'json' or 'text'.
+// NewBinaryFormatter returns a formatter that returns messages in the protobuf
+// binary format. Each message is prefixed with its size, encoded as a varint,
+// so that the output of multiple messages can be concatenated and read back
+// with NewBinaryRequestParser.
+func NewBinaryFormatter() Formatter {
+	return formatBinary
+}
+
+// formatSingleBinary formats a message in the protobuf binary format without
+// a size prefix.
+func formatSingleBinary(m proto.Message) (string, error) {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func formatBinary(m proto.Message) (string, error) {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, 0, binary.MaxVarintLen64+len(b))
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return string(append(buf, b...)), nil
+}
+
+// Format of request data. The allowed values are 'json', 'text' or 'binary'.
 type Format string
 
 const (
@@ -213,6 +538,11 @@ const (
 	// If it does, it will be interpreted as a final, blank message after the
 	// separator.
 	FormatText = Format("text")
+
+	// FormatBinary specifies input data must be in the protobuf binary format.
+	// Each message must be prefixed with its size, encoded as a varint, so
+	// multiple request values can be concatenated.
+	FormatBinary = Format("binary")
 )
 
 // AnyResolverFromDescriptorSource returns an AnyResolver that will search for
@@ -375,29 +705,66 @@ type FormatOptions struct {
 	// FormatJSON only flag.
 	AllowUnknownFields bool
 
+	// UseProtoNames, when true, uses the field names from the proto source
+	// in the output instead of their lowerCamelCase JSON names.
+	// FormatJSON only flag.
+	UseProtoNames bool
+
+	// EnumsAsInts, when true, renders enum values as numbers instead of names.
+	// FormatJSON only flag.
+	EnumsAsInts bool
+
+	// Int64AsNumbers, when true, renders 64-bit integer fields as JSON numbers
+	// instead of the strings required by the proto3 JSON mapping.
+	// FormatJSON only flag.
+	Int64AsNumbers bool
+
+	// CompactJSON, when true, renders JSON without any indentation or newlines.
+	// FormatJSON only flag.
+	CompactJSON bool
+
 	// IncludeTextSeparator is true then, when invoked to format multiple messages,
 	// all messages after the first one will be prefixed with the
 	// ASCII 'Record Separator' character (0x1E).
 	// It might be useful when the output is piped to another grpcurl process.
 	// FormatText only flag.
 	IncludeTextSeparator bool
+
+	// MaxMessageSize bounds the size of request messages, or is
+	// DefaultMaxMessageSize if 0.
+	// FormatBinary only flag.
+	MaxMessageSize int
+
+	// SingleMessage, when true, reads the request as a single message that
+	// takes the whole input and formats responses without size prefixes,
+	// for the side of a method that does not stream.
+	// FormatBinary only flag.
+	SingleMessage bool
 }
 
 // RequestParserAndFormatter returns a request parser and formatter for the
 // given format. The given descriptor source may be used for parsing message
 // data (if needed by the format).
-// It accepts a set of options. The field EmitJSONDefaultFields and IncludeTextSeparator
-// are options for JSON and protobuf text formats, respectively. The AllowUnknownFields field
-// is a JSON-only format flag.
-// Requests will be parsed from the given in.
+// It accepts a set of options. IncludeTextSeparator is an option for the protobuf text
+// format; all other fields are JSON-only format flags.
+// Requests will be parsed from the given in. If in is nil, the returned parser
+// behaves as if given empty input; this is useful when only the formatter is needed.
 func RequestParserAndFormatter(format Format, descSource DescriptorSource, in io.Reader, opts FormatOptions) (RequestParser, Formatter, error) {
+	if in == nil {
+		in = bytes.NewReader(nil)
+	}
 	switch format {
 	case FormatJSON:
 		resolver := AnyResolverFromDescriptorSource(descSource)
 		unmarshaler := jsonpb.Unmarshaler{AnyResolver: resolver, AllowUnknownFields: opts.AllowUnknownFields}
-		return NewJSONRequestParserWithUnmarshaler(in, unmarshaler), NewJSONFormatter(opts.EmitJSONDefaultFields, anyResolverWithFallback{AnyResolver: resolver}), nil
+		return NewJSONRequestParserWithUnmarshaler(in, unmarshaler), NewJSONFormatterWithOptions(opts, anyResolverWithFallback{AnyResolver: resolver}), nil
 	case FormatText:
 		return NewTextRequestParser(in), NewTextFormatter(opts.IncludeTextSeparator), nil
+	case FormatBinary:
+		if opts.SingleMessage {
+			return newSingleBinaryRequestParser(in, opts.MaxMessageSize), formatSingleBinary, nil
+		}
+		return newBinaryRequestParser(in, opts.MaxMessageSize), NewBinaryFormatter(), nil
 	default:
 		return nil, nil, fmt.Errorf("unknown format: %s", format)
 	}
@@ -489,7 +856,7 @@ func (h *DefaultEventHandler) OnReceiveResponse(resp proto.Message) {
 	if respStr, err := h.Formatter(resp); err != nil {
 		fmt.Fprintf(h.Out, "Failed to format response message %d: %v\n", h.NumResponses, err)
 	} else {
-		fmt.Fprintf(h.Out, ack.ToSuccessResponse(respStr))
+		fmt.Fprintln(h.Out, respStr)
 	}
 }
 
@@ -2,6 +2,7 @@ package gateway
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"strings"
@@ -10,7 +11,10 @@ import (
 	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 we have to import this because it appears in exported API
 	"github.com/golang/protobuf/proto"  //lint:ignore SA1019 we have to import this because it appears in exported API
 	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
@@ -24,6 +28,10 @@ func TestRequestParser(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create message: %v", err)
 	}
+	messageAsBinary, err := formatBinary(msg)
+	if err != nil {
+		t.Fatalf("failed to encode message: %v", err)
+	}
 
 	testCases := []struct {
 		format         Format
@@ -65,6 +73,20 @@ func TestRequestParser(t *testing.T) {
 			input:          messageAsText + string(textSeparatorChar) + messageAsText + string(textSeparatorChar) + messageAsText,
 			expectedOutput: []proto.Message{msg, msg, msg},
 		},
+		{
+			format: FormatBinary,
+			input:  "",
+		},
+		{
+			format:         FormatBinary,
+			input:          messageAsBinary,
+			expectedOutput: []proto.Message{msg},
+		},
+		{
+			format:         FormatBinary,
+			input:          messageAsBinary + messageAsBinary + messageAsBinary,
+			expectedOutput: []proto.Message{msg, msg, msg},
+		},
 	}
 
 	for i, tc := range testCases {
@@ -94,6 +116,161 @@ func TestRequestParser(t *testing.T) {
 	}
 }
 
+func TestBinaryRequestParserTruncated(t *testing.T) {
+	msg, err := makeProto()
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	b, err := formatBinary(msg)
+	if err != nil {
+		t.Fatalf("failed to encode message: %v", err)
+	}
+
+	rf := NewBinaryRequestParser(strings.NewReader(b[:len(b)-1]))
+	var req structpb.Value
+	if err := rf.Next(&req); err != io.ErrUnexpectedEOF {
+		t.Errorf("expecting %v for truncated message, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestBinaryRequestParserTooLarge(t *testing.T) {
+	// the last is above the largest int, which make would panic on
+	for _, size := range []uint64{11, 1 << 40, 1<<63 + 1} {
+		in := append(binary.AppendUvarint(nil, size), make([]byte, 11)...)
+		rf, _, err := RequestParserAndFormatter(FormatBinary, nil, bytes.NewReader(in), FormatOptions{MaxMessageSize: 10})
+		if err != nil {
+			t.Fatalf("failed to create parser: %v", err)
+		}
+		var req structpb.Value
+		if err := rf.Next(&req); status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("expecting %v for a message of %d bytes, got %v", codes.ResourceExhausted, size, err)
+		}
+	}
+}
+
+func TestSingleBinaryMessage(t *testing.T) {
+	msg, err := makeProto()
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	opts := FormatOptions{SingleMessage: true}
+	_, formatter, err := RequestParserAndFormatter(FormatBinary, nil, nil, opts)
+	if err != nil {
+		t.Fatalf("failed to create formatter: %v", err)
+	}
+	b, err := formatter(msg)
+	if err != nil {
+		t.Fatalf("failed to encode message: %v", err)
+	}
+	if len(b) != proto.Size(msg) {
+		t.Errorf("expecting the message without a size prefix, got %q", b)
+	}
+
+	rf, _, err := RequestParserAndFormatter(FormatBinary, nil, strings.NewReader(b), opts)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	var req structpb.Value
+	if err := rf.Next(&req); err != nil || !proto.Equal(&req, msg) {
+		t.Errorf("expecting the message back, got %v %v", &req, err)
+	}
+	if err := rf.Next(&req); err != io.EOF || rf.NumRequests() != 1 {
+		t.Errorf("expecting a single message, got %v after %d", err, rf.NumRequests())
+	}
+
+	opts.MaxMessageSize = len(b) - 1
+	rf, _, _ = RequestParserAndFormatter(FormatBinary, nil, strings.NewReader(b), opts)
+	if err := rf.Next(&req); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expecting %v for a message over the limit, got %v", codes.ResourceExhausted, err)
+	}
+}
+
+func TestJSONFormatterOptions(t *testing.T) {
+	source, err := DescriptorSourceFromProtoSets("internal/testing/example.protoset")
+	if err != nil {
+		t.Fatalf("failed to create descriptor source: %v", err)
+	}
+	d, err := source.FindSymbol("TestRequest")
+	if err != nil {
+		t.Fatalf("failed to find message 'TestRequest': %v", err)
+	}
+	msg := dynamic.NewMessage(d.(*desc.MessageDescriptor))
+	if err := msg.UnmarshalJSON([]byte(`{"fileNames":["12345"],"extensions":[{"id":"9007199254740993"}]}`)); err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+
+	testCases := []struct {
+		opts     FormatOptions
+		expected string
+	}{
+		{
+			opts:     FormatOptions{CompactJSON: true},
+			expected: `{"fileNames":["12345"],"extensions":[{"id":"9007199254740993"}]}`,
+		},
+		{
+			opts:     FormatOptions{CompactJSON: true, UseProtoNames: true},
+			expected: `{"file_names":["12345"],"extensions":[{"id":"9007199254740993"}]}`,
+		},
+		{
+			opts:     FormatOptions{CompactJSON: true, Int64AsNumbers: true},
+			expected: `{"fileNames":["12345"],"extensions":[{"id":9007199254740993}]}`,
+		},
+		{
+			opts:     FormatOptions{CompactJSON: true, Int64AsNumbers: true, UseProtoNames: true},
+			expected: `{"file_names":["12345"],"extensions":[{"id":9007199254740993}]}`,
+		},
+		{
+			opts: FormatOptions{Int64AsNumbers: true},
+			expected: `{
+  "fileNames": [
+    "12345"
+  ],
+  "extensions": [
+    {
+      "id": 9007199254740993
+    }
+  ]
+}`,
+		},
+	}
+	for i, tc := range testCases {
+		_, formatter, err := RequestParserAndFormatter(FormatJSON, source, nil, tc.opts)
+		if err != nil {
+			t.Fatalf("failed to create formatter: %v", err)
+		}
+		str, err := formatter(msg)
+		if err != nil {
+			t.Errorf("#%d: failed to format message: %v", i+1, err)
+		} else if str != tc.expected {
+			t.Errorf("#%d: wrong output; expecting:\n%s\ngot:\n%s", i+1, tc.expected, str)
+		}
+	}
+}
+
+func TestJSONFormatterEnumsAsInts(t *testing.T) {
+	source, err := DescriptorSourceFromProtoSets("internal/testing/test.protoset")
+	if err != nil {
+		t.Fatalf("failed to create descriptor source: %v", err)
+	}
+	d, err := source.FindSymbol("testing.Payload")
+	if err != nil {
+		t.Fatalf("failed to find message 'testing.Payload': %v", err)
+	}
+	msg := dynamic.NewMessage(d.(*desc.MessageDescriptor))
+	if err := msg.UnmarshalJSON([]byte(`{"type":"UNCOMPRESSABLE"}`)); err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	_, formatter, err := RequestParserAndFormatter(FormatJSON, source, nil, FormatOptions{CompactJSON: true, EnumsAsInts: true})
+	if err != nil {
+		t.Fatalf("failed to create formatter: %v", err)
+	}
+	if str, err := formatter(msg); err != nil {
+		t.Errorf("failed to format message: %v", err)
+	} else if str != `{"type":1}` {
+		t.Errorf("wrong output: %s", str)
+	}
+}
+
 // Handler prints response data (and headers/trailers in verbose mode).
 // This verifies that we get the right output in both JSON and proto text modes.
 func TestHandler(t *testing.T) {
@@ -12,20 +12,11 @@ type Response struct {
 }
 
 func ToSuccessResponse(date string) string {
-	var dataAny any
-	err := json.Unmarshal([]byte(date), &dataAny)
-	if err != nil {
-		dataAny = date
-	}
-	marshal, err := json.Marshal(Response{
+	return marshal(Response{
 		Code: http.StatusOK,
 		Msg:  "ok",
-		Data: dataAny,
+		Data: jsonOrString(date),
 	})
-	if err != nil {
-		return ""
-	}
-	return string(marshal)
 }
 
 func ToFailResponse(date string) string {
@@ -0,0 +1,285 @@
+package ack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Envelope wraps the results of an RPC in the body of an HTTP response.
+type Envelope interface {
+	// Success wraps one formatted response message. For streaming methods it
+	// is called once per message.
+	Success(data string) string
+	// Failure wraps an error status, returning the HTTP status code to use
+	// along with the body.
+	Failure(stat *status.Status) (int, string)
+}
+
+var (
+	envelopesMu sync.RWMutex
+	envelopes   = map[string]Envelope{
+		"none":    Raw,
+		"raw":     Raw,
+		"ack":     Ack,
+		"jsonapi": JSONAPI,
+	}
+)
+
+// RegisterEnvelope makes an envelope available under the given name, for
+// selection from config. It replaces any envelope registered with that name.
+func RegisterEnvelope(name string, e Envelope) {
+	envelopesMu.Lock()
+	defer envelopesMu.Unlock()
+	envelopes[name] = e
+}
+
+// LookupEnvelope returns the envelope registered with the given name.
+func LookupEnvelope(name string) (Envelope, bool) {
+	envelopesMu.RLock()
+	defer envelopesMu.RUnlock()
+	e, ok := envelopes[name]
+	return e, ok
+}
+
+// HTTPStatus maps a gRPC status code to the closest HTTP status code.
+func HTTPStatus(code codes.Code) int {
+	if s, ok := httpStatus[code]; ok {
+		return s
+	}
+	return http.StatusOK
+}
+
+var httpStatus = map[codes.Code]int{
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// jsonOrString returns data as-is if it is valid JSON, so that it can be
+// embedded without losing number precision or field order, or as a string
+// otherwise.
+func jsonOrString(data string) any {
+	if json.Valid([]byte(data)) {
+		return json.RawMessage(data)
+	}
+	return data
+}
+
+func marshal(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// details returns the details of stat as a JSON array of google.protobuf.Any
+// values, or nil if there are none.
+func details(stat *status.Status) json.RawMessage {
+	if len(stat.Proto().GetDetails()) == 0 {
+		return nil
+	}
+	b, err := protojson.Marshal(&spb.Status{Details: stat.Proto().GetDetails()})
+	if err != nil {
+		return nil
+	}
+	var s struct {
+		Details json.RawMessage `json:"details"`
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil
+	}
+	return s.Details
+}
+
+// fieldViolations returns the field violations in the google.rpc.BadRequest
+// details of stat.
+func fieldViolations(stat *status.Status) []*errdetails.BadRequest_FieldViolation {
+	var violations []*errdetails.BadRequest_FieldViolation
+	for _, d := range stat.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			violations = append(violations, br.GetFieldViolations()...)
+		}
+	}
+	return violations
+}
+
+// Raw writes responses unchanged and errors as {"code":5,"message":"..."},
+// with a matching HTTP status code and any details of the status.
+var Raw Envelope = rawEnvelope{}
+
+type rawEnvelope struct{}
+
+func (rawEnvelope) Success(data string) string {
+	return data
+}
+
+func (rawEnvelope) Failure(stat *status.Status) (int, string) {
+	return HTTPStatus(stat.Code()), marshal(struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Details json.RawMessage `json:"details,omitempty"`
+	}{int(stat.Code()), stat.Message(), details(stat)})
+}
+
+// Ack wraps results in a Response, with HTTP status 200 except for invalid
+// requests: errors with google.rpc.BadRequest field violations get HTTP
+// status 400, in the response too, with the details as data.
+var Ack Envelope = ackEnvelope{}
+
+type ackEnvelope struct{}
+
+func (ackEnvelope) Success(data string) string {
+	return ToSuccessResponse(data)
+}
+
+func (ackEnvelope) Failure(stat *status.Status) (int, string) {
+	if d := details(stat); d != nil {
+		code := http.StatusOK
+		if stat.Code() == codes.InvalidArgument && len(fieldViolations(stat)) > 0 {
+			code = http.StatusBadRequest
+		}
+		return code, marshal(Response{
+			Code: code,
+			Msg:  stat.Message(),
+			Data: d,
+		})
+	}
+	return http.StatusOK, ToFailResponse(stat.Message())
+}
+
+// JSONAPI wraps responses as {"data":...} and errors as
+// {"errors":[{"status":"404","code":"NotFound","title":"..."}]}, in the style
+// of JSON:API. Field violations become one error each, pointing at the
+// offending field.
+var JSONAPI Envelope = jsonAPIEnvelope{}
+
+type jsonAPIEnvelope struct{}
+
+func (jsonAPIEnvelope) Success(data string) string {
+	return marshal(map[string]any{"data": jsonOrString(data)})
+}
+
+func (jsonAPIEnvelope) Failure(stat *status.Status) (int, string) {
+	type jsonAPISource struct {
+		Pointer string `json:"pointer"`
+	}
+	type jsonAPIError struct {
+		Status string         `json:"status"`
+		Code   string         `json:"code"`
+		Title  string         `json:"title"`
+		Detail string         `json:"detail,omitempty"`
+		Source *jsonAPISource `json:"source,omitempty"`
+	}
+	code := HTTPStatus(stat.Code())
+	violations := fieldViolations(stat)
+	if len(violations) == 0 {
+		return code, marshal(map[string][]jsonAPIError{"errors": {{
+			Status: strconv.Itoa(code),
+			Code:   stat.Code().String(),
+			Title:  stat.Message(),
+		}}})
+	}
+	errs := make([]jsonAPIError, len(violations))
+	for i, fv := range violations {
+		errs[i] = jsonAPIError{
+			Status: strconv.Itoa(code),
+			Code:   stat.Code().String(),
+			Title:  "invalid field",
+			Detail: fv.GetDescription(),
+			Source: &jsonAPISource{Pointer: "/" + strings.NewReplacer(".", "/", "[", "/", "]", "").Replace(fv.GetField())},
+		}
+	}
+	return code, marshal(map[string][]jsonAPIError{"errors": errs})
+}
+
+// TemplateData is the data available to the templates of a template
+// envelope.
+type TemplateData struct {
+	// Data is the formatted response message; empty for errors.
+	Data string
+	// Code and Status are the gRPC status code as a number and a name.
+	Code   int
+	Status string
+	// Message is the error message; empty for responses.
+	Message string
+	// HTTPStatus is the HTTP status code the response is written with.
+	HTTPStatus int
+	// Details is the JSON array of the error details, if any.
+	Details string
+}
+
+// NewTemplateEnvelope returns an envelope that renders responses and errors
+// with the given text/template sources, which are executed with TemplateData.
+// Data is inserted verbatim, so templates use {{.Data}} to embed JSON
+// responses and {{json .Data}} to embed other formats as a string; the json
+// function marshals any value.
+func NewTemplateEnvelope(success, failure string) (Envelope, error) {
+	funcs := template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+	s, err := template.New("success").Funcs(funcs).Parse(success)
+	if err != nil {
+		return nil, fmt.Errorf("invalid success template: %w", err)
+	}
+	f, err := template.New("failure").Funcs(funcs).Parse(failure)
+	if err != nil {
+		return nil, fmt.Errorf("invalid failure template: %w", err)
+	}
+	return &templateEnvelope{success: s, failure: f}, nil
+}
+
+type templateEnvelope struct {
+	success, failure *template.Template
+}
+
+func (e *templateEnvelope) Success(data string) string {
+	return e.execute(e.success, TemplateData{Data: data, Status: codes.OK.String(), HTTPStatus: http.StatusOK})
+}
+
+func (e *templateEnvelope) Failure(stat *status.Status) (int, string) {
+	code := HTTPStatus(stat.Code())
+	return code, e.execute(e.failure, TemplateData{
+		Code:       int(stat.Code()),
+		Status:     stat.Code().String(),
+		Message:    stat.Message(),
+		HTTPStatus: code,
+		Details:    string(details(stat)),
+	})
+}
+
+func (e *templateEnvelope) execute(t *template.Template, data TemplateData) string {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return ToFailResponse(err.Error())
+	}
+	return buf.String()
+}
@@ -0,0 +1,68 @@
+package ack
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEnvelopes(t *testing.T) {
+	tmpl, err := NewTemplateEnvelope(`{"ok":true,"result":{{.Data}}}`, `{"ok":false,"error":{{json .Message}},"status":{{json .Status}}}`)
+	if err != nil {
+		t.Fatalf("failed to parse templates: %v", err)
+	}
+	data := `{"id":"1","n":12345678901234567890}`
+	stat := status.New(codes.NotFound, "no such thing")
+
+	testCases := []struct {
+		name        string
+		env         Envelope
+		success     string
+		failureCode int
+		failure     string
+	}{
+		{"raw", Raw, data, http.StatusNotFound, `{"code":5,"message":"no such thing"}`},
+		{"ack", Ack, `{"code":200,"msg":"ok","data":` + data + `}`, http.StatusOK, `{"code":200,"msg":"no such thing","data":null}`},
+		{"jsonapi", JSONAPI, `{"data":` + data + `}`, http.StatusNotFound, `{"errors":[{"status":"404","code":"NotFound","title":"no such thing"}]}`},
+		{"template", tmpl, `{"ok":true,"result":` + data + `}`, http.StatusNotFound, `{"ok":false,"error":"no such thing","status":"NotFound"}`},
+	}
+	for _, tc := range testCases {
+		if s := tc.env.Success(data); s != tc.success {
+			t.Errorf("%s: expecting success %q, got %q", tc.name, tc.success, s)
+		}
+		if code, s := tc.env.Failure(stat); code != tc.failureCode || s != tc.failure {
+			t.Errorf("%s: expecting failure %d %q, got %d %q", tc.name, tc.failureCode, tc.failure, code, s)
+		}
+	}
+
+	if s := Ack.Success("not json"); s != `{"code":200,"msg":"ok","data":"not json"}` {
+		t.Errorf("ack: unexpected success for text data: %q", s)
+	}
+	if _, ok := LookupEnvelope("none"); !ok {
+		t.Error("expecting a built-in envelope named none")
+	}
+}
+
+func TestAckInvalidRequest(t *testing.T) {
+	stat, err := status.New(codes.InvalidArgument, "invalid request").WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "name", Description: "value is required"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, s := Ack.Failure(stat)
+	if code != http.StatusBadRequest || !strings.HasPrefix(s, `{"code":400,"msg":"invalid request","data":[{"@type":"type.googleapis.com/google.rpc.BadRequest"`) ||
+		!strings.Contains(s, `"field":"name"`) {
+		t.Errorf("expecting a bad request with the field violations, got %d %s", code, s)
+	}
+
+	// other details are still reported with 200
+	stat, _ = status.New(codes.NotFound, "no such thing").WithDetails(&errdetails.ResourceInfo{ResourceName: "thing"})
+	if code, _ := Ack.Failure(stat); code != http.StatusOK {
+		t.Errorf("expecting 200, got %d", code)
+	}
+}
@@ -0,0 +1,33 @@
+// Package cache stores the HTTP responses of read-only methods, so that
+// repeated calls are answered without reaching the backend.
+package cache
+
+import (
+	"net/http"
+	"time"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	// ETag is the entity tag of the body, quoted.
+	ETag    string    `json:"etag"`
+	Expires time.Time `json:"expires"`
+}
+
+// Fresh reports whether the entry has not expired at the given time.
+func (e *Entry) Fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+// Cache stores entries by key. The gateway keeps them in memory with an LRU,
+// and other implementations, such as clients of an external cache, can be
+// plugged in instead. Implementations must be safe for concurrent use and
+// should not keep entries past their expiry.
+type Cache interface {
+	// Get returns the entry stored for key, if any.
+	Get(key string) (*Entry, bool)
+	// Set stores the entry for key, replacing any previous one.
+	Set(key string, e *Entry)
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is a Cache in memory that holds up to a number of entries, evicting the
+// least recently used ones first. Expired entries are dropped when they are
+// looked up.
+type LRU struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List // of *item, most recently used first
+	items map[string]*list.Element
+	now   func() time.Time
+}
+
+type item struct {
+	key   string
+	entry *Entry
+}
+
+// NewLRU returns an LRU that holds up to maxEntries entries.
+func NewLRU(maxEntries int) *LRU {
+	return &LRU{max: maxEntries, order: list.New(), items: map[string]*list.Element{}, now: time.Now}
+}
+
+func (c *LRU) Get(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	it := el.Value.(*item)
+	if !it.entry.Fresh(c.now()) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return it.entry, true
+}
+
+func (c *LRU) Set(key string, e *Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*item).entry = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&item{key: key, entry: e})
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*item).key)
+	}
+}
+
+// Len returns the number of entries held, including expired ones that have
+// not been looked up since.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := NewLRU(2)
+	c.now = func() time.Time { return now }
+	entry := func(body string, ttl time.Duration) *Entry {
+		return &Entry{Body: []byte(body), Expires: now.Add(ttl)}
+	}
+
+	c.Set("a", entry("a", time.Minute))
+	c.Set("b", entry("b", time.Minute))
+	// a becomes the most recently used, so b is evicted for c
+	if e, ok := c.Get("a"); !ok || string(e.Body) != "a" {
+		t.Fatalf("expecting a, got %v %v", e, ok)
+	}
+	c.Set("c", entry("c", time.Second))
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expecting b to be evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("expecting 2 entries, got %d", c.Len())
+	}
+
+	c.Set("a", entry("a2", time.Minute))
+	if e, ok := c.Get("a"); !ok || string(e.Body) != "a2" {
+		t.Errorf("expecting a to be replaced, got %v %v", e, ok)
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok := c.Get("c"); ok {
+		t.Errorf("expecting c to expire")
+	}
+	if c.Len() != 1 {
+		t.Errorf("expecting expired entries to be dropped, got %d", c.Len())
+	}
+}
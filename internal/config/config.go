@@ -1,19 +1,26 @@
 package config
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"flag"
 	"fmt"
 	gateway "github.com/LCY2013/http-to-grpc-gateway"
 	"github.com/LCY2013/http-to-grpc-gateway/internal/indent"
 	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	"github.com/fsnotify/fsnotify"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // StatusCodeOffset To avoid confusion between program error codes and the gRPC resonse
@@ -81,7 +88,8 @@ var (
 		contents should include all such request messages concatenated together
 		(possibly delimited; see -format).`))
 	Format = Flags.String("format", "json", Prettify(`
-		The format of request data. The allowed values are 'json' or 'text'. For
+		The format of request data. The allowed values are 'json', 'text' or
+		'binary'. For
 		'json', the input data must be in JSON format. Multiple request values
 		may be concatenated (messages with a JSON representation other than
 		object must be separated by whitespace, such as a newline). For 'text',
@@ -89,7 +97,10 @@ var (
 		multiple request values must be separated by the "record separator"
 		ASCII character: 0x1E. The stream should not end in a record separator.
 		If it does, it will be interpreted as a final, blank message after the
-		separator.`))
+		separator. For 'binary', each message must be in the protobuf binary
+		format, prefixed with its size encoded as a varint. In server mode this
+		is only the default: the request format is chosen by the Content-Type
+		header and the response format by the Accept header.`))
 	AllowUnknownFields = Flags.Bool("allow-unknown-fields", false, Prettify(`
 		When true, the request contents, if 'json' format is used, allows
 		unknown fields to be present. They will be ignored when parsing
@@ -127,6 +138,12 @@ var (
 		Enable verbose output.`))
 	VeryVerbose = Flags.Bool("vv", false, Prettify(`
 		Enable very verbose output.`))
+	LogLevel = Flags.String("log-level", "", Prettify(`
+		The minimum level of the messages logged in server mode: debug, info,
+		warn or error. Overrides the level of the log section of the config.`))
+	LogFormat = Flags.String("log-format", "", Prettify(`
+		The format of the messages logged in server mode: json or console.
+		Overrides the format of the log section of the config.`))
 	ServerName = Flags.String("servername", "", Prettify(`
 		Override run name when validating TLS certificate. This flag is
 		ignored if -Plaintext or -insecure is used.
@@ -134,6 +151,10 @@ var (
 		an error to use both -authority and -servername (though this will be
 		permitted if they are both SetOp to the same value, to increase backwards
 		compatibility with earlier releases that allowed both to be SetOp).`))
+	ConfigFile = Flags.String("config", "", Prettify(`
+		The config file of server mode, in YAML, JSON or TOML format according
+		to its extension. Defaults to the GATEWAY_CONFIG environment variable,
+		or else to the first config file found in the usual places.`))
 	Reflection = OptionalBoolFlag{Val: true}
 )
 
@@ -241,10 +262,12 @@ func (cs CompositeSource) AllExtensionsForType(typeName string) ([]*desc.FieldDe
 
 func Usage() {
 	fmt.Fprintf(os.Stderr, `Usage:
-	%s [flags] [address] [list|describe] [symbol]
+	%s [flags] [address] [list|describe|openapi] [symbol]
+	%s [flags] server [local|http[,...]]
+	%s [flags] config validate [file] | config print-defaults
 
-The 'address' is only optional when used with 'list' or 'describe' and a
-protoset or proto flag is provided.
+The 'address' is only optional when used with 'list', 'describe' or 'openapi'
+and a protoset or proto flag is provided.
 
 If 'list' is indicated, the symbol (if present) should be a fully-qualified
 service name. If present, all methods of that service are listed. If not
@@ -254,7 +277,22 @@ If 'describe' is indicated, the descriptor for the given symbol is shown. The
 symbol should be a fully-qualified service, enum, or message name. If no symbol
 is given then the descriptors for all exposed or known services are shown.
 
-If neither verb is present, the symbol must be a fully-qualified method name in
+If 'openapi' is indicated, an OpenAPI 3 document describing how to invoke the
+methods through the gateway is printed. If a symbol is given, it should be a
+fully-qualified service name and only that service is included.
+
+If 'server' is indicated, the gateway serves HTTP on the address of its config.
+The backend of each method is found by the registries given as arguments, or
+else by those of server.registries, asked in order until one knows it: 'local'
+for the local registry, 'http' for the Addr header, e.g. 'server local,http'.
+Its config is taken from flags, GATEWAY_* environment variables, the config
+file and defaults, in that order of precedence.
+
+If 'config validate' is indicated, the config the server would use, or the
+given file, is checked and every problem found is reported. 'config
+print-defaults' prints the default config.
+
+If no verb is present, the symbol must be a fully-qualified method name in
 'service/method' or 'service.method' format. In this case, the request body will
 be used to invoke the named method. If no body is given but one is required
 (i.e. the method is unary or run-streaming), an empty instance of the
@@ -267,7 +305,7 @@ Unix variants, if a -unix=true flag is present, then the address must be the
 path to the domain socket.
 
 Available flags:
-`, os.Args[0])
+`, os.Args[0], os.Args[0], os.Args[0])
 	Flags.PrintDefaults()
 }
 
@@ -315,65 +353,547 @@ func (f *OptionalBoolFlag) IsBoolFlag() bool {
 }
 
 type Config struct {
+	// Server configures the gateway's own listener. Plain-text listeners
+	// accept both HTTP/1.1 and HTTP/2 (h2c); with a certificate and key the
+	// gateway serves TLS, negotiating HTTP/2 via ALPN.
+	Server struct {
+		Addr     string `json:"addr"`
+		CertFile string `json:"cert_file"`
+		KeyFile  string `json:"key_file"`
+		// DrainTimeout is how long in-flight calls are waited for on
+		// SIGTERM or SIGINT, in seconds, before they are cancelled.
+		DrainTimeout float64 `json:"drain_timeout"`
+		// ShutdownDelay is how long, in seconds, the gateway keeps serving
+		// once it reports not being ready on SIGTERM or SIGINT, for load
+		// balancers to stop sending it requests before it stops accepting
+		// them.
+		ShutdownDelay float64 `json:"shutdown_delay"`
+		// ReadHeaderTimeout and IdleTimeout bound, in seconds, the time to
+		// read the headers of a request and the time keep-alive connections
+		// are kept idle. They are unbounded when 0.
+		ReadHeaderTimeout float64 `json:"read_header_timeout"`
+		IdleTimeout       float64 `json:"idle_timeout"`
+		// Registries are the registries that find the backend of a
+		// method, asked in this order until one knows it: "local" for
+		// the local registry, "http" for the Addr header.
+		Registries []string `json:"registries"`
+	} `json:"server"`
+	// Backends configures the connections to backends.
+	Backends Backends `json:"backends"`
+	// Limits bounds what clients may send.
+	Limits struct {
+		// MaxRequestBytes bounds the size of request bodies, other than
+		// those of native gRPC calls; 0 means no limit.
+		MaxRequestBytes int64 `json:"max_request_bytes"`
+		// MaxProtosetBytes bounds the size of protosets uploaded through
+		// the admin API; 0 means no limit.
+		MaxProtosetBytes int64 `json:"max_protoset_bytes"`
+	} `json:"limits"`
 	LocalRegistry struct {
 		Registry map[string]string `json:"registry"`
+		// File, if set, is where the registry is saved when it is changed
+		// through the admin API. When the file exists, it replaces Registry
+		// on startup.
+		File string `json:"file"`
 	} `json:"local_registry"`
-	Log struct {
-		Filename string `json:"filename"`
-	} `json:"log"`
+	// SchemaRegistry loads the descriptors of particular services or
+	// backends from files, for backends that do not expose server
+	// reflection.
+	SchemaRegistry []Schema `json:"schema_registry"`
+	// JSON holds the default JSON options for all methods.
+	JSON JSONOptions `json:"json"`
+	// Envelope selects how responses and errors are wrapped for all methods.
+	Envelope EnvelopeConfig `json:"envelope"`
+	// Routes override the JSON options and envelope for particular services
+	// or methods.
+	Routes []Route `json:"routes"`
+	// Cache configures the cache of responses to read-only methods.
+	Cache Cache `json:"cache"`
+	// Coalesce configures the sharing of one backend call among identical
+	// calls made at the same time.
+	Coalesce Coalesce `json:"coalesce"`
+	// Admin configures the admin API under /_gateway/admin/, which is
+	// disabled unless a token is set. Requests must present the token in an
+	// "Authorization: Bearer" header.
+	Admin struct {
+		Token string `json:"token"`
+	} `json:"admin"`
+	// Health configures /readyz and the gRPC health service of the gateway.
+	Health struct {
+		// RequiredServices lists the services that must have a healthy
+		// endpoint for the gateway to be ready.
+		RequiredServices []string `json:"required_services"`
+		// GRPC serves grpc.health.v1.Health to native gRPC clients, where
+		// the empty service reports the readiness of the gateway.
+		GRPC bool `json:"grpc"`
+	} `json:"health"`
+	// Log configures the logs of the server, whose level can also be changed
+	// at runtime through the admin API.
+	Log logger.Config `json:"log"`
+	// Redact lists fully-qualified names of fields, e.g. "pkg.Message.field",
+	// whose values are kept out of the logs, in addition to fields with the
+	// debug_redact option.
+	Redact []string `json:"redact"`
 }
 
-var (
+// Backends configures the connections to backends. Times are in seconds.
+type Backends struct {
+	// ConnectTimeout bounds the time to connect to a backend.
+	ConnectTimeout float64 `json:"connect_timeout"`
+	// KeepaliveTime is how often idle connections are pinged, and how long
+	// pings are waited for; connections are not pinged when 0.
+	KeepaliveTime float64 `json:"keepalive_time"`
+	// CallTimeout bounds each call; 0 means no limit.
+	CallTimeout float64 `json:"call_timeout"`
+	// MaxMsgSize bounds the size of the messages received from backends,
+	// in bytes; 0 means the gRPC default of 4MB.
+	MaxMsgSize int `json:"max_msg_size"`
+	// UserAgent is prepended to the user agent of the gateway.
+	UserAgent string `json:"user_agent"`
+	// Headers are sent in "name: value" format with calls and reflection
+	// requests, RPCHeaders with calls only and ReflectHeaders with
+	// reflection requests only.
+	Headers        []string `json:"headers"`
+	RPCHeaders     []string `json:"rpc_headers"`
+	ReflectHeaders []string `json:"reflect_headers"`
+	// Reflection uses server reflection to describe backends. It defaults
+	// to true, unless protoset or proto files are given on the command
+	// line.
+	Reflection *bool `json:"reflection"`
+	// TLS, when enabled, connects to backends with TLS instead of plain
+	// text.
+	TLS struct {
+		Enabled bool `json:"enabled"`
+		// CACert is a file of trusted root certificates, the system ones by
+		// default. Insecure skips the verification of certificates.
+		CACert   string `json:"ca_cert"`
+		Insecure bool   `json:"insecure"`
+		// Cert and Key are the files of a client certificate.
+		Cert string `json:"cert"`
+		Key  string `json:"key"`
+		// ServerName overrides the name certificates are verified against,
+		// and the authority of calls.
+		ServerName string `json:"server_name"`
+	} `json:"tls"`
+}
+
+// JSONOptions controls how messages are rendered to and parsed from JSON in
+// server mode. Unset fields inherit from the enclosing level: command-line
+// flags, then the top-level json section, then matching routes, then query
+// parameters of the request itself.
+type JSONOptions struct {
+	// UseProtoNames uses the field names from the proto source instead of
+	// lowerCamelCase JSON names.
+	UseProtoNames *bool `json:"use_proto_names"`
+	// EnumsAsInts renders enum values as numbers instead of names.
+	EnumsAsInts *bool `json:"enums_as_ints"`
+	// Int64AsNumbers renders 64-bit integers as numbers instead of strings.
+	Int64AsNumbers *bool `json:"int64_as_numbers"`
+	// EmitDefaults includes fields that have their default value.
+	EmitDefaults *bool `json:"emit_defaults"`
+	// Pretty indents the output, which is compact by default.
+	Pretty *bool `json:"pretty"`
+	// Raw omits the envelope around responses and errors, regardless of the
+	// configured envelope.
+	Raw *bool `json:"raw"`
+	// DiscardUnknown ignores unknown fields in requests instead of failing.
+	DiscardUnknown *bool `json:"discard_unknown"`
+}
+
+// Route configures the handling of the methods it matches.
+type Route struct {
+	// Method is either a fully-qualified method name, in 'service/method' or
+	// 'service.method' format, or a service name to match all its methods.
+	Method string      `json:"method"`
+	JSON   JSONOptions `json:"json"`
+	// Envelope, if set, replaces the top-level envelope.
+	Envelope *EnvelopeConfig `json:"envelope"`
+	// Transform rewrites the messages of matching methods. Transforms of
+	// service routes apply before those of method routes.
+	Transform Transform `json:"transform"`
+	// Cache, if set, caches the responses of matching methods, which must
+	// be read-only, or keeps them from being cached.
+	Cache *RouteCache `json:"cache"`
+	// Coalesce, if set, turns the coalescing of identical calls to matching
+	// methods on or off.
+	Coalesce *bool `json:"coalesce"`
+}
+
+// Cache configures the cache of responses to unary methods that are read-only:
+// those with the idempotency_level = NO_SIDE_EFFECTS option, and those of
+// routes with a cache section. Responses are cached by method, request
+// message and the values of the Vary headers.
+type Cache struct {
+	// MaxEntries bounds the responses kept in memory; caching is disabled
+	// when it is 0.
+	MaxEntries int `json:"max_entries"`
+	// TTL is how long responses are kept, in seconds.
+	TTL float64 `json:"ttl"`
+	// Idempotent caches the methods with the NO_SIDE_EFFECTS idempotency
+	// level, unless a route says otherwise.
+	Idempotent bool `json:"idempotent"`
+	// Vary lists the request headers whose values tell responses apart,
+	// e.g. Authorization so that callers only see their own.
+	Vary []string `json:"vary"`
+}
+
+// Coalesce configures the coalescing of identical calls to unary methods that
+// are read-only: while a call is in flight, calls with the same request
+// message and values of the Vary headers wait for its response instead of
+// calling the backend. Failed calls are not shared, and their waiters call
+// the backend themselves.
+type Coalesce struct {
+	// Idempotent coalesces the calls to methods with the NO_SIDE_EFFECTS
+	// idempotency level, unless a route says otherwise.
+	Idempotent bool `json:"idempotent"`
+	// Vary lists the request headers whose values tell calls apart.
+	Vary []string `json:"vary"`
+}
+
+// RouteCache configures the cache for the methods of a route.
+type RouteCache struct {
+	// Disabled keeps the responses of the methods from being cached.
+	Disabled bool `json:"disabled"`
+	// TTL, if set, replaces the TTL of the cache section, in seconds.
+	TTL float64 `json:"ttl"`
+	// Vary adds to the headers of the cache section.
+	Vary []string `json:"vary"`
+}
+
+// Transform rewrites messages between their HTTP and gRPC forms.
+type Transform struct {
+	// Request is applied to requests after they are parsed and before they
+	// are validated and sent to the backend.
+	Request MessageTransform `json:"request"`
+	// Response is applied to responses before they are formatted.
+	Response MessageTransform `json:"response"`
+}
+
+// MessageTransform lists the rewrites of a message. Fields are given as paths
+// of field names separated by dots, e.g. "payload.body", which may only go
+// through singular message fields. Rewrites are applied in the order of the
+// fields below, except that Body is applied first to requests and last to
+// responses.
+type MessageTransform struct {
+	// Headers and Query set fields from HTTP headers and query parameters.
+	// They only apply to requests.
+	Headers []FieldSource `json:"headers"`
+	Query   []FieldSource `json:"query"`
+	// Rename moves the values of fields to other fields of the same type.
+	Rename []FieldRename `json:"rename"`
+	// Defaults sets fields that are not set.
+	Defaults []FieldValue `json:"defaults"`
+	// Drop clears fields, e.g. sensitive fields of responses.
+	Drop []string `json:"drop"`
+	// Body is the message field that makes up the HTTP body: requests are
+	// parsed into it and responses consist of it only.
+	Body string `json:"body"`
+}
+
+// FieldSource sets a field from the named header or query parameter.
+type FieldSource struct {
+	Name  string `json:"name"`
+	Field string `json:"field"`
+}
+
+type FieldRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// FieldValue sets a field to a value, given as it would be in JSON.
+type FieldValue struct {
+	Field string      `json:"field"`
+	Value interface{} `json:"value"`
+}
+
+// Schema maps services or a backend to the files that describe them.
+type Schema struct {
+	// Services and Backend select the methods the files are used for: those
+	// of the given services, or all those of the backend at the given
+	// address.
+	Services []string `json:"services"`
+	Backend  string   `json:"backend"`
+	// Protosets, or Protos with ImportPaths, are the files to load. Each may
+	// also be a directory, from which all protosets or proto files are
+	// loaded.
+	Protosets   []string `json:"protosets"`
+	Protos      []string `json:"protos"`
+	ImportPaths []string `json:"import_paths"`
+	// Reflection falls back to server reflection for symbols that are not
+	// found in the files.
+	Reflection bool `json:"reflection"`
+}
+
+// Validate reports whether the schema is complete.
+func (s *Schema) Validate() error {
+	if len(s.Services) == 0 && s.Backend == "" {
+		return fmt.Errorf("schema must name services or a backend")
+	}
+	if len(s.Protosets) > 0 && len(s.Protos) > 0 {
+		return fmt.Errorf("schema for %s: use either protosets or protos, but not both", s)
+	}
+	if len(s.Protosets) == 0 && len(s.Protos) == 0 {
+		return fmt.Errorf("schema for %s: no protosets or protos", s)
+	}
+	return nil
+}
+
+func (s *Schema) String() string {
+	if s.Backend != "" {
+		return s.Backend
+	}
+	return strings.Join(s.Services, ", ")
+}
+
+// EnvelopeConfig selects the envelope responses and errors are wrapped in.
+type EnvelopeConfig struct {
+	// Name is "none" (or "raw"), "ack", "jsonapi", "template" or the name of
+	// an envelope registered with ack.RegisterEnvelope. It defaults to "ack".
+	Name string `json:"name"`
+	// Success and Failure are the text/template sources of the "template"
+	// envelope, executed with an ack.TemplateData.
+	Success string `json:"success"`
+	Failure string `json:"failure"`
+}
+
+// Matches reports whether the route applies to the given method of the given
+// service.
+func (r *Route) Matches(service, method string) bool {
+	return r.Method == service || r.Method == service+"/"+method || r.Method == service+"."+method
+}
+
+// MatchRoutes returns the routes that apply to the given fully-qualified
+// method, with routes for the whole service before routes for the method so
+// that the latter take precedence when applied in order.
+func (c *Config) MatchRoutes(fullMethod string) []Route {
+	pos := strings.LastIndex(fullMethod, "/")
+	if pos < 0 {
+		pos = strings.LastIndex(fullMethod, ".")
+	}
+	if pos < 0 {
+		return nil
+	}
+	service, method := fullMethod[:pos], fullMethod[pos+1:]
+
+	var serviceRoutes, methodRoutes []Route
+	for _, r := range c.Routes {
+		if !r.Matches(service, method) {
+			continue
+		}
+		if r.Method == service {
+			serviceRoutes = append(serviceRoutes, r)
+		} else {
+			methodRoutes = append(methodRoutes, r)
+		}
+	}
+	return append(serviceRoutes, methodRoutes...)
+}
+
+// Info describes the active config.
+type Info struct {
+	// Version is incremented each time a config is loaded successfully,
+	// starting from 1; it is 0 if none has been.
+	Version int64 `json:"version"`
+	// File is the path of the config file and Checksum the SHA-256 of the
+	// contents it was loaded from.
+	File     string    `json:"file,omitempty"`
+	Checksum string    `json:"checksum,omitempty"`
+	LoadedAt time.Time `json:"loaded_at"`
+	// Error is why the last attempt to load the config failed, if it did,
+	// in which case the previous config is still active.
+	Error string `json:"error,omitempty"`
+}
+
+// loaded is a config along with its description.
+type loaded struct {
 	conf *Config
-	once sync.Once
+	info Info
+}
+
+var (
+	current  atomic.Pointer[loaded]
+	once     sync.Once
+	reloadMu sync.Mutex // serializes reloads
+
+	mu          sync.Mutex // guards lastErr and subscribers
+	lastErr     error
+	subscribers []func(old, new *Config)
 )
 
 // readInConfig 开始初始化整个配置
 func readInConfig() error {
+	path, err := findConfigFile()
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		viper.SetConfigFile(path)
+		viper.OnConfigChange(func(fsnotify.Event) {
+			if err := reload(path); err != nil {
+				logger.Errorf("Failed to reload config, keeping version %d: %v", Active().Version, err)
+			}
+		})
+		viper.WatchConfig()
+	}
+	return reload(path)
+}
+
+// findConfigFile returns the path of the config file, or "" if there is none,
+// in which case the config comes from the defaults, the environment and the
+// flags.
+func findConfigFile() (string, error) {
 	var (
 		pwd string
 		err error
 	)
 	if err = viper.BindPFlags(pflag.CommandLine); err != nil {
-		return err
+		return "", err
+	}
+	if file := configFile(); file != "" {
+		return file, nil
 	}
-	viper.SetConfigName(*pflag.String("config.name", "config", "config name"))        // name of config file (without extension)
-	viper.SetConfigType(*pflag.String("config.extension", "yml", "config extension")) // REQUIRED if the config file does not have the extension in the name
-	viper.AddConfigPath("/etc/http-grpc-gateway/")                                    // path to look for the config file in
-	viper.AddConfigPath("$HOME/.http-grpc-gateway")                                   // call multiple times to add many search paths
-	viper.AddConfigPath(".")                                                          // optionally look for config in the working directory
-	viper.AddConfigPath("./configs")                                                  // optionally look for config in the working directory
-	viper.AddConfigPath("../configs")                                                 // optionally look for config in the working directory
-	viper.AddConfigPath("../../configs")                                              // optionally look for config in the working directory
-	viper.AddConfigPath("../../../configs")                                           // optionally look for config in the working directory
+	viper.SetConfigName("config")                   // name of config file (without extension)
+	viper.AddConfigPath("/etc/http-grpc-gateway/")  // path to look for the config file in
+	viper.AddConfigPath("$HOME/.http-grpc-gateway") // call multiple times to add many search paths
+	viper.AddConfigPath(".")                        // optionally look for config in the working directory
+	viper.AddConfigPath("./configs")                // optionally look for config in the working directory
+	viper.AddConfigPath("../configs")               // optionally look for config in the working directory
+	viper.AddConfigPath("../../configs")            // optionally look for config in the working directory
+	viper.AddConfigPath("../../../configs")         // optionally look for config in the working directory
 	if pwd, err = os.Getwd(); err == nil {
 		viper.AddConfigPath(pwd) // optionally look for config in the working directory
 	}
 
 	err = viper.ReadInConfig() // Find and read the config file
-	if err != nil {            // Handle errors reading the config file
-		return err
+	if errors.As(err, &viper.ConfigFileNotFoundError{}) {
+		return "", nil
 	}
+	// errors in the file itself are reported when loading it
+	if path := viper.ConfigFileUsed(); path != "" {
+		return path, nil
+	}
+	return "", err
+}
 
-	viper.WatchConfig()
-
-	conf = &Config{}
+// Load builds and validates the config the way the server does, from the
+// given file, or the one the server would use if path is empty. It returns
+// the path of the file used, which is empty if there is none.
+func Load(path string) (*Config, string, error) {
+	if path == "" {
+		var err error
+		if path, err = findConfigFile(); err != nil {
+			return nil, "", err
+		}
+	}
+	c, _, err := load(path)
+	return c, path, err
+}
 
-	return viper.Unmarshal(conf, func(c *mapstructure.DecoderConfig) {
+// load builds the config from the defaults, overridden by the config file at
+// path, if any, then the environment, then the flags set on the command line,
+// and validates it. It also returns the checksum of the contents of the file.
+func load(path string) (*Config, string, error) {
+	v := viper.New()
+	var sum string
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		sum = fmt.Sprintf("%x", sha256.Sum256(b))
+		if ext := strings.TrimPrefix(filepath.Ext(path), "."); ext != "" {
+			v.SetConfigType(ext)
+		} else {
+			v.SetConfigType("yml")
+		}
+		if err = v.ReadConfig(bytes.NewReader(b)); err != nil {
+			return nil, "", fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	bindEnv(v)
+	c := Defaults()
+	if err := v.Unmarshal(c, func(c *mapstructure.DecoderConfig) {
 		c.TagName = "json"
-	})
+	}); err != nil {
+		return nil, "", err
+	}
+	applyFlags(c)
+	if err := c.Validate(); err != nil {
+		return nil, "", err
+	}
+	return c, sum, nil
+}
+
+// reload loads the config file at path and makes it the active config, then
+// notifies the subscribers. If the file is invalid, the active config is kept.
+// Loading a file that has not changed does nothing, since editors may write a
+// file several times when saving it.
+func reload(path string) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	c, sum, err := load(path)
+	mu.Lock()
+	lastErr = err
+	subs := subscribers
+	mu.Unlock()
+	if err != nil {
+		return err
+	}
+	old := current.Load()
+	if old != nil && old.info.Checksum == sum {
+		return nil
+	}
+	info := Info{File: path, Checksum: sum, LoadedAt: time.Now(), Version: 1}
+	if old == nil {
+		current.Store(&loaded{conf: c, info: info})
+		return nil
+	}
+	info.Version = old.info.Version + 1
+	current.Store(&loaded{conf: c, info: info})
+	logger.Infof("Reloaded config from %s, now at version %d", path, info.Version)
+	for _, fn := range subs {
+		fn(old.conf, c)
+	}
+	return nil
 }
 
 // Conf 直接获取conf
 func Conf() *Config {
-	if conf == nil {
-		once.Do(func() {
-			if err := readInConfig(); err != nil {
-				logger.Error(err)
-			}
-		})
+	once.Do(func() {
+		if err := readInConfig(); err != nil {
+			logger.Error(err)
+		}
+	})
+	if l := current.Load(); l != nil {
+		return l.conf
+	}
+	return nil
+}
+
+// Active describes the active config.
+func Active() Info {
+	Conf()
+	var info Info
+	if l := current.Load(); l != nil {
+		info = l.info
 	}
-	return conf
+	mu.Lock()
+	defer mu.Unlock()
+	if lastErr != nil {
+		info.Error = lastErr.Error()
+	}
+	return info
+}
+
+// Subscribe registers fn to be called with the previous and the new config
+// each time the config is reloaded, in the order of registration. Code that
+// reads the config with Conf on every use needs no subscription; fn is for
+// state built from the config.
+func Subscribe(fn func(old, new *Config)) {
+	mu.Lock()
+	defer mu.Unlock()
+	subscribers = append(subscribers, fn)
 }
 
 func LocalRegistry() map[string]string {
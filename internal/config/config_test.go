@@ -0,0 +1,139 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	for _, s := range []Schema{
+		{Protosets: []string{"a.protoset"}},
+		{Backend: "localhost:1"},
+		{Backend: "localhost:1", Protosets: []string{"a.protoset"}, Protos: []string{"a.proto"}},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("expecting %+v to be invalid", s)
+		}
+	}
+	s := Schema{Services: []string{"pkg.Svc"}, Protosets: []string{"a.protoset"}}
+	if err := s.Validate(); err != nil {
+		t.Errorf("expecting %+v to be valid, got %v", s, err)
+	}
+}
+
+func TestLoadExample(t *testing.T) {
+	c, _, err := load("../../config.yml")
+	if err != nil {
+		t.Fatalf("failed to load the example config: %v", err)
+	}
+	if addr := c.LocalRegistry.Registry["testing.testservice"]; addr != "127.0.0.1:8082" {
+		t.Errorf("wrong registry loaded: %v", c.LocalRegistry.Registry)
+	}
+}
+
+func TestReload(t *testing.T) {
+	// keeps Conf from looking for a config file
+	once.Do(func() {})
+	t.Cleanup(func() {
+		current.Store(nil)
+		lastErr = nil
+		subscribers = nil
+	})
+	path := filepath.Join(t.TempDir(), "config.yml")
+	write := func(s string) {
+		if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var notified []string
+	Subscribe(func(old, new *Config) {
+		notified = append(notified, old.Server.Addr+"->"+new.Server.Addr)
+	})
+
+	write("server:\n  addr: \":8080\"\n")
+	if err := reload(path); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if info := Active(); info.Version != 1 || info.Error != "" || current.Load().conf.Server.Addr != ":8080" {
+		t.Errorf("wrong config loaded: %+v", info)
+	}
+
+	// broken edits are rejected as a whole
+	write("server:\n  addr: \":9090\"\n  cert_file: cert.pem\nenvelope:\n  name: nope\n")
+	err := reload(path)
+	if err == nil || !strings.Contains(err.Error(), "key_file") || !strings.Contains(err.Error(), `unknown envelope "nope"`) {
+		t.Errorf("expecting both errors to be reported, got %v", err)
+	}
+	if info := Active(); info.Version != 1 || info.Error == "" || current.Load().conf.Server.Addr != ":8080" {
+		t.Errorf("expecting the previous config to be kept, got %+v", info)
+	}
+
+	write("server:\n  addr: \":9090\"\n")
+	if err := reload(path); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	// unchanged files are not reloaded again
+	if err := reload(path); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if info := Active(); info.Version != 2 || info.Error != "" || current.Load().conf.Server.Addr != ":9090" {
+		t.Errorf("wrong config reloaded: %+v", info)
+	}
+	if len(notified) != 1 || notified[0] != ":8080->:9090" {
+		t.Errorf("wrong notifications: %v", notified)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"server": {"addr": ":9090", "drain_timeout": 5}, "backends": {"call_timeout": 3}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvPrefix+"SERVER_ADDR", ":7070")
+	t.Setenv(EnvPrefix+"BACKENDS_CALL_TIMEOUT", "4")
+	t.Setenv(EnvPrefix+"BACKENDS_HEADERS", "a: 1,b: 2")
+	if err := Flags.Set("max-time", "6"); err != nil {
+		t.Fatal(err)
+	}
+	// flags cannot be unset, so max-time stays set for the later tests
+	t.Cleanup(func() { *MaxTime = 0 })
+
+	c, _, err := load(path)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if c.Server.Addr != ":7070" {
+		t.Errorf("expecting the environment to override the file, got %q", c.Server.Addr)
+	}
+	if c.Server.DrainTimeout != 5 {
+		t.Errorf("expecting the file to override the defaults, got %v", c.Server.DrainTimeout)
+	}
+	if c.Backends.ConnectTimeout != Defaults().Backends.ConnectTimeout {
+		t.Errorf("expecting the default connect timeout, got %v", c.Backends.ConnectTimeout)
+	}
+	if c.Backends.CallTimeout != 6 {
+		t.Errorf("expecting the flag to override the environment, got %v", c.Backends.CallTimeout)
+	}
+	if len(c.Backends.Headers) != 2 || c.Backends.Headers[1] != "b: 2" {
+		t.Errorf("wrong headers from the environment: %q", c.Backends.Headers)
+	}
+}
+
+func TestInsecureFlag(t *testing.T) {
+	// flags cannot be unset, so insecure stays set, to false, for the later
+	// tests
+	t.Cleanup(func() { _ = Flags.Set("insecure", "false") })
+	for _, v := range []bool{false, true} {
+		if err := Flags.Set("insecure", strconv.FormatBool(v)); err != nil {
+			t.Fatal(err)
+		}
+		c := Defaults()
+		applyFlags(c)
+		if c.Backends.TLS.Enabled != v || c.Backends.TLS.Insecure != v {
+			t.Errorf("-insecure=%v: expecting TLS enabled and insecure to be %v, got %+v", v, v, c.Backends.TLS)
+		}
+	}
+}
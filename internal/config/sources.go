@@ -0,0 +1,129 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix starts the names of the environment variables that override the
+// config file. The rest of a name is the path of the setting in upper case
+// with underscores, e.g. GATEWAY_SERVER_ADDR for server.addr. Lists are given
+// as comma-separated values. Settings in lists of sections, such as routes,
+// and in maps, such as the local registry, can only be set in the file.
+//
+// The config file itself is the one given with the -config flag or the
+// GATEWAY_CONFIG variable, or else the first config.yml, config.yaml,
+// config.json or config.toml found in /etc/http-grpc-gateway,
+// $HOME/.http-grpc-gateway, the working directory and configs directories.
+//
+// Settings are taken, in order of precedence, from the flags set on the
+// command line, the environment, the config file, and the defaults.
+const EnvPrefix = "GATEWAY_"
+
+// Defaults returns the config used for settings that are not set otherwise.
+func Defaults() *Config {
+	c := &Config{}
+	c.Server.Addr = ":8080"
+	c.Server.DrainTimeout = 15
+	c.Server.ShutdownDelay = 5
+	c.Server.Registries = []string{"local"}
+	c.Backends.ConnectTimeout = 10
+	c.Limits.MaxProtosetBytes = 64 << 20
+	c.Cache.TTL = 30
+	c.Cache.Idempotent = true
+	c.Cache.Vary = []string{"Authorization"}
+	c.Coalesce.Vary = []string{"Authorization"}
+	c.Envelope.Name = "ack"
+	c.Log.Level = "debug"
+	c.Log.MaxSize = 521
+	c.Log.MaxAge = 7
+	return c
+}
+
+// configFile returns the config file given with the -config flag or the
+// GATEWAY_CONFIG variable, if any.
+func configFile() string {
+	if *ConfigFile != "" {
+		return *ConfigFile
+	}
+	return os.Getenv(EnvPrefix + "CONFIG")
+}
+
+// bindEnv binds the settings of the config to their environment variables.
+func bindEnv(v *viper.Viper) {
+	var bind func(t reflect.Type, prefix string)
+	bind = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			key := prefix + name
+			switch ft := f.Type; {
+			case ft.Kind() == reflect.Struct:
+				bind(ft, key+".")
+			case ft.Kind() == reflect.Map, ft.Kind() == reflect.Interface,
+				ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.String,
+				ft.Kind() == reflect.Pointer && ft.Elem().Kind() == reflect.Struct:
+			default:
+				_ = v.BindEnv(key, EnvPrefix+strings.ToUpper(strings.ReplaceAll(key, ".", "_")))
+			}
+		}
+	}
+	bind(reflect.TypeOf(Config{}), "")
+}
+
+// applyFlags overrides the config with the flags that were set on the command
+// line.
+func applyFlags(c *Config) {
+	Flags.Visit(func(f *flag.Flag) {
+		b := &c.Backends
+		switch f.Name {
+		case "connect-timeout":
+			b.ConnectTimeout = *ConnectTimeout
+		case "keepalive-time":
+			b.KeepaliveTime = *KeepaliveTime
+		case "max-time":
+			b.CallTimeout = *MaxTime
+		case "max-msg-sz":
+			b.MaxMsgSize = *MaxMsgSz
+		case "user-agent":
+			b.UserAgent = *UserAgent
+		case "H":
+			b.Headers = AddlHeaders
+		case "rpc-header":
+			b.RPCHeaders = RpcHeaders
+		case "reflect-header":
+			b.ReflectHeaders = ReflHeaders
+		case "use-Reflection":
+			b.Reflection = &Reflection.Val
+		case "plaintext":
+			b.TLS.Enabled = !*Plaintext
+		case "insecure":
+			// -insecure=false leaves whether TLS is used to the rest
+			b.TLS.Insecure = *Insecure
+			if *Insecure {
+				b.TLS.Enabled = true
+			}
+		case "cacert":
+			b.TLS.Enabled, b.TLS.CACert = true, *Cacert
+		case "cert":
+			b.TLS.Enabled, b.TLS.Cert = true, *Cert
+		case "key":
+			b.TLS.Enabled, b.TLS.Key = true, *Key
+		case "servername":
+			b.TLS.ServerName = *ServerName
+		case "authority":
+			b.TLS.ServerName = *Authority
+		case "log-level":
+			c.Log.Level = *LogLevel
+		case "log-format":
+			c.Log.Format = *LogFormat
+		}
+	})
+}
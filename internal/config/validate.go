@@ -0,0 +1,148 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/ack"
+)
+
+// Validate reports everything that is wrong with the config, so that a
+// broken config is rejected as a whole rather than failing at request time.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	if (c.Server.CertFile == "") != (c.Server.KeyFile == "") {
+		add("server: cert_file and key_file must be set together")
+	}
+	if err := ValidateRegistries(c.Server.Registries); err != nil {
+		add("server: registries: %v", err)
+	}
+	for _, v := range []struct {
+		name  string
+		value float64
+	}{
+		{"server: drain_timeout", c.Server.DrainTimeout},
+		{"server: shutdown_delay", c.Server.ShutdownDelay},
+		{"server: read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server: idle_timeout", c.Server.IdleTimeout},
+		{"backends: connect_timeout", c.Backends.ConnectTimeout},
+		{"backends: keepalive_time", c.Backends.KeepaliveTime},
+		{"backends: call_timeout", c.Backends.CallTimeout},
+		{"backends: max_msg_size", float64(c.Backends.MaxMsgSize)},
+		{"limits: max_request_bytes", float64(c.Limits.MaxRequestBytes)},
+		{"limits: max_protoset_bytes", float64(c.Limits.MaxProtosetBytes)},
+		{"cache: max_entries", float64(c.Cache.MaxEntries)},
+		{"cache: ttl", c.Cache.TTL},
+	} {
+		if v.value < 0 {
+			add("%s must not be negative", v.name)
+		}
+	}
+	if tls := c.Backends.TLS; (tls.Cert == "") != (tls.Key == "") {
+		add("backends: tls: cert and key must be set together")
+	}
+	for _, h := range append(append(append([]string(nil), c.Backends.Headers...), c.Backends.RPCHeaders...), c.Backends.ReflectHeaders...) {
+		if name, _, ok := strings.Cut(h, ":"); !ok || strings.TrimSpace(name) == "" {
+			add("backends: header %q must be in 'name: value' format", h)
+		}
+	}
+	for svc, addr := range c.LocalRegistry.Registry {
+		if err := ValidateEndpoint(addr); err != nil {
+			add("local_registry: %s: %v", svc, err)
+		}
+	}
+	for i := range c.SchemaRegistry {
+		if err := c.SchemaRegistry[i].Validate(); err != nil {
+			add("schema_registry: %v", err)
+		}
+	}
+	if err := c.Envelope.Validate(); err != nil {
+		add("envelope: %v", err)
+	}
+	for _, r := range c.Routes {
+		if r.Method == "" {
+			add("routes: route without a method")
+			continue
+		}
+		if r.Envelope != nil {
+			if err := r.Envelope.Validate(); err != nil {
+				add("routes: %s: envelope: %v", r.Method, err)
+			}
+		}
+		if r.Cache != nil && r.Cache.TTL < 0 {
+			add("routes: %s: cache: ttl must not be negative", r.Method)
+		}
+	}
+	if err := c.Log.Validate(); err != nil {
+		add("log: %v", err)
+	}
+	for _, f := range c.Redact {
+		if f == "" {
+			add("redact: empty field name")
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate reports whether the envelope exists and, for templates, whether
+// they parse.
+func (e *EnvelopeConfig) Validate() error {
+	switch e.Name {
+	case "":
+		return nil
+	case "template":
+		_, err := ack.NewTemplateEnvelope(e.Success, e.Failure)
+		return err
+	}
+	if _, ok := ack.LookupEnvelope(e.Name); !ok {
+		return fmt.Errorf("unknown envelope %q", e.Name)
+	}
+	return nil
+}
+
+// RegistryTypes are the registries server mode can find backends with.
+var RegistryTypes = []string{"local", "http"}
+
+// ValidateRegistries reports whether registries names known registries, each
+// at most once.
+func ValidateRegistries(registries []string) error {
+	if len(registries) == 0 {
+		return errors.New("no registry given")
+	}
+	seen := map[string]bool{}
+	for _, r := range registries {
+		known := false
+		for _, t := range RegistryTypes {
+			known = known || r == t
+		}
+		switch {
+		case !known:
+			return fmt.Errorf("unknown registry %q, expecting %s", r, strings.Join(RegistryTypes, " or "))
+		case seen[r]:
+			return fmt.Errorf("registry %q given more than once", r)
+		}
+		seen[r] = true
+	}
+	return nil
+}
+
+// ValidateEndpoint reports whether addr is a valid backend address: host:port,
+// or a path when connecting through unix sockets.
+func ValidateEndpoint(addr string) error {
+	if addr == "" {
+		return errors.New("missing endpoint")
+	}
+	if IsUnixSocket != nil && IsUnixSocket() {
+		return nil
+	}
+	if host, port, err := net.SplitHostPort(addr); err != nil || host == "" || port == "" {
+		return fmt.Errorf("endpoint %q must be host:port", addr)
+	}
+	return nil
+}
@@ -1,4 +1,4 @@
-package indent
+package indent_test
 
 import (
 	"bytes"
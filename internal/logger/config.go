@@ -0,0 +1,218 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Config configures logging. The zero Config logs everything to stderr in
+// console format; files are only written when Filename is set.
+type Config struct {
+	// Level is the minimum level logged: debug (the default), info, warn or
+	// error. It can be changed at runtime with LevelHandler.
+	Level string `json:"level"`
+	// Format is either json or console. By default, stdout and stderr use
+	// the console format and files use JSON.
+	Format string `json:"format"`
+	// Outputs lists where messages go: stdout, stderr and files, which are
+	// the info and error files named after Filename. It defaults to stderr,
+	// and files if Filename is set.
+	Outputs []string `json:"outputs"`
+	// Filename is the path of the log files without their "-info.log" and
+	// "-error.log" suffixes, e.g. ./logs/gateway. The files output needs it.
+	Filename string `json:"filename"`
+	// MaxSize is the size in megabytes at which files are rotated, 521 by
+	// default; MaxAge is the number of days rotated files are kept, 7 by
+	// default; MaxBackups is the number of rotated files kept, all of them
+	// by default. Rotated files are compressed unless Compress is false.
+	MaxSize    int   `json:"max_size"`
+	MaxAge     int   `json:"max_age"`
+	MaxBackups int   `json:"max_backups"`
+	Compress   *bool `json:"compress"`
+	// Sampling, if set, limits the messages logged per second with the same
+	// level and message: the first Initial are logged, then every
+	// Thereafter-th.
+	Sampling *struct {
+		Initial    int `json:"initial"`
+		Thereafter int `json:"thereafter"`
+	} `json:"sampling"`
+}
+
+var (
+	// level is shared by all configurations, so that changes made at runtime
+	// survive until the next call to Configure
+	level = zap.NewAtomicLevelAt(zap.DebugLevel)
+
+	mu    sync.Mutex
+	files []*lumberjack.Logger
+)
+
+// Validate reports whether c can be used to configure logging.
+func (c Config) Validate() error {
+	var lvl zapcore.Level
+	if c.Level != "" {
+		if err := lvl.UnmarshalText([]byte(c.Level)); err != nil {
+			return fmt.Errorf("invalid log level %q", c.Level)
+		}
+	}
+	switch c.Format {
+	case "", "json", "console":
+	default:
+		return fmt.Errorf("invalid log format %q: must be json or console", c.Format)
+	}
+	for _, out := range c.Outputs {
+		switch strings.ToLower(out) {
+		case "stdout", "stderr":
+		case "files":
+			if c.Filename == "" {
+				return fmt.Errorf("invalid log output %q: needs a filename", out)
+			}
+		default:
+			return fmt.Errorf("invalid log output %q: must be stdout, stderr or files", out)
+		}
+	}
+	return nil
+}
+
+// Configure replaces the loggers with ones built from c. Messages logged
+// concurrently go to either.
+func Configure(c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	lvl := zap.DebugLevel
+	if c.Level != "" {
+		_ = lvl.UnmarshalText([]byte(c.Level))
+	}
+	outputs := c.Outputs
+	if len(outputs) == 0 {
+		outputs = []string{"stderr"}
+		if c.Filename != "" {
+			outputs = append(outputs, "files")
+		}
+	}
+
+	var cores []zapcore.Core
+	var opened []*lumberjack.Logger
+	for _, out := range outputs {
+		switch strings.ToLower(out) {
+		case "stdout":
+			cores = append(cores, zapcore.NewCore(encoder(c.Format, "console"), zapcore.Lock(os.Stdout), level))
+		case "stderr":
+			cores = append(cores, zapcore.NewCore(encoder(c.Format, "console"), zapcore.Lock(os.Stderr), level))
+		case "files":
+			info, errs := rotated(c, c.Filename+"-info.log"), rotated(c, c.Filename+"-error.log")
+			opened = append(opened, info, errs)
+			cores = append(cores,
+				zapcore.NewCore(encoder(c.Format, "json"), zapcore.AddSync(info), atLeast(zap.InfoLevel)),
+				zapcore.NewCore(encoder(c.Format, "json"), zapcore.AddSync(errs), atLeast(zap.ErrorLevel)))
+		}
+	}
+	core := zapcore.NewTee(cores...)
+	if s := c.Sampling; s != nil {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, s.Initial, s.Thereafter)
+	}
+
+	base := zap.New(core, zap.AddCaller())
+	current.Store(&loggers{
+		sugar: base.WithOptions(zap.AddCallerSkip(1)).Sugar(),
+		ctx:   base.Sugar(),
+	})
+	level.SetLevel(lvl)
+
+	mu.Lock()
+	closing := files
+	files = opened
+	mu.Unlock()
+	for _, f := range closing {
+		_ = f.Close()
+	}
+	return nil
+}
+
+func encoder(format, def string) zapcore.Encoder {
+	if format == "" {
+		format = def
+	}
+	if format == "json" {
+		conf := zap.NewProductionEncoderConfig()
+		conf.EncodeTime = zapcore.ISO8601TimeEncoder
+		return zapcore.NewJSONEncoder(conf)
+	}
+	return zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
+		MessageKey:  "msg",
+		LevelKey:    "level",
+		EncodeLevel: zapcore.CapitalLevelEncoder,
+		TimeKey:     "ts",
+		EncodeTime: func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+			enc.AppendString(t.Format("2006-01-02 15:04:05"))
+		},
+		CallerKey:    "file",
+		EncodeCaller: zapcore.ShortCallerEncoder,
+		EncodeDuration: func(d time.Duration, enc zapcore.PrimitiveArrayEncoder) {
+			enc.AppendInt64(int64(d) / 1000000)
+		},
+	})
+}
+
+// atLeast enables the given level and above, as long as the configured level
+// allows them.
+func atLeast(min zapcore.Level) zap.LevelEnablerFunc {
+	return func(lvl zapcore.Level) bool {
+		return lvl >= min && level.Enabled(lvl)
+	}
+}
+
+func rotated(c Config, filename string) *lumberjack.Logger {
+	l := &lumberjack.Logger{
+		Filename:   filename,
+		MaxSize:    c.MaxSize,
+		MaxAge:     c.MaxAge,
+		MaxBackups: c.MaxBackups,
+		LocalTime:  true,
+		Compress:   c.Compress == nil || *c.Compress,
+	}
+	if l.MaxSize == 0 {
+		l.MaxSize = 521
+	}
+	if l.MaxAge == 0 {
+		l.MaxAge = 7
+	}
+	return l
+}
+
+// LevelHandler serves the current log level as JSON, e.g. {"level":"info"},
+// on GET and changes it on PUT with a JSON body of the same form, or a form
+// with a level field.
+func LevelHandler() http.Handler {
+	return level
+}
+
+type fieldsKey struct{}
+
+// WithFields returns a context whose logger, as returned by Ctx, adds the
+// given key-value pairs to every message.
+func WithFields(ctx context.Context, keysAndValues ...interface{}) context.Context {
+	fields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	fields = append(fields[:len(fields):len(fields)], keysAndValues...)
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// Ctx returns a logger that adds the fields of ctx, set by WithFields, to
+// every message.
+func Ctx(ctx context.Context) *zap.SugaredLogger {
+	l := current.Load().ctx
+	if fields, _ := ctx.Value(fieldsKey{}).([]interface{}); len(fields) > 0 {
+		return l.With(fields...)
+	}
+	return l
+}
@@ -0,0 +1,79 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestConfigure(t *testing.T) {
+	for _, c := range []Config{
+		{Level: "loud"},
+		{Format: "xml"},
+		{Outputs: []string{"syslog"}},
+		{Outputs: []string{"files"}},
+	} {
+		if err := Configure(c); err == nil {
+			t.Errorf("expecting error for %+v", c)
+		}
+	}
+
+	// the defaults write no files
+	if err := Configure(Config{}); err != nil {
+		t.Fatalf("failed to configure: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expecting no files, got %d", len(files))
+	}
+
+	filename := filepath.Join(t.TempDir(), "test")
+	t.Cleanup(func() { _ = Configure(Config{}) })
+	if err := Configure(Config{Level: "info", Outputs: []string{"files"}, Filename: filename}); err != nil {
+		t.Fatalf("failed to configure: %v", err)
+	}
+	if level.Level() != zap.InfoLevel {
+		t.Errorf("expecting level info, got %s", level.Level())
+	}
+
+	ctx := WithFields(context.Background(), "request_id", "abc")
+	Ctx(ctx).Info("with fields")
+	Debug("not logged")
+	Error("failed")
+
+	b, err := os.ReadFile(filename + "-info.log")
+	if err != nil {
+		t.Fatalf("failed to read info log: %v", err)
+	}
+	info := string(b)
+	if !strings.Contains(info, `"msg":"with fields","request_id":"abc"`) {
+		t.Errorf("expecting message with the fields of the context, got %s", info)
+	}
+	if strings.Contains(info, "not logged") || !strings.Contains(info, `"msg":"failed"`) {
+		t.Errorf("wrong messages in info log: %s", info)
+	}
+	if b, _ := os.ReadFile(filename + "-error.log"); strings.Count(string(b), "\n") != 1 {
+		t.Errorf("expecting only the error in the error log, got %s", b)
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	t.Cleanup(func() { level.SetLevel(zap.DebugLevel) })
+
+	w := httptest.NewRecorder()
+	LevelHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"level":"warn"}`)))
+	if w.Code != http.StatusOK || level.Level() != zap.WarnLevel {
+		t.Errorf("expecting level warn, got %d %s", w.Code, level.Level())
+	}
+
+	w = httptest.NewRecorder()
+	LevelHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if body := strings.TrimSpace(w.Body.String()); body != `{"level":"warn"}` {
+		t.Errorf("unexpected level: %s", body)
+	}
+}
@@ -1,178 +1,105 @@
 package logger
 
 import (
-	"fmt"
+	"sync/atomic"
+
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
-	"gopkg.in/natefinch/lumberjack.v2"
-	"io"
-	"os"
-	"time"
 )
 
-var (
-	logger *zap.SugaredLogger
-)
+// loggers holds the loggers built by Configure: sugar is used by the functions
+// of this package, which it skips when reporting callers, and ctx by Ctx.
+type loggers struct {
+	sugar *zap.SugaredLogger
+	ctx   *zap.SugaredLogger
+}
+
+var current atomic.Pointer[loggers]
 
 func init() {
-	// 日志文件名称
-	fileName := "gateway"
-
-	/*syncWriter := zapcore.AddSync(
-		&lumberjack.Logger{
-			Filename:   fileName, // 文件名称
-			MaxSize:    521,      // MB
-			MaxAge:     7,        // date
-			MaxBackups: 0,        // 最大备份
-			LocalTime:  true,
-			Compress:   true, // 是否启用压缩
-		},
-	)*/
-	// 编码
-	fileEncoder := zap.NewProductionEncoderConfig()
-	// 时间格式
-	fileEncoder.EncodeTime = zapcore.ISO8601TimeEncoder
-
-	// 设置一些基本日志格式 具体含义还比较好理解，直接看zap源码也不难懂
-	consulEncoder := zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
-		MessageKey:  "msg",
-		LevelKey:    "level",
-		EncodeLevel: zapcore.CapitalLevelEncoder,
-		TimeKey:     "ts",
-		EncodeTime: func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
-			enc.AppendString(t.Format("2006-01-02 15:04:05"))
-		},
-		CallerKey:    "file",
-		EncodeCaller: zapcore.ShortCallerEncoder,
-		EncodeDuration: func(d time.Duration, enc zapcore.PrimitiveArrayEncoder) {
-			enc.AppendInt64(int64(d) / 1000000)
-		},
-	})
-
-	// 实现两个判断日志等级的interface
-	infoLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return lvl >= zapcore.InfoLevel
-	})
-
-	errorLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return lvl >= zapcore.ErrorLevel
-	})
-
-	// 获取 info、error日志文件的io.Writer 抽象 getWriter() 在下方实现
-	infoWriter := getWriter(fmt.Sprintf("./logs/%s-info.log", fileName))
-	errorWriter := getWriter(fmt.Sprintf("./logs/%s-error.log", fileName))
-
-	core := zapcore.NewTee(
-		zapcore.NewCore(
-			consulEncoder,
-			zapcore.AddSync(os.Stdout),
-			zapcore.DebugLevel), //打印到控制台
-		zapcore.NewCore(
-			// 编码器
-			zapcore.NewJSONEncoder(fileEncoder),
-			zapcore.AddSync(infoWriter),
-			infoLevel),
-		zapcore.NewCore(
-			// 编码器
-			zapcore.NewJSONEncoder(fileEncoder),
-			zapcore.AddSync(errorWriter),
-			errorLevel),
-	)
-
-	log := zap.New(
-		core,
-		zap.AddCaller(),
-		zap.AddCallerSkip(1),
-	)
-
-	logger = log.Sugar()
-}
-
-func getWriter(filename string) io.Writer {
-	// 生成rotatelogs的Logger 实际生成的文件名 info.log.YYmmddHH
-	// info.log是指向最新日志的链接
-	// 保存7天内的日志，每1小时(整点)分割一次日志
-	return zapcore.AddSync(
-		&lumberjack.Logger{
-			Filename:   filename, // 文件名称
-			MaxSize:    521,      // MB
-			MaxAge:     7,        // date
-			MaxBackups: 0,        // 最大备份
-			LocalTime:  true,
-			Compress:   true, // 是否启用压缩
-		},
-	)
+	// the defaults, until the config is read
+	if err := Configure(Config{}); err != nil {
+		panic(err)
+	}
+}
+
+func logger() *zap.SugaredLogger {
+	return current.Load().sugar
+}
+
+// Sync flushes any buffered log messages.
+func Sync() error {
+	return logger().Sync()
 }
 
 func Debug(args ...any) {
-	logger.Debug(args...)
+	logger().Debug(args...)
 }
 
 // Info uses fmt.Sprint to construct and log a message.
 func Info(args ...interface{}) {
-	logger.Info(args...)
+	logger().Info(args...)
 }
 
 // Warn uses fmt.Sprint to construct and log a message.
 func Warn(args ...interface{}) {
-	logger.Warn(args...)
+	logger().Warn(args...)
 }
 
 // Error uses fmt.Sprint to construct and log a message.
 func Error(args ...interface{}) {
-	logger.Error(args...)
+	logger().Error(args...)
 }
 
 // DPanic uses fmt.Sprint to construct and log a message. In development, the
 // logger then panics. (See DPanicLevel for details.)
 func DPanic(args ...interface{}) {
-	logger.DPanic(args...)
+	logger().DPanic(args...)
 }
 
 // Panic uses fmt.Sprint to construct and log a message, then panics.
 func Panic(args ...interface{}) {
-	logger.Panic(args...)
+	logger().Panic(args...)
 }
 
 // Fatal uses fmt.Sprint to construct and log a message, then calls os.Exit.
 func Fatal(args ...interface{}) {
-	logger.Fatal(args...)
+	logger().Fatal(args...)
 }
 
 // Debugf uses fmt.Sprintf to log a templated message.
 func Debugf(template string, args ...interface{}) {
-	logger.Debugf(template, args...)
+	logger().Debugf(template, args...)
 }
 
 // Infof uses fmt.Sprintf to log a templated message.
 func Infof(template string, args ...interface{}) {
-	logger.Infof(template, args...)
+	logger().Infof(template, args...)
 }
 
 // Warnf uses fmt.Sprintf to log a templated message.
 func Warnf(template string, args ...interface{}) {
-	logger.Warnf(template, args...)
+	logger().Warnf(template, args...)
 }
 
 // Errorf uses fmt.Sprintf to log a templated message.
 func Errorf(template string, args ...interface{}) {
-	logger.Errorf(template, args...)
+	logger().Errorf(template, args...)
 }
 
 // DPanicf uses fmt.Sprintf to log a templated message. In development, the
 // logger then panics. (See DPanicLevel for details.)
 func DPanicf(template string, args ...interface{}) {
-	logger.DPanicf(template, args...)
+	logger().DPanicf(template, args...)
 }
 
 // Panicf uses fmt.Sprintf to log a templated message, then panics.
 func Panicf(template string, args ...interface{}) {
-	logger.Panicf(template, args...)
+	logger().Panicf(template, args...)
 }
 
 // Fatalf uses fmt.Sprintf to log a templated message, then calls os.Exit.
 func Fatalf(template string, args ...interface{}) {
-	logger.Fatalf(template, args...)
+	logger().Fatalf(template, args...)
 }
 
 // Debugw logs a message with some additional context. The variadic key-value
@@ -182,78 +109,78 @@ func Fatalf(template string, args ...interface{}) {
 //
 //	s.With(keysAndValues).Debug(msg)
 func Debugw(msg string, keysAndValues ...interface{}) {
-	logger.Debugw(msg, keysAndValues...)
+	logger().Debugw(msg, keysAndValues...)
 }
 
 // Infow logs a message with some additional context. The variadic key-value
 // pairs are treated as they are in With.
 func Infow(msg string, keysAndValues ...interface{}) {
-	logger.Infow(msg, keysAndValues...)
+	logger().Infow(msg, keysAndValues...)
 }
 
 // Warnw logs a message with some additional context. The variadic key-value
 // pairs are treated as they are in With.
 func Warnw(msg string, keysAndValues ...interface{}) {
-	logger.Warnw(msg, keysAndValues...)
+	logger().Warnw(msg, keysAndValues...)
 }
 
 // Errorw logs a message with some additional context. The variadic key-value
 // pairs are treated as they are in With.
 func Errorw(msg string, keysAndValues ...interface{}) {
-	logger.Errorw(msg, keysAndValues...)
+	logger().Errorw(msg, keysAndValues...)
 }
 
 // DPanicw logs a message with some additional context. In development, the
 // logger then panics. (See DPanicLevel for details.) The variadic key-value
 // pairs are treated as they are in With.
 func DPanicw(msg string, keysAndValues ...interface{}) {
-	logger.DPanicw(msg, keysAndValues...)
+	logger().DPanicw(msg, keysAndValues...)
 }
 
 // Panicw logs a message with some additional context, then panics. The
 // variadic key-value pairs are treated as they are in With.
 func Panicw(msg string, keysAndValues ...interface{}) {
-	logger.Panicw(msg, keysAndValues...)
+	logger().Panicw(msg, keysAndValues...)
 }
 
 // Fatalw logs a message with some additional context, then calls os.Exit. The
 // variadic key-value pairs are treated as they are in With.
 func Fatalw(msg string, keysAndValues ...interface{}) {
-	logger.Fatalw(msg, keysAndValues...)
+	logger().Fatalw(msg, keysAndValues...)
 }
 
 // Debugln uses fmt.Sprintln to construct and log a message.
 func Debugln(args ...interface{}) {
-	logger.Debugln(args...)
+	logger().Debugln(args...)
 }
 
 // Infoln uses fmt.Sprintln to construct and log a message.
 func Infoln(args ...interface{}) {
-	logger.Infoln(args...)
+	logger().Infoln(args...)
 }
 
 // Warnln uses fmt.Sprintln to construct and log a message.
 func Warnln(args ...interface{}) {
-	logger.Warnln(args...)
+	logger().Warnln(args...)
 }
 
 // Errorln uses fmt.Sprintln to construct and log a message.
 func Errorln(args ...interface{}) {
-	logger.Errorln(args...)
+	logger().Errorln(args...)
 }
 
 // DPanicln uses fmt.Sprintln to construct and log a message. In development, the
 // logger then panics. (See DPanicLevel for details.)
 func DPanicln(args ...interface{}) {
-	logger.DPanicln(args...)
+	logger().DPanicln(args...)
 }
 
 // Panicln uses fmt.Sprintln to construct and log a message, then panics.
 func Panicln(args ...interface{}) {
-	logger.Panicln(args...)
+	logger().Panicln(args...)
 }
 
 // Fatalln uses fmt.Sprintln to construct and log a message, then calls os.Exit.
 func Fatalln(args ...interface{}) {
-	logger.Fatalln(args...)
+	logger().Fatalln(args...)
 }
@@ -0,0 +1,332 @@
+// Package openapi generates OpenAPI 3 documents that describe the methods
+// reachable through the gateway, from the descriptors of a DescriptorSource.
+package openapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 the descriptor API is built on the v1 API
+	"github.com/jhump/protoreflect/desc"
+)
+
+// Version is the OpenAPI version of generated documents.
+const Version = "3.0.3"
+
+// Document is an OpenAPI document, limited to the parts used by the gateway.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Tags       []Tag                `json:"tags,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type PathItem struct {
+	Post *Operation `json:"post,omitempty"`
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	// Streaming is "client", "server" or "bidi" for streaming methods, whose
+	// messages are sent and received as a sequence of JSON values.
+	Streaming string `json:"x-grpc-streaming,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// Schema is a JSON schema, as used by OpenAPI.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+}
+
+// Options control how the gateway is described.
+type Options struct {
+	// Title and Version go into the info section.
+	Title   string
+	Version string
+	// Services, if not empty, restricts the document to the given services.
+	Services []string
+	// AddrHeader documents the Addr header, which selects the backend when
+	// the gateway uses the http registry.
+	AddrHeader bool
+	// Envelope is the name of the envelope that wraps responses: "ack" and
+	// "jsonapi" are described, anything else is taken to leave responses
+	// as-is.
+	Envelope string
+	// UseProtoNames, EnumsAsInts and Int64AsNumbers must match the JSON
+	// options of the gateway.
+	UseProtoNames  bool
+	EnumsAsInts    bool
+	Int64AsNumbers bool
+}
+
+// Generate returns a document with an operation for every method of every
+// service in source.
+func Generate(source grpcgateway.DescriptorSource, opts Options) (*Document, error) {
+	doc := New(opts)
+	if err := doc.Add(source, opts); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// New returns an empty document.
+func New(opts Options) *Document {
+	title, version := opts.Title, opts.Version
+	if title == "" {
+		title = "http-to-grpc-gateway"
+	}
+	if version == "" {
+		version = "1.0.0"
+	}
+	return &Document{
+		OpenAPI: Version,
+		Info: Info{
+			Title:       title,
+			Description: "gRPC methods exposed over HTTP by the gateway. Each method is invoked with a POST to /{service}/{method}.",
+			Version:     version,
+		},
+		Paths:      map[string]*PathItem{},
+		Components: Components{Schemas: map[string]*Schema{}},
+	}
+}
+
+// Add adds the services in source to the document, so that documents can
+// be assembled from several backends.
+func (doc *Document) Add(source grpcgateway.DescriptorSource, opts Options) error {
+	services, err := grpcgateway.ListServices(source)
+	if err != nil {
+		return err
+	}
+	if len(opts.Services) > 0 {
+		services = filter(services, opts.Services)
+	}
+	g := generator{doc: doc, opts: opts}
+	for _, svc := range services {
+		if err := g.addService(source, svc); err != nil {
+			return err
+		}
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool {
+		return doc.Tags[i].Name < doc.Tags[j].Name
+	})
+	return nil
+}
+
+// Fingerprint returns a digest of all descriptors in source, which changes
+// whenever a document generated from source would.
+func Fingerprint(source grpcgateway.DescriptorSource) (string, error) {
+	files, err := grpcgateway.GetAllFiles(source)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, fd := range files {
+		b, err := proto.Marshal(fd.AsFileDescriptorProto())
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s:%d:", fd.GetName(), len(b))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func filter(services, keep []string) []string {
+	var filtered []string
+	for _, svc := range services {
+		for _, k := range keep {
+			if strings.EqualFold(svc, k) {
+				filtered = append(filtered, svc)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+type generator struct {
+	doc  *Document
+	opts Options
+}
+
+func (g *generator) addService(source grpcgateway.DescriptorSource, svc string) error {
+	dsc, err := source.FindSymbol(svc)
+	if err != nil {
+		return err
+	}
+	sd, ok := dsc.(*desc.ServiceDescriptor)
+	if !ok {
+		return fmt.Errorf("%q is not a service", svc)
+	}
+	methods, err := grpcgateway.ListMethods(source, svc)
+	if err != nil {
+		return err
+	}
+	if !g.hasTag(svc) {
+		g.doc.Tags = append(g.doc.Tags, Tag{Name: svc, Description: comment(sd)})
+	}
+	for _, m := range methods {
+		md := sd.FindMethodByName(m[strings.LastIndex(m, ".")+1:])
+		if md == nil {
+			continue
+		}
+		g.doc.Paths["/"+svc+"/"+md.GetName()] = &PathItem{Post: g.operation(md)}
+	}
+	return nil
+}
+
+func (g *generator) hasTag(name string) bool {
+	for _, t := range g.doc.Tags {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *generator) operation(md *desc.MethodDescriptor) *Operation {
+	svc := md.GetService().GetFullyQualifiedName()
+	op := &Operation{
+		OperationID: svc + "." + md.GetName(),
+		Summary:     md.GetName(),
+		Description: comment(md),
+		Tags:        []string{svc},
+		RequestBody: &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{"application/json": {Schema: g.messageRef(md.GetInputType())}},
+		},
+		Responses: map[string]*Response{
+			"200": {
+				Description: "The response message.",
+				Content:     map[string]MediaType{"application/json": {Schema: g.envelope(g.messageRef(md.GetOutputType()))}},
+			},
+			"default": {
+				Description: "An error.",
+				Content:     map[string]MediaType{"application/json": {Schema: g.errorSchema()}},
+			},
+		},
+	}
+	switch {
+	case md.IsClientStreaming() && md.IsServerStreaming():
+		op.Streaming = "bidi"
+	case md.IsClientStreaming():
+		op.Streaming = "client"
+	case md.IsServerStreaming():
+		op.Streaming = "server"
+	}
+	if g.opts.AddrHeader {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        "Addr",
+			In:          "header",
+			Description: "Address (host:port) of the gRPC backend.",
+			Required:    true,
+			Schema:      &Schema{Type: "string"},
+		})
+	}
+	return op
+}
+
+// envelope wraps the schema of a response message in the configured
+// envelope.
+func (g *generator) envelope(data *Schema) *Schema {
+	switch g.opts.Envelope {
+	case "", "ack":
+		return &Schema{Type: "object", Properties: map[string]*Schema{
+			"code": {Type: "integer", Format: "int32"},
+			"msg":  {Type: "string"},
+			"data": data,
+		}}
+	case "jsonapi":
+		return &Schema{Type: "object", Properties: map[string]*Schema{"data": data}}
+	}
+	return data
+}
+
+func (g *generator) errorSchema() *Schema {
+	switch g.opts.Envelope {
+	case "", "ack":
+		return &Schema{Type: "object", Properties: map[string]*Schema{
+			"code": {Type: "integer", Format: "int32"},
+			"msg":  {Type: "string", Description: "The error message."},
+			"data": {Type: "array", Items: &Schema{Type: "object"}, Description: "Error details, if any."},
+		}}
+	case "jsonapi":
+		return &Schema{Type: "object", Properties: map[string]*Schema{
+			"errors": {Type: "array", Items: &Schema{Type: "object", Properties: map[string]*Schema{
+				"status": {Type: "string"},
+				"code":   {Type: "string"},
+				"title":  {Type: "string"},
+				"detail": {Type: "string"},
+				"source": {Type: "object", Properties: map[string]*Schema{"pointer": {Type: "string"}}},
+			}}},
+		}}
+	}
+	return &Schema{Type: "object", Properties: map[string]*Schema{
+		"code":    {Type: "integer", Format: "int32", Description: "The gRPC status code."},
+		"message": {Type: "string"},
+		"details": {Type: "array", Items: &Schema{Type: "object"}},
+	}}
+}
+
+// comment returns the leading comment of a descriptor, if the source info
+// was retained.
+func comment(d desc.Descriptor) string {
+	return strings.TrimSpace(d.GetSourceInfo().GetLeadingComments())
+}
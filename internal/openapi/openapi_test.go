@@ -0,0 +1,90 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+)
+
+func TestGenerate(t *testing.T) {
+	source, err := grpcgateway.DescriptorSourceFromProtoSets("../testing/test.protoset")
+	if err != nil {
+		t.Fatalf("failed to load protoset: %v", err)
+	}
+
+	doc, err := Generate(source, Options{Envelope: "none", AddrHeader: true})
+	if err != nil {
+		t.Fatalf("failed to generate document: %v", err)
+	}
+	op := doc.Paths["/testing.TestService/UnaryCall"].Post
+	if op == nil {
+		t.Fatal("missing operation for UnaryCall")
+	}
+	if op.RequestBody.Content["application/json"].Schema.Ref != "#/components/schemas/testing.SimpleRequest" {
+		t.Errorf("unexpected request schema: %+v", op.RequestBody.Content["application/json"].Schema)
+	}
+	if op.Responses["200"].Content["application/json"].Schema.Ref != "#/components/schemas/testing.SimpleResponse" {
+		t.Errorf("unexpected response schema: %+v", op.Responses["200"].Content["application/json"].Schema)
+	}
+	if len(op.Parameters) != 1 || op.Parameters[0].Name != "Addr" {
+		t.Errorf("expecting the Addr header parameter, got %+v", op.Parameters)
+	}
+	if s := doc.Paths["/testing.TestService/FullDuplexCall"].Post.Streaming; s != "bidi" {
+		t.Errorf("expecting FullDuplexCall to be bidi streaming, got %q", s)
+	}
+
+	payload := doc.Components.Schemas["testing.Payload"]
+	if payload == nil {
+		t.Fatal("missing schema for testing.Payload")
+	}
+	if body := payload.Properties["body"]; body.Type != "string" || body.Format != "byte" {
+		t.Errorf("unexpected schema for bytes field: %+v", body)
+	}
+	if typ := payload.Properties["type"]; typ.Ref != "#/components/schemas/testing.PayloadType" {
+		t.Errorf("unexpected schema for enum field: %+v", typ)
+	}
+	enum := doc.Components.Schemas["testing.PayloadType"]
+	if b, _ := json.Marshal(enum.Enum); enum.Type != "string" || string(b) != `["COMPRESSABLE","UNCOMPRESSABLE","RANDOM"]` {
+		t.Errorf("unexpected enum schema: %+v", enum)
+	}
+	req := doc.Components.Schemas["testing.SimpleRequest"]
+	if _, ok := req.Properties["responseSize"]; !ok {
+		t.Errorf("expecting JSON names by default, got %v", req.Properties)
+	}
+}
+
+func TestGenerateOptions(t *testing.T) {
+	source, err := grpcgateway.DescriptorSourceFromProtoSets("../testing/test.protoset")
+	if err != nil {
+		t.Fatalf("failed to load protoset: %v", err)
+	}
+
+	doc, err := Generate(source, Options{UseProtoNames: true, EnumsAsInts: true})
+	if err != nil {
+		t.Fatalf("failed to generate document: %v", err)
+	}
+	if _, ok := doc.Components.Schemas["testing.SimpleRequest"].Properties["response_size"]; !ok {
+		t.Error("expecting proto names")
+	}
+	if enum := doc.Components.Schemas["testing.PayloadType"]; enum.Type != "integer" {
+		t.Errorf("expecting enums as integers, got %+v", enum)
+	}
+	resp := doc.Paths["/testing.TestService/UnaryCall"].Post.Responses["200"].Content["application/json"].Schema
+	if resp.Properties["data"] == nil || resp.Properties["data"].Ref != "#/components/schemas/testing.SimpleResponse" {
+		t.Errorf("expecting the response in an ack envelope, got %+v", resp)
+	}
+
+	fp1, err := Fingerprint(source)
+	if err != nil {
+		t.Fatalf("failed to fingerprint descriptors: %v", err)
+	}
+	other, _ := grpcgateway.DescriptorSourceFromProtoSets("../testing/example.protoset")
+	fp2, err := Fingerprint(other)
+	if err != nil {
+		t.Fatalf("failed to fingerprint descriptors: %v", err)
+	}
+	if fp1 == fp2 {
+		t.Error("expecting different fingerprints for different descriptors")
+	}
+}
@@ -0,0 +1,187 @@
+package openapi
+
+import (
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// wellKnownTypes are the schemas of the well-known types, which have special
+// representations in JSON.
+var wellKnownTypes = map[string]func(g *generator) *Schema{
+	"google.protobuf.Any": func(*generator) *Schema {
+		return &Schema{Type: "object", Description: "Any message, identified by its \"@type\" property.",
+			Properties: map[string]*Schema{"@type": {Type: "string"}}}
+	},
+	"google.protobuf.Timestamp": func(*generator) *Schema {
+		return &Schema{Type: "string", Format: "date-time"}
+	},
+	"google.protobuf.Duration": func(*generator) *Schema {
+		return &Schema{Type: "string", Description: "A duration in seconds with up to nine fractional digits, suffixed with \"s\", e.g. \"1.5s\"."}
+	},
+	"google.protobuf.FieldMask": func(*generator) *Schema {
+		return &Schema{Type: "string", Description: "Comma-separated field paths."}
+	},
+	"google.protobuf.Empty": func(*generator) *Schema {
+		return &Schema{Type: "object"}
+	},
+	"google.protobuf.Struct": func(*generator) *Schema {
+		return &Schema{Type: "object", AdditionalProperties: &Schema{}}
+	},
+	"google.protobuf.Value": func(*generator) *Schema {
+		return &Schema{Description: "Any JSON value."}
+	},
+	"google.protobuf.ListValue": func(*generator) *Schema {
+		return &Schema{Type: "array", Items: &Schema{}}
+	},
+	"google.protobuf.DoubleValue": func(*generator) *Schema {
+		return &Schema{Type: "number", Format: "double", Nullable: true}
+	},
+	"google.protobuf.FloatValue": func(*generator) *Schema {
+		return &Schema{Type: "number", Format: "float", Nullable: true}
+	},
+	"google.protobuf.Int64Value": func(g *generator) *Schema {
+		s := g.int64Schema("int64")
+		s.Nullable = true
+		return s
+	},
+	"google.protobuf.UInt64Value": func(g *generator) *Schema {
+		s := g.int64Schema("uint64")
+		s.Nullable = true
+		return s
+	},
+	"google.protobuf.Int32Value": func(*generator) *Schema {
+		return &Schema{Type: "integer", Format: "int32", Nullable: true}
+	},
+	"google.protobuf.UInt32Value": func(*generator) *Schema {
+		return &Schema{Type: "integer", Format: "uint32", Nullable: true}
+	},
+	"google.protobuf.BoolValue": func(*generator) *Schema {
+		return &Schema{Type: "boolean", Nullable: true}
+	},
+	"google.protobuf.StringValue": func(*generator) *Schema {
+		return &Schema{Type: "string", Nullable: true}
+	},
+	"google.protobuf.BytesValue": func(*generator) *Schema {
+		return &Schema{Type: "string", Format: "byte", Nullable: true}
+	},
+}
+
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// messageRef returns a reference to the schema of a message, adding the
+// schemas of it and of the types it refers to to the document's components.
+func (g *generator) messageRef(md *desc.MessageDescriptor) *Schema {
+	name := md.GetFullyQualifiedName()
+	if _, ok := g.doc.Components.Schemas[name]; ok {
+		return ref(name)
+	}
+	if wkt, ok := wellKnownTypes[name]; ok {
+		g.doc.Components.Schemas[name] = wkt(g)
+		return ref(name)
+	}
+
+	s := &Schema{Type: "object", Description: comment(md), Properties: map[string]*Schema{}}
+	// added before the fields, so that recursive types terminate
+	g.doc.Components.Schemas[name] = s
+	for _, fd := range md.GetFields() {
+		key := fd.GetJSONName()
+		if g.opts.UseProtoNames {
+			key = fd.GetName()
+		}
+		fs := g.fieldSchema(fd)
+		if c := comment(fd); c != "" {
+			fs = withDescription(fs, c)
+		}
+		s.Properties[key] = fs
+	}
+	return ref(name)
+}
+
+// withDescription returns s with a description. References cannot have
+// siblings, so they are wrapped in a schema that can.
+func withDescription(s *Schema, description string) *Schema {
+	if s.Ref == "" {
+		s.Description = description
+		return s
+	}
+	return &Schema{Description: description, AllOf: []*Schema{s}}
+}
+
+func (g *generator) fieldSchema(fd *desc.FieldDescriptor) *Schema {
+	if fd.IsMap() {
+		return &Schema{Type: "object", AdditionalProperties: g.valueSchema(fd.GetMapValueType())}
+	}
+	s := g.valueSchema(fd)
+	if fd.IsRepeated() {
+		return &Schema{Type: "array", Items: s}
+	}
+	return s
+}
+
+// valueSchema returns the schema of a single value of the field's type.
+func (g *generator) valueSchema(fd *desc.FieldDescriptor) *Schema {
+	switch fd.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return &Schema{Type: "number", Format: "double"}
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT:
+		return &Schema{Type: "number", Format: "float"}
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return g.int64Schema("int64")
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		return g.int64Schema("uint64")
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED32:
+		return &Schema{Type: "integer", Format: "uint32"}
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return &Schema{Type: "boolean"}
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return &Schema{Type: "string"}
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return &Schema{Type: "string", Format: "byte"}
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return g.enumRef(fd.GetEnumType())
+	default:
+		return g.messageRef(fd.GetMessageType())
+	}
+}
+
+// int64Schema returns the schema of 64-bit integers, which are strings in
+// JSON unless the gateway is configured to write them as numbers.
+func (g *generator) int64Schema(format string) *Schema {
+	if g.opts.Int64AsNumbers {
+		return &Schema{Type: "integer", Format: format}
+	}
+	return &Schema{Type: "string", Format: format}
+}
+
+func (g *generator) enumRef(ed *desc.EnumDescriptor) *Schema {
+	name := ed.GetFullyQualifiedName()
+	if name == "google.protobuf.NullValue" {
+		return &Schema{Nullable: true, Description: "Always null."}
+	}
+	if _, ok := g.doc.Components.Schemas[name]; ok {
+		return ref(name)
+	}
+	s := &Schema{Type: "string", Description: comment(ed)}
+	for _, v := range ed.GetValues() {
+		if g.opts.EnumsAsInts {
+			s.Enum = append(s.Enum, v.GetNumber())
+		} else {
+			s.Enum = append(s.Enum, v.GetName())
+		}
+	}
+	if g.opts.EnumsAsInts {
+		s.Type, s.Format = "integer", "int32"
+	}
+	g.doc.Components.Schemas[name] = s
+	return ref(name)
+}
@@ -0,0 +1,210 @@
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+)
+
+// The mappings of the local registry start out as those of the config, or
+// those saved to local_registry.file if it exists, and can then be changed at
+// runtime, which saves them to that file if one is configured. Reloaded
+// configs change them as Reload says, keeping the changes made at runtime.
+var (
+	mu       sync.Mutex
+	services map[string]entry // by lowercase service name; nil until loaded
+)
+
+// entry maps a service, named as it was given, to its endpoint.
+type entry struct {
+	service string
+	addr    string
+}
+
+// ErrInvalid is wrapped by the errors returned for invalid mappings.
+var ErrInvalid = errors.New("invalid mapping")
+
+// serviceName matches fully-qualified service names.
+var serviceName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// load reads the mappings if they have not been yet, with mu held.
+func load() {
+	if services != nil {
+		return
+	}
+	services = map[string]entry{}
+	if file := persistFile(); file != "" {
+		var saved map[string]string
+		b, err := os.ReadFile(file)
+		if err == nil {
+			if err = json.Unmarshal(b, &saved); err == nil {
+				for svc, addr := range saved {
+					services[strings.ToLower(svc)] = entry{svc, addr}
+				}
+				return
+			}
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			// falls back to the config, which is better than no registry
+			logger.Errorf("Failed to read local registry from %s, using the config: %v", file, err)
+		}
+	}
+	for svc, addr := range config.LocalRegistry() {
+		services[strings.ToLower(svc)] = entry{svc, addr}
+	}
+}
+
+// persistFile returns the file the registry is saved to, if any.
+var persistFile = func() string {
+	if conf := config.Conf(); conf != nil {
+		return conf.LocalRegistry.File
+	}
+	return ""
+}
+
+// Services returns a copy of the mappings from service names to endpoints.
+// Names are matched case-insensitively; those from the config are lowercase.
+func Services() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+	m := make(map[string]string, len(services))
+	for _, e := range services {
+		m[e.service] = e.addr
+	}
+	return m
+}
+
+// Lookup returns the endpoint of a service.
+func Lookup(service string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+	e := services[strings.ToLower(service)]
+	return e.addr, e.addr != ""
+}
+
+// Set maps a service to an endpoint, reporting whether the service is new.
+func Set(service, addr string) (bool, error) {
+	if !serviceName.MatchString(service) {
+		return false, fmt.Errorf("%w: service name %q must be fully-qualified, e.g. package.Service", ErrInvalid, service)
+	}
+	if err := config.ValidateEndpoint(addr); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+	key := strings.ToLower(service)
+	old, exists := services[key]
+	services[key] = entry{service, addr}
+	if err := save(); err != nil {
+		if exists {
+			services[key] = old
+		} else {
+			delete(services, key)
+		}
+		return false, err
+	}
+	return !exists, nil
+}
+
+// Reload applies to the mappings the changes a reloaded config makes to them,
+// from old to updated, saving them if a file is configured. The config wins
+// for the services it changes: those it adds or maps to another endpoint
+// are mapped to its endpoint, and those it removes are removed, unless they
+// were mapped to another endpoint at runtime. Other mappings, including
+// those added at runtime, are kept.
+func Reload(old, updated map[string]string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+	prev := make(map[string]entry, len(services))
+	for key, e := range services {
+		prev[key] = e
+	}
+	lowercase := func(m map[string]string) map[string]string {
+		l := make(map[string]string, len(m))
+		for svc, addr := range m {
+			l[strings.ToLower(svc)] = addr
+		}
+		return l
+	}
+	was, is := lowercase(old), lowercase(updated)
+	for svc, addr := range updated {
+		key := strings.ToLower(svc)
+		if prevAddr, ok := was[key]; !ok || prevAddr != addr {
+			services[key] = entry{svc, addr}
+		}
+	}
+	for key, addr := range was {
+		if _, ok := is[key]; !ok && services[key].addr == addr {
+			delete(services, key)
+		}
+	}
+	if err := save(); err != nil {
+		services = prev
+		return err
+	}
+	return nil
+}
+
+// Remove removes the mapping of a service, reporting whether there was one.
+func Remove(service string) (bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	load()
+	key := strings.ToLower(service)
+	old, exists := services[key]
+	if !exists {
+		return false, nil
+	}
+	delete(services, key)
+	if err := save(); err != nil {
+		services[key] = old
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes the mappings to the configured file, if any, with mu held. The
+// file is replaced atomically so that it is never left half written.
+func save() error {
+	file := persistFile()
+	if file == "" {
+		return nil
+	}
+	m := make(map[string]string, len(services))
+	for _, e := range services {
+		m[e.service] = e.addr
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save local registry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(append(b, '\n')); err == nil {
+		err = tmp.Close()
+	} else {
+		_ = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save local registry: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package local
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "registry.json")
+	persistFile = func() string { return file }
+	reset := func(m map[string]entry) {
+		mu.Lock()
+		services = m
+		mu.Unlock()
+	}
+	// starts empty rather than from the config
+	reset(map[string]entry{})
+	t.Cleanup(func() {
+		persistFile = func() string { return "" }
+		reset(nil)
+	})
+
+	if _, err := Set("not a service", "127.0.0.1:1"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expecting an invalid service name, got %v", err)
+	}
+	if _, err := Set("pkg.Service", "127.0.0.1"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expecting an invalid endpoint, got %v", err)
+	}
+	if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expecting nothing to be saved, got %v", err)
+	}
+
+	if created, err := Set("pkg.Service", "127.0.0.1:1"); err != nil || !created {
+		t.Fatalf("expecting the service to be added, got %v %v", created, err)
+	}
+	if created, err := Set("pkg.Service", "127.0.0.1:2"); err != nil || created {
+		t.Fatalf("expecting the service to be updated, got %v %v", created, err)
+	}
+	if created, err := Set("pkg.Other", "127.0.0.1:3"); err != nil || !created {
+		t.Fatalf("expecting the service to be added, got %v %v", created, err)
+	}
+	if addr, ok := Lookup("PKG.service"); !ok || addr != "127.0.0.1:2" {
+		t.Errorf("expecting case-insensitive lookup, got %q %v", addr, ok)
+	}
+	if removed, err := Remove("pkg.other"); err != nil || !removed {
+		t.Errorf("expecting the service to be removed, got %v %v", removed, err)
+	}
+	if removed, err := Remove("pkg.Other"); err != nil || removed {
+		t.Errorf("expecting nothing to remove, got %v %v", removed, err)
+	}
+
+	// the saved registry is loaded again
+	reset(nil)
+	got := Services()
+	if len(got) != 1 || got["pkg.Service"] != "127.0.0.1:2" {
+		t.Errorf("wrong registry loaded from %s: %v", file, got)
+	}
+}
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "registry.json")
+	persistFile = func() string { return file }
+	mu.Lock()
+	services = map[string]entry{}
+	mu.Unlock()
+	t.Cleanup(func() {
+		persistFile = func() string { return "" }
+		mu.Lock()
+		services = nil
+		mu.Unlock()
+	})
+
+	old := map[string]string{"pkg.Kept": "127.0.0.1:1", "pkg.Changed": "127.0.0.1:2", "pkg.Removed": "127.0.0.1:3", "pkg.Edited": "127.0.0.1:4"}
+	if err := Reload(nil, old); err != nil {
+		t.Fatal(err)
+	}
+	// runtime changes
+	for svc, addr := range map[string]string{"pkg.Added": "127.0.0.1:5", "pkg.Edited": "127.0.0.1:6", "pkg.Kept": "127.0.0.1:7"} {
+		if _, err := Set(svc, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updated := map[string]string{"pkg.Kept": "127.0.0.1:1", "pkg.Changed": "127.0.0.1:8", "pkg.New": "127.0.0.1:9"}
+	if err := Reload(old, updated); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		// runtime changes to services the config leaves alone are kept
+		"pkg.Kept":  "127.0.0.1:7",
+		"pkg.Added": "127.0.0.1:5",
+		// services the config changes or adds take its endpoint
+		"pkg.Changed": "127.0.0.1:8",
+		"pkg.New":     "127.0.0.1:9",
+		// services removed from the config are kept if changed at runtime
+		"pkg.Edited": "127.0.0.1:6",
+	}
+	got := Services()
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expecting %v, got %v", expected, got)
+	}
+
+	// and saved
+	mu.Lock()
+	services = nil
+	mu.Unlock()
+	if got := Services(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expecting %v to be saved, got %v", expected, got)
+	}
+}
@@ -1,11 +1,85 @@
 package registry
 
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
 type Register interface {
 	Register() (*Registry, error)
 }
 
+// ErrNotFound is returned by registers that do not know where a service is,
+// so that the next register of a Chain is asked instead.
+var ErrNotFound = errors.New("service not found")
+
+// NotFoundf formats an error that matches ErrNotFound.
+func NotFoundf(format string, a ...interface{}) error {
+	return notFound{fmt.Errorf(format, a...)}
+}
+
+type notFound struct{ error }
+
+func (notFound) Is(target error) bool { return target == ErrNotFound }
+
+// Chain is a Register that asks its registers in order and resolves to the
+// first address found. Errors other than ErrNotFound, such as a malformed
+// method name, end the search.
+type Chain []Register
+
+func (c Chain) Register() (*Registry, error) {
+	if len(c) == 0 {
+		return nil, NotFoundf("no registry configured")
+	}
+	var errs []error
+	for _, r := range c {
+		reg, err := r.Register()
+		if err == nil {
+			return reg, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
 type Registry struct {
 	Method  string
 	Service string
 	Addr    string
 }
+
+// MethodFromRequest returns the method to invoke and the service it belongs to.
+// The method is taken from the "Method" header if present. Otherwise the URL
+// path is used, which is how gRPC-Web, Connect and native gRPC clients address
+// methods ("/package.Service/Method").
+func MethodFromRequest(req *http.Request) (string, string, error) {
+	method := req.Header.Get("Method")
+	if method == "" {
+		method = strings.TrimPrefix(req.URL.Path, "/")
+	}
+	if method == "" {
+		return "", "", errors.New("method parameter not found")
+	}
+
+	service, _ := parseSymbol(method)
+	if service == "" {
+		return "", "", fmt.Errorf("given method name %q is not in expected format: 'service/method' or 'service.method'", method)
+	}
+	return method, service, nil
+}
+
+func parseSymbol(svcAndMethod string) (string, string) {
+	pos := strings.LastIndex(svcAndMethod, "/")
+	if pos < 0 {
+		pos = strings.LastIndex(svcAndMethod, ".")
+		if pos < 0 {
+			return "", ""
+		}
+	}
+	return svcAndMethod[:pos], svcAndMethod[pos+1:]
+}
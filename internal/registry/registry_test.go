@@ -0,0 +1,35 @@
+package registry
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type registerFunc func() (*Registry, error)
+
+func (f registerFunc) Register() (*Registry, error) { return f() }
+
+func TestChain(t *testing.T) {
+	found := func(addr string) Register {
+		return registerFunc(func() (*Registry, error) { return &Registry{Addr: addr}, nil })
+	}
+	missing := func(name string) Register {
+		return registerFunc(func() (*Registry, error) { return nil, NotFoundf("not in %s", name) })
+	}
+	broken := registerFunc(func() (*Registry, error) { return nil, errors.New("broken") })
+
+	if reg, err := (Chain{missing("a"), found("b:1"), found("c:1")}).Register(); err != nil || reg.Addr != "b:1" {
+		t.Errorf("expecting the first address found, got %+v %v", reg, err)
+	}
+	_, err := Chain{missing("a"), missing("b")}.Register()
+	if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "not in a") || !strings.Contains(err.Error(), "not in b") {
+		t.Errorf("expecting every registry to be reported, got %v", err)
+	}
+	if _, err = (Chain{broken, found("b:1")}).Register(); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expecting errors to end the search, got %v", err)
+	}
+	if _, err = (Chain{}).Register(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expecting an empty chain to find nothing, got %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/registry"
+	"google.golang.org/grpc/codes"
+)
+
+// requestIDHeader carries the ID of a request, which is taken from the
+// client if it sends one, returned in the response and forwarded to the
+// backend as metadata.
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen bounds the IDs accepted from clients, which end up in logs.
+const maxRequestIDLen = 128
+
+// accessEntry collects what is known about a request as it is handled, for
+// its access log entry. Its methods may be called on nil, for requests that
+// are not logged, and concurrently, since RPCs may outlive their handlers.
+type accessEntry struct {
+	mu      sync.Mutex
+	service string
+	method  string
+	backend string
+	code    *codes.Code
+
+	requests  atomic.Int64
+	responses atomic.Int64
+}
+
+type accessKey struct{}
+
+// accessFrom returns the access log entry of the request with the given
+// context, or nil if there is none.
+func accessFrom(ctx context.Context) *accessEntry {
+	e, _ := ctx.Value(accessKey{}).(*accessEntry)
+	return e
+}
+
+// setRPC records the method invoked and the backend serving it.
+func (e *accessEntry) setRPC(r *registry.Registry) {
+	if e == nil || r == nil {
+		return
+	}
+	method := r.Method
+	if pos := strings.LastIndexAny(method, "/."); pos >= 0 {
+		method = method[pos+1:]
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.service, e.method, e.backend = r.Service, method, r.Addr
+}
+
+// setCode records the outcome of the RPC; the first one recorded is kept.
+func (e *accessEntry) setCode(code codes.Code) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.code == nil {
+		e.code = &code
+	}
+}
+
+// setCounts records the number of messages sent and received.
+func (e *accessEntry) setCounts(requests, responses int) {
+	if e == nil {
+		return
+	}
+	e.requests.Store(int64(requests))
+	e.responses.Store(int64(responses))
+}
+
+func (e *accessEntry) addRequest() {
+	if e != nil {
+		e.requests.Add(1)
+	}
+}
+
+func (e *accessEntry) addResponse() {
+	if e != nil {
+		e.responses.Add(1)
+	}
+}
+
+// accessLog wraps a handler so that every request gets an ID and an entry in
+// the access log once it has been handled.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			req.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		entry := &accessEntry{}
+		in := &countingReader{ReadCloser: req.Body}
+		req.Body = in
+		ctx := logger.WithFields(req.Context(), "request_id", id)
+		req = req.WithContext(context.WithValue(ctx, accessKey{}, entry))
+		out := &accessWriter{ResponseWriter: w}
+		next.ServeHTTP(out, req)
+
+		status := int(out.status.Load())
+		if status == 0 {
+			status = http.StatusOK
+		}
+		entry.mu.Lock()
+		fields := []interface{}{
+			"request_id", id,
+			"client_ip", clientIP(req),
+			"http_method", req.Method,
+			"path", req.URL.Path,
+			"grpc_service", entry.service,
+			"grpc_method", entry.method,
+			"backend", entry.backend,
+		}
+		if entry.code != nil {
+			fields = append(fields, "grpc_code", entry.code.String())
+		}
+		entry.mu.Unlock()
+		fields = append(fields,
+			"http_status", status,
+			"bytes_in", in.n.Load(),
+			"bytes_out", out.n.Load(),
+			"requests", entry.requests.Load(),
+			"responses", entry.responses.Load(),
+			"latency", time.Since(start),
+		)
+		logger.Infow("access", fields...)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// accessWriter records the status code and counts the bytes of a response.
+type accessWriter struct {
+	http.ResponseWriter
+	status atomic.Int32
+	n      atomic.Int64
+}
+
+func (w *accessWriter) WriteHeader(code int) {
+	w.status.CompareAndSwap(0, int32(code))
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	w.status.CompareAndSwap(0, http.StatusOK)
+	n, err := w.ResponseWriter.Write(b)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+func (w *accessWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *accessWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestAccessLog(t *testing.T) {
+	// the backend records the request IDs it is sent
+	ids := make(chan []string, 1)
+	addr := newTestBackend(t, nil, grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ids <- md.Get("x-request-id")
+		return handler(ctx, req)
+	}))
+
+	var entry *accessEntry
+	handler := registerWithServe([]string{"http"})
+	gw := httptest.NewServer(accessLog(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entry = accessFrom(req.Context())
+		handler(w, req)
+	})))
+	defer gw.Close()
+
+	post := func(id string) *http.Response {
+		req, _ := http.NewRequest("POST", gw.URL+"/testing.TestService/UnaryCall", strings.NewReader(`{"payload":{"body":"aGVsbG8="}}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Addr", addr)
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := post("")
+	id := resp.Header.Get(requestIDHeader)
+	if len(id) != 32 {
+		t.Errorf("expecting a generated request ID, got %q", id)
+	}
+	if forwarded := <-ids; len(forwarded) != 1 || forwarded[0] != id {
+		t.Errorf("expecting request ID %q to be forwarded, got %v", id, forwarded)
+	}
+	if entry == nil {
+		t.Fatal("expecting an access log entry")
+	}
+	if entry.service != "testing.TestService" || entry.method != "UnaryCall" || entry.backend != addr {
+		t.Errorf("wrong RPC in access log entry: %s %s %s", entry.service, entry.method, entry.backend)
+	}
+	if entry.code == nil || *entry.code != codes.OK {
+		t.Errorf("expecting code OK in access log entry, got %v", entry.code)
+	}
+	if entry.requests.Load() != 1 || entry.responses.Load() != 1 {
+		t.Errorf("wrong message counts in access log entry: %d, %d", entry.requests.Load(), entry.responses.Load())
+	}
+
+	resp = post("abc-123")
+	if id := resp.Header.Get(requestIDHeader); id != "abc-123" {
+		t.Errorf("expecting the client's request ID to be kept, got %q", id)
+	}
+	if forwarded := <-ids; len(forwarded) != 1 || forwarded[0] != "abc-123" {
+		t.Errorf("expecting the client's request ID to be forwarded, got %v", forwarded)
+	}
+
+	resp = post("bad id\tvalue")
+	if id := resp.Header.Get(requestIDHeader); id == "bad id\tvalue" || len(id) != 32 {
+		t.Errorf("expecting an invalid request ID to be replaced, got %q", id)
+	}
+	<-ids
+}
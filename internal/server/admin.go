@@ -0,0 +1,28 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+)
+
+// adminOnly guards the admin API: requests are rejected unless an admin token
+// is configured and presented as a bearer token.
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		conf := config.Conf()
+		if conf == nil || conf.Admin.Token == "" {
+			http.Error(writer, "admin API is disabled: set admin.token in the config", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(conf.Admin.Token)) != 1 {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="gateway admin"`)
+			http.Error(writer, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(writer, req)
+	})
+}
@@ -0,0 +1,444 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/cache"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/registry"
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 RequestSupplier is built on the v1 API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// cacheHeader tells clients whether a response came from the cache.
+const cacheHeader = "X-Cache"
+
+// responseCache holds the responses of read-only methods, or is nil if they
+// are not cached.
+var responseCache cache.Cache
+
+// cacheConf returns the config responses are cached by.
+var cacheConf = serverConf
+
+// errCached abandons calls whose response is found in the cache.
+var errCached = errors.New("response found in the cache")
+
+// SetCache plugs in the cache responses are kept in, such as a client of an
+// external cache, instead of the LRU in memory sized by cache.max_entries. It
+// must be called before Run.
+func SetCache(c cache.Cache) {
+	responseCache = c
+}
+
+// cachePolicy is how the responses of a method are cached and shared.
+type cachePolicy struct {
+	// ttl is how long responses are cached, or 0 if they are not
+	ttl time.Duration
+	// coalesce shares the response of a call with identical calls in flight
+	coalesce bool
+	vary     []string
+}
+
+func (p cachePolicy) cached() bool {
+	return p.ttl > 0
+}
+
+// cachePolicyFor returns how the responses of the given method are cached and
+// shared. Only those of unary methods that have the NO_SIDE_EFFECTS
+// idempotency level or a route saying so are.
+func cachePolicyFor(conf *config.Config, source grpcgateway.DescriptorSource, method string) cachePolicy {
+	var policy cachePolicy
+	mtd := findMethod(source, method)
+	if mtd == nil || mtd.IsClientStreaming() || mtd.IsServerStreaming() {
+		return policy
+	}
+	readOnly := mtd.GetMethodOptions().GetIdempotencyLevel() == descriptorpb.MethodOptions_NO_SIDE_EFFECTS
+	var route *config.RouteCache
+	coalesce := conf.Coalesce.Idempotent && readOnly
+	for _, r := range conf.MatchRoutes(method) {
+		if r.Cache != nil {
+			route = r.Cache
+		}
+		if r.Coalesce != nil {
+			coalesce = *r.Coalesce
+		}
+	}
+	switch {
+	case route != nil && !route.Disabled:
+		policy.ttl = seconds(conf.Cache.TTL)
+		if route.TTL > 0 {
+			policy.ttl = seconds(route.TTL)
+		}
+		policy.vary = addHeaders(conf.Cache.Vary, route.Vary)
+	case route == nil && conf.Cache.Idempotent && readOnly:
+		policy.ttl = seconds(conf.Cache.TTL)
+		policy.vary = addHeaders(conf.Cache.Vary, nil)
+	}
+	if coalesce {
+		policy.coalesce = true
+		policy.vary = addHeaders(policy.vary, conf.Coalesce.Vary)
+	}
+	return policy
+}
+
+// addHeaders returns the header names of a followed by those of b that are
+// not in a.
+func addHeaders(a, b []string) []string {
+	names := append([]string(nil), a...)
+	for _, name := range b {
+		found := false
+		for _, n := range names {
+			found = found || strings.EqualFold(n, name)
+		}
+		if !found {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// knownPolicies holds the cache policies worked out for the methods of
+// backends, by backend address and method, so that the responses of later
+// calls can be looked up before connecting to the backend. They hold for the
+// config they were worked out with only.
+var knownPolicies = struct {
+	sync.Mutex
+	conf *config.Config
+	m    map[string]cachePolicy
+}{}
+
+// rememberPolicy records the cache policy, under conf, of the method of the
+// backend at addr.
+func rememberPolicy(conf *config.Config, addr, method string, policy cachePolicy) {
+	knownPolicies.Lock()
+	defer knownPolicies.Unlock()
+	if knownPolicies.conf != conf {
+		knownPolicies.conf, knownPolicies.m = conf, map[string]cachePolicy{}
+	}
+	knownPolicies.m[addr+" "+method] = policy
+}
+
+// knownPolicy returns the cache policy, under conf, of the method of the
+// backend at addr if it has been recorded.
+func knownPolicy(conf *config.Config, addr, method string) (cachePolicy, bool) {
+	knownPolicies.Lock()
+	defer knownPolicies.Unlock()
+	if knownPolicies.conf != conf {
+		return cachePolicy{}, false
+	}
+	policy, ok := knownPolicies.m[addr+" "+method]
+	return policy, ok
+}
+
+// findMethod returns the descriptor of the given fully-qualified method, or
+// nil if the source does not have it.
+func findMethod(source grpcgateway.DescriptorSource, method string) *desc.MethodDescriptor {
+	pos := strings.LastIndex(method, "/")
+	if pos < 0 {
+		pos = strings.LastIndex(method, ".")
+	}
+	if pos < 0 {
+		return nil
+	}
+	d, err := source.FindSymbol(method[:pos])
+	if err != nil {
+		return nil
+	}
+	sd, ok := d.(*desc.ServiceDescriptor)
+	if !ok {
+		return nil
+	}
+	return sd.FindMethodByName(method[pos+1:])
+}
+
+// cachedCall caches the response of a call to a cacheable method, or shares
+// it with identical calls. The response is buffered so that it can be stored
+// and given an ETag before it is written.
+type cachedCall struct {
+	req    *http.Request
+	method string
+	policy cachePolicy
+	// variant is what else the response depends on: the backend, and the
+	// format of the response
+	variant string
+	// bodyKey is the key of the request body as sent, if the call was
+	// looked up before connecting to the backend, and key that of the
+	// request message
+	bodyKey string
+	key     string
+	// hit is the response found in the cache or shared by another call,
+	// and state tells which
+	hit   *cache.Entry
+	state string
+	// flight is the flight the call leads, if any, and flightKey its key
+	flight    *flight
+	flightKey string
+	buf       *responseBuffer
+}
+
+// cacheVariant returns what else than the request message the response to
+// req from the backend at addr depends on.
+func cacheVariant(addr string, req *http.Request, opts jsonOptions) string {
+	return fmt.Sprintf("%s %s %+v", addr, responseFormat(req, grpcgateway.Format(*config.Format)), opts)
+}
+
+// lookupCall looks the response to req up before connecting to the backend
+// reg resolves to, keyed by the request body as sent, if the cache policy of
+// the method is known from earlier calls: in the cache, then among the
+// identical calls in flight, waiting for the response of one if there is.
+// Otherwise, the call leads the flight others wait for. The body is read, and
+// replaced with a copy. It returns nil if the policy is not known or
+// responses to the method are neither cached nor shared.
+func lookupCall(ctx context.Context, req *http.Request, reg *registry.Registry, opts jsonOptions) (*cachedCall, error) {
+	policy, ok := knownPolicy(cacheConf(), reg.Addr, reg.Method)
+	if !ok || !policy.cached() && !policy.coalesce {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	c := newCachedCall(req.WithContext(ctx), reg.Method, policy, cacheVariant(reg.Addr, req, opts))
+	c.bodyKey = c.cacheKey("body", append([]byte(req.Header.Get("Content-Type")+"\x00"), body...))
+	if c.lookup(c.bodyKey) || !policy.coalesce {
+		return c, nil
+	}
+	if err := c.join(c.bodyKey); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newCachedCall(req *http.Request, method string, policy cachePolicy, variant string) *cachedCall {
+	return &cachedCall{
+		req:     req,
+		method:  method,
+		policy:  policy,
+		variant: variant,
+		buf:     &responseBuffer{header: http.Header{}},
+	}
+}
+
+// supplier wraps the request supplier of the call so that the response is
+// looked up once the request message is known, before it is sent: in the
+// cache, then among the identical calls in flight, waiting for the response
+// of one if there is. When a response is found, the call is abandoned with
+// errCached and hit is set.
+func (c *cachedCall) supplier(next grpcgateway.RequestSupplier) grpcgateway.RequestSupplier {
+	var msg []byte
+	calls := 0
+	return func(m proto.Message) error {
+		calls++
+		err := next(m)
+		switch {
+		case err == nil && calls == 1:
+			msg, err = marshalDeterministic(m)
+		case err == io.EOF && calls <= 2:
+			c.key = c.cacheKey("message", msg)
+			if c.lookup(c.key) {
+				return errCached
+			}
+			// calls that lead a flight already joined it before
+			// connecting to the backend
+			if c.policy.coalesce && c.flight == nil {
+				if werr := c.join(c.key); werr != nil {
+					return werr
+				}
+				if c.hit != nil {
+					return errCached
+				}
+			}
+		}
+		return err
+	}
+}
+
+// join waits for the response of the identical call in flight with the given
+// key, setting hit if it is shared, or else leads the flight.
+func (c *cachedCall) join(key string) error {
+	var err error
+	if c.hit, c.flight, err = joinFlight(c.req.Context(), key); err != nil {
+		return err
+	}
+	if c.hit != nil {
+		c.state = "SHARED"
+		coalesceStats.Add(c.method, 1)
+	}
+	c.flightKey = key
+	return nil
+}
+
+// lookup looks the response up in the cache by key, unless the client asks
+// not to.
+func (c *cachedCall) lookup(key string) bool {
+	if !c.policy.cached() || cacheDirective(c.req, "no-cache") || cacheDirective(c.req, "no-store") {
+		return false
+	}
+	e, ok := responseCache.Get(key)
+	if ok {
+		c.hit, c.state = e, "HIT"
+	}
+	return ok
+}
+
+// cacheKey returns the key of the response to the given request, which both
+// the cache and the flights of coalesced calls are keyed by. The request is
+// either the "message", or the "body" as sent. The key includes the backend,
+// so that calls to different backends neither share responses nor flights,
+// and the version of the config, so that changes to the config, e.g. to
+// envelopes or transforms, are not hidden by cached responses.
+func (c *cachedCall) cacheKey(kind string, b []byte) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%d\x00%s\x00%s\x00%s\x00%x", config.Active().Version, kind, c.method, c.variant, b)
+	for _, name := range c.policy.vary {
+		_, _ = fmt.Fprintf(h, "\x00%s=%q", http.CanonicalHeaderKey(name), c.req.Header.Values(name))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// finish writes the buffered response to w, after storing it and sharing it
+// with the calls waiting for it if the call succeeded.
+func (c *cachedCall) finish(w http.ResponseWriter, ok bool) {
+	code := c.buf.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	if !ok || code != http.StatusOK {
+		copyHeader(w.Header(), c.buf.header)
+		w.WriteHeader(code)
+		_, _ = w.Write(c.buf.body.Bytes())
+		return
+	}
+	body := c.buf.body.Bytes()
+	sum := sha256.Sum256(body)
+	e := &cache.Entry{
+		Header:  c.buf.header,
+		Body:    body,
+		ETag:    `"` + hex.EncodeToString(sum[:16]) + `"`,
+		Expires: time.Now().Add(c.policy.ttl),
+	}
+	if c.policy.cached() && !cacheDirective(c.req, "no-store") {
+		responseCache.Set(c.key, e)
+		if c.bodyKey != "" {
+			responseCache.Set(c.bodyKey, e)
+		}
+	}
+	c.land(e)
+	c.write(w, e, "MISS")
+}
+
+// land ends the flight the call leads, if any, sharing the given response with
+// the calls waiting for it, or letting them call the backend themselves if it
+// is nil. It may be called more than once.
+func (c *cachedCall) land(e *cache.Entry) {
+	if c.flight != nil {
+		c.flight.land(c.flightKey, e)
+		c.flight = nil
+	}
+}
+
+// write writes a cached response, or 304 Not Modified if the client has it
+// already.
+func (c *cachedCall) write(w http.ResponseWriter, e *cache.Entry, state string) {
+	h := w.Header()
+	copyHeader(h, e.Header)
+	h.Set("ETag", e.ETag)
+	if c.policy.cached() {
+		maxAge := int(time.Until(e.Expires).Seconds())
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		h.Set("Cache-Control", fmt.Sprintf("max-age=%d", maxAge))
+		if len(c.policy.vary) > 0 {
+			h.Set("Vary", strings.Join(c.policy.vary, ", "))
+		}
+	}
+	h.Set(cacheHeader, state)
+	if etagMatches(c.req.Header.Get("If-None-Match"), e.ETag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(e.Body)
+}
+
+// responseBuffer is a ResponseWriter that keeps the response in memory.
+type responseBuffer struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.code == 0 {
+		b.code = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *responseBuffer) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = append([]string(nil), v...)
+	}
+}
+
+// cacheDirective reports whether the Cache-Control header of the request has
+// the given directive.
+func cacheDirective(req *http.Request, directive string) bool {
+	for _, v := range req.Header.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// etagMatches reports whether an If-None-Match header matches the ETag, using
+// the weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalDeterministic encodes m the same way every time, so that equal
+// requests get the same cache key.
+func marshalDeterministic(m proto.Message) ([]byte, error) {
+	if dm, ok := m.(*dynamic.Message); ok {
+		return dm.MarshalDeterministic()
+	}
+	var b proto.Buffer
+	b.SetDeterministic(true)
+	err := b.Marshal(m)
+	return b.Bytes(), err
+}
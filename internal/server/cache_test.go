@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/cache"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	gatewaytesting "github.com/LCY2013/http-to-grpc-gateway/internal/testing"
+	"github.com/jhump/protoreflect/desc/builder"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// countingServer counts unary calls, failing those with a "fail" payload. If
+// release is set, calls wait to receive from it, or for it to be closed. If name is set, it replaces
+// the username of responses, to tell backends apart.
+type countingServer struct {
+	gatewaytesting.TestServer
+	calls   *int32
+	release chan struct{}
+	name    string
+}
+
+func (s countingServer) UnaryCall(ctx context.Context, req *gatewaytesting.SimpleRequest) (*gatewaytesting.SimpleResponse, error) {
+	atomic.AddInt32(s.calls, 1)
+	if s.release != nil {
+		<-s.release
+	}
+	if string(req.GetPayload().GetBody()) == "fail" {
+		return nil, status.Error(codes.NotFound, "no such thing")
+	}
+	resp, err := s.TestServer.UnaryCall(ctx, req)
+	if err == nil && s.name != "" {
+		resp.Username = s.name
+	}
+	return resp, err
+}
+
+// connCounter counts the connections made to a backend.
+type connCounter struct {
+	n int32
+}
+
+func (c *connCounter) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (c *connCounter) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (c *connCounter) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (c *connCounter) HandleConn(_ context.Context, s stats.ConnStats) {
+	if _, ok := s.(*stats.ConnBegin); ok {
+		atomic.AddInt32(&c.n, 1)
+	}
+}
+
+func (c *connCounter) count() int32 {
+	return atomic.LoadInt32(&c.n)
+}
+
+func TestResponseCache(t *testing.T) {
+	var calls int32
+	counting := func(s *grpc.Server) {
+		gatewaytesting.RegisterTestServiceServer(s, countingServer{calls: &calls})
+		reflection.Register(s)
+	}
+	conns := &connCounter{}
+	addr, other := newTestBackend(t, counting, grpc.StatsHandler(conns)), newTestBackend(t, counting)
+
+	conf := config.Defaults()
+	conf.Routes = []config.Route{{Method: "testing.TestService/UnaryCall", Cache: &config.RouteCache{TTL: 60}}}
+	responseCache, cacheConf = cache.NewLRU(20), func() *config.Config { return conf }
+	t.Cleanup(func() { responseCache, cacheConf = nil, serverConf })
+
+	svr := httptest.NewServer(registerWithServe([]string{"http"}))
+	defer svr.Close()
+	post := func(path, body string, header ...string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("POST", svr.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Addr", addr)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp
+	}
+	check := func(resp *http.Response, code int, state string, wantCalls int32) string {
+		t.Helper()
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != code || resp.Header.Get(cacheHeader) != state || atomic.LoadInt32(&calls) != wantCalls {
+			t.Errorf("expecting %d %q after %d calls, got %d %q after %d calls: %s",
+				code, state, wantCalls, resp.StatusCode, resp.Header.Get(cacheHeader), atomic.LoadInt32(&calls), b)
+		}
+		return string(b)
+	}
+
+	const method = "/testing.TestService/UnaryCall"
+	resp := post(method, `{"payload":{"body":"aGVsbG8="},"response_size":1}`)
+	etag := resp.Header.Get("ETag")
+	if etag == "" || resp.Header.Get("Cache-Control") != "max-age=59" && resp.Header.Get("Cache-Control") != "max-age=60" {
+		t.Errorf("expecting cache headers, got %v", resp.Header)
+	}
+	body := check(resp, http.StatusOK, "MISS", 1)
+
+	// the same request message, written differently
+	if got := check(post(method, `{"response_size":1, "payload":{"body":"aGVsbG8="}}`), http.StatusOK, "HIT", 1); got != body {
+		t.Errorf("expecting the cached response %q, got %q", body, got)
+	}
+	if got := check(post(method, `{"payload":{"body":"aGVsbG8="},"response_size":1}`, "If-None-Match", etag), http.StatusNotModified, "HIT", 1); got != "" {
+		t.Errorf("expecting no body, got %q", got)
+	}
+	check(post(method, `{"payload":{"body":"aGVsbG8="},"response_size":1}`, "Authorization", "Bearer other"), http.StatusOK, "MISS", 2)
+	check(post(method, `{"payload":{"body":"aGVsbG8="},"response_size":1}`, "Cache-Control", "no-cache"), http.StatusOK, "MISS", 3)
+	check(post(method+"?raw", `{"payload":{"body":"aGVsbG8="},"response_size":1}`), http.StatusOK, "MISS", 4)
+
+	// errors are not cached
+	check(post(method, `{"payload":{"body":"ZmFpbA=="}}`), http.StatusOK, "", 5)
+	check(post(method, `{"payload":{"body":"ZmFpbA=="}}`), http.StatusOK, "", 6)
+
+	// responses of one backend are not served for another
+	check(post(method, `{"payload":{"body":"YmFja2VuZA=="}}`), http.StatusOK, "MISS", 7)
+	check(post(method, `{"payload":{"body":"YmFja2VuZA=="}}`, "Addr", other), http.StatusOK, "MISS", 8)
+	check(post(method, `{"payload":{"body":"YmFja2VuZA=="}}`, "Addr", other), http.StatusOK, "HIT", 8)
+
+	// the response to a body sent before is found without connecting to
+	// the backend
+	before := conns.count()
+	check(post(method, `{"payload":{"body":"aGVsbG8="},"response_size":1}`), http.StatusOK, "HIT", 8)
+	if got := conns.count(); got != before {
+		t.Errorf("expecting no connection to the backend for a hit, got %d", got-before)
+	}
+}
+
+func TestCachePolicy(t *testing.T) {
+	empty := builder.RpcTypeMessage(builder.NewMessage("Empty"), false)
+	readOnly := builder.NewMethod("Get", empty, empty).SetOptions(&descriptorpb.MethodOptions{
+		IdempotencyLevel: descriptorpb.MethodOptions_NO_SIDE_EFFECTS.Enum(),
+	})
+	fd, err := builder.NewFile("svc.proto").SetPackageName("pkg").AddService(
+		builder.NewService("Svc").
+			AddMethod(readOnly).
+			AddMethod(builder.NewMethod("Put", empty, empty)).
+			AddMethod(builder.NewMethod("Watch", empty, builder.RpcTypeMessage(builder.NewMessage("Event"), true)))).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := grpcgateway.DescriptorSourceFromFileDescriptors(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := config.Defaults()
+	cases := map[string]bool{"pkg.Svc/Get": true, "pkg.Svc/Put": false, "pkg.Svc/Watch": false, "pkg.Svc/Nope": false}
+	for method, want := range cases {
+		if policy := cachePolicyFor(conf, source, method); policy.cached() != want || policy.coalesce {
+			t.Errorf("%s: expecting cached to be %v and no coalescing, got %+v", method, want, policy)
+		}
+	}
+	conf.Coalesce.Idempotent = true
+	if policy := cachePolicyFor(conf, source, "pkg.Svc/Get"); !policy.coalesce || strings.Join(policy.vary, ",") != "Authorization" {
+		t.Errorf("expecting Get to be coalesced, got %+v", policy)
+	}
+
+	yes, no := true, false
+	conf.Routes = []config.Route{
+		{Method: "pkg.Svc", Cache: &config.RouteCache{TTL: 5, Vary: []string{"X-User"}}},
+		{Method: "pkg.Svc/Get", Cache: &config.RouteCache{Disabled: true}, Coalesce: &no},
+		{Method: "pkg.Svc/Watch", Cache: &config.RouteCache{}, Coalesce: &yes},
+	}
+	conf.Coalesce.Vary = []string{"authorization", "X-Tenant"}
+	policy := cachePolicyFor(conf, source, "pkg.Svc/Put")
+	if !policy.cached() || policy.ttl.Seconds() != 5 || policy.coalesce || strings.Join(policy.vary, ",") != "Authorization,X-User" {
+		t.Errorf("expecting the route to make Put cached, got %+v", policy)
+	}
+	if policy = cachePolicyFor(conf, source, "pkg.Svc/Get"); policy.cached() || policy.coalesce {
+		t.Errorf("expecting the route to keep Get from being cached and coalesced, got %+v", policy)
+	}
+	if policy = cachePolicyFor(conf, source, "pkg.Svc/Watch"); policy.cached() || policy.coalesce {
+		t.Errorf("expecting streams not to be cached or coalesced, got %+v", policy)
+	}
+	conf.Routes = []config.Route{{Method: "pkg.Svc/Put", Coalesce: &yes}}
+	if policy = cachePolicyFor(conf, source, "pkg.Svc/Put"); policy.cached() || !policy.coalesce || strings.Join(policy.vary, ",") != "authorization,X-Tenant" {
+		t.Errorf("expecting the route to make Put coalesced, got %+v", policy)
+	}
+}
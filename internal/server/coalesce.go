@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"sync"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/cache"
+)
+
+// coalesceStats counts, by method, the calls answered with the response of an
+// identical call in flight rather than by the backend. It is served with the
+// other expvars at /_gateway/admin/vars.
+var coalesceStats = expvar.NewMap("gateway_coalesced_calls")
+
+// flights holds the calls in flight whose response is shared, by key.
+var flights = struct {
+	sync.Mutex
+	m map[string]*flight
+}{m: map[string]*flight{}}
+
+// flight is a call in flight whose response is shared with identical calls.
+type flight struct {
+	done    chan struct{}
+	entry   *cache.Entry
+	waiters int // guarded by flights
+}
+
+// joinFlight waits for the response of the identical call in flight, if
+// there is one, and returns it. Otherwise, or if that call fails, the caller
+// makes the call, leading the returned flight that it must land.
+func joinFlight(ctx context.Context, key string) (*cache.Entry, *flight, error) {
+	for {
+		flights.Lock()
+		f, ok := flights.m[key]
+		if !ok {
+			f = &flight{done: make(chan struct{})}
+			flights.m[key] = f
+			flights.Unlock()
+			return nil, f, nil
+		}
+		f.waiters++
+		flights.Unlock()
+
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+		if f.entry != nil {
+			return f.entry, nil, nil
+		}
+		// errors are not shared, as they may be particular to the call,
+		// so waiters try again, one of them leading the next flight
+	}
+}
+
+// land ends the flight, sharing e with its waiters, or nil if the call failed.
+func (f *flight) land(key string, e *cache.Entry) {
+	flights.Lock()
+	if flights.m[key] == f {
+		delete(flights.m, key)
+	}
+	flights.Unlock()
+	f.entry = e
+	close(f.done)
+}
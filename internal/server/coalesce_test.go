@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/cache"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	gatewaytesting "github.com/LCY2013/http-to-grpc-gateway/internal/testing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+func TestCoalesce(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	conns := &connCounter{}
+	// backends tells the backends apart by address
+	backends := map[string]string{}
+	for _, name := range []string{"a", "b"} {
+		name := name
+		backends[newTestBackend(t, func(s *grpc.Server) {
+			gatewaytesting.RegisterTestServiceServer(s, countingServer{calls: &calls, release: release, name: name})
+			reflection.Register(s)
+		}, grpc.StatsHandler(conns))] = name
+	}
+
+	const method = "testing.TestService/UnaryCall"
+	yes := true
+	conf := config.Defaults()
+	conf.Routes = []config.Route{{Method: method, Coalesce: &yes}}
+	cacheConf = func() *config.Config { return conf }
+	t.Cleanup(func() { cacheConf = serverConf })
+
+	svr := httptest.NewServer(registerWithServe([]string{"http"}))
+	defer svr.Close()
+
+	// the same call is made 3 times to each backend, first before the
+	// gateway knows the method may be coalesced, which it learns from the
+	// descriptors of the method, then after
+	const n = 6
+	for _, round := range []struct {
+		name  string
+		conns int32
+	}{{"cold", n}, {"warm", int32(len(backends))}} {
+		before, beforeCalls, beforeConns := coalescedCalls(method), atomic.LoadInt32(&calls), conns.count()
+		type result struct {
+			code    int
+			state   string
+			body    string
+			backend string
+		}
+		results := make(chan result, n)
+		var wg sync.WaitGroup
+		for addr, name := range backends {
+			for j := 0; j < n/len(backends); j++ {
+				wg.Add(1)
+				go func(addr, name string) {
+					defer wg.Done()
+					req, _ := http.NewRequest("POST", svr.URL+"/"+method, strings.NewReader(`{"payload":{"body":"aGVsbG8="}}`))
+					req.Header.Set("Content-Type", "application/json")
+					req.Header.Set("Addr", addr)
+					resp, err := http.DefaultClient.Do(req)
+					if err != nil {
+						t.Errorf("request failed: %v", err)
+						return
+					}
+					defer resp.Body.Close()
+					b, _ := io.ReadAll(resp.Body)
+					results <- result{resp.StatusCode, resp.Header.Get(cacheHeader), string(b), name}
+				}(addr, name)
+			}
+		}
+
+		// the backends are held until every other call waits for the
+		// first one to each backend
+		deadline := time.Now().Add(2 * time.Second)
+		for waiters() != n-len(backends) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: expecting %d calls to wait, got %d", round.name, n-len(backends), waiters())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		for range backends {
+			release <- struct{}{}
+		}
+		wg.Wait()
+		close(results)
+
+		states := map[string]int{}
+		for r := range results {
+			if r.code != http.StatusOK || !strings.Contains(r.body, `"username":"`+r.backend+`"`) {
+				t.Errorf("%s: expecting the response of backend %s, got %d %q", round.name, r.backend, r.code, r.body)
+			}
+			states[r.state]++
+		}
+		if states["MISS"] != len(backends) || states["SHARED"] != n-len(backends) {
+			t.Errorf("%s: expecting one call to each backend to be shared with the others, got %v", round.name, states)
+		}
+		if got := atomic.LoadInt32(&calls) - beforeCalls; got != int32(len(backends)) {
+			t.Errorf("%s: expecting one call to each backend, got %d", round.name, got)
+		}
+		if got := coalescedCalls(method) - before; got != n-int64(len(backends)) {
+			t.Errorf("%s: expecting %d coalesced calls to be counted, got %d", round.name, n-len(backends), got)
+		}
+		// once the method is known to be coalesced, waiters do not
+		// connect to the backend
+		if got := conns.count() - beforeConns; got != round.conns {
+			t.Errorf("%s: expecting %d connections to the backends, got %d", round.name, round.conns, got)
+		}
+	}
+}
+
+func TestJoinFlight(t *testing.T) {
+	ctx := context.Background()
+	_, leader, err := joinFlight(ctx, "key")
+	if leader == nil || err != nil {
+		t.Fatalf("expecting to lead the flight, got %v %v", leader, err)
+	}
+
+	type joined struct {
+		entry  *cache.Entry
+		flight *flight
+	}
+	waiter := make(chan joined)
+	go func() {
+		e, f, _ := joinFlight(ctx, "key")
+		waiter <- joined{e, f}
+	}()
+	for waiters() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	// failures are not shared, the waiter leads the next flight instead
+	leader.land("key", nil)
+	next := <-waiter
+	if next.entry != nil || next.flight == nil {
+		t.Fatalf("expecting the waiter to lead, got %+v", next)
+	}
+
+	go func() {
+		e, f, _ := joinFlight(ctx, "key")
+		waiter <- joined{e, f}
+	}()
+	for waiters() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	entry := &cache.Entry{Body: []byte("ok")}
+	next.flight.land("key", entry)
+	if got := <-waiter; got.entry != entry || got.flight != nil {
+		t.Errorf("expecting the response to be shared, got %+v", got)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, f, _ := joinFlight(ctx, "key")
+	if _, _, err = joinFlight(cancelled, "key"); err != context.Canceled {
+		t.Errorf("expecting waiting to be cancelled, got %v", err)
+	}
+	f.land("key", nil)
+}
+
+// waiters returns the number of calls waiting for a flight.
+func waiters() int {
+	flights.Lock()
+	defer flights.Unlock()
+	n := 0
+	for _, f := range flights.m {
+		n += f.waiters
+	}
+	return n
+}
+
+func coalescedCalls(method string) int64 {
+	if v, ok := coalesceStats.Get(method).(interface{ Value() int64 }); ok {
+		return v.Value()
+	}
+	return 0
+}
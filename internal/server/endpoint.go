@@ -2,145 +2,421 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
+	"expvar"
 	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
 	"github.com/LCY2013/http-to-grpc-gateway/internal/ack"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/cache"
 	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
 	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
 	"github.com/LCY2013/http-to-grpc-gateway/internal/registry"
-	httpReg "github.com/LCY2013/http-to-grpc-gateway/internal/registry/http"
-	localReg "github.com/LCY2013/http-to-grpc-gateway/internal/registry/local"
 	"github.com/LCY2013/http-to-grpc-gateway/internal/util/async"
 	"github.com/jhump/protoreflect/grpcreflect"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
-	reflectpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
 func Run(args []string) {
-	logger.Info("gateway started...")
-	// 创建一个监听8080端口的服务器
-	err := http.ListenAndServe(":8080", registerWithServe(args[0]))
+	if info := config.Active(); config.Conf() == nil && info.Error != "" {
+		logger.Fatalf("Invalid config: %s", info.Error)
+		return
+	}
+	conf := serverConf()
+	if err := configureLogging(conf); err != nil {
+		logger.Fatal(err)
+		return
+	}
+	registries, err := serverRegistries(args, conf)
+	if err != nil {
+		logger.Fatalf("Invalid registries: %v", err)
+		return
+	}
+	if responseCache == nil && conf.Cache.MaxEntries > 0 {
+		responseCache = cache.NewLRU(conf.Cache.MaxEntries)
+	}
+	logger.Infof("gateway started, finding backends with the %s registries...", strings.Join(registries, ", "))
+	// descriptors are loaded once and reloaded when the files change, rather
+	// than for every request
+	files, err := configuredFiles()
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+	schemas, err := configuredSchemas()
 	if err != nil {
 		logger.Fatal(err)
 		return
 	}
+	for _, f := range append([]*fileSource{files}, schemaFiles(schemas)...) {
+		if err := f.Watch(); err != nil {
+			logger.Errorf("Failed to watch descriptor files, changes will not be reloaded: %v", err)
+		}
+	}
+	// 创建一个监听8080端口的服务器
+	mux := http.NewServeMux()
+	mux.Handle("/openapi.json", openAPIHandler(registries))
+	mux.Handle("/explorer/", explorerHandler(registries))
+	mux.Handle("/_gateway/", introspectionHandler(registries))
+	mux.Handle("/_gateway/admin/protoset", adminOnly(protosetHandler(registries)))
+	mux.Handle("/_gateway/admin/log", adminOnly(logger.LevelHandler()))
+	mux.Handle("/_gateway/admin/config", adminOnly(configInfoHandler()))
+	mux.Handle("/_gateway/admin/vars", adminOnly(expvar.Handler()))
+	mux.Handle(registryPath, adminOnly(registryHandler()))
+	mux.Handle(registryPath+"/", adminOnly(registryHandler()))
+	d := newDrainer()
+	ready := newReadiness(d)
+	mux.Handle("/healthz", healthzHandler())
+	mux.Handle("/readyz", ready)
+	if conf.Health.GRPC {
+		mux.Handle("/", grpcHealthHandler(ready, registerWithServe(registries)))
+	} else {
+		mux.Handle("/", registerWithServe(registries))
+	}
+	h2s := &http2.Server{}
+	srv := &http.Server{
+		Addr: conf.Server.Addr,
+		// h2c lets native gRPC clients speak HTTP/2 without TLS on the same port
+		Handler:           h2c.NewHandler(d.track(accessLog(limitRequests(mux))), h2s),
+		ReadHeaderTimeout: seconds(conf.Server.ReadHeaderTimeout),
+		IdleTimeout:       seconds(conf.Server.IdleTimeout),
+	}
+	var cert *certificate
+	if conf.Server.CertFile != "" {
+		cert = &certificate{}
+		if err = cert.load(conf.Server.CertFile, conf.Server.KeyFile); err != nil {
+			logger.Fatal(err)
+			return
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: cert.get}
+	}
+	config.Subscribe(applyReload(cert))
+	// lets Shutdown send GOAWAY on HTTP/2 connections, including those h2c
+	// takes over from srv
+	if err = http2.ConfigureServer(srv, h2s); err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() {
+		if cert != nil {
+			errc <- srv.ListenAndServeTLS("", "")
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+	select {
+	case err = <-errc:
+		logger.Fatal(err)
+		return
+	case <-ctx.Done():
+	}
+	// a second signal terminates right away
+	stop()
+
+	timeout := seconds(serverConf().Server.DrainTimeout)
+	logger.Infof("Shutting down, waiting up to %s for in-flight calls...", timeout)
+	d.startDraining()
+	// keeps serving until load balancers see that the gateway is not ready
+	time.Sleep(seconds(serverConf().Server.ShutdownDelay))
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// stops accepting connections, and streams on HTTP/2 connections
+	go func() { _ = srv.Shutdown(drainCtx) }()
+	if n := d.wait(drainCtx, cancelGrace); n > 0 {
+		logger.Warnf("Cancelled %d calls still in flight after %s", n, timeout)
+	}
+	_ = srv.Close()
+	// the schema registry may have been reloaded since
+	schemas, _ = configuredSchemas()
+	for _, f := range append([]*fileSource{files}, schemaFiles(schemas)...) {
+		_ = f.Close()
+	}
+	logger.Info("gateway stopped")
+	_ = logger.Sync()
+}
+
+// limitRequests bounds the size of request bodies, except for those of native
+// gRPC calls, whose streams may be long-lived.
+func limitRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if n := serverConf().Limits.MaxRequestBytes; n > 0 && !isGRPC(req) {
+			req.Body = http.MaxBytesReader(writer, req.Body, n)
+		}
+		next.ServeHTTP(writer, req)
+	})
+}
+
+// configureLogging sets up logging from the log section of the config and the
+// -log-level and -log-format flags.
+func configureLogging(conf *config.Config) error {
+	var c logger.Config
+	if conf != nil {
+		c = conf.Log
+	}
+	if *config.LogLevel != "" {
+		c.Level = *config.LogLevel
+	}
+	if *config.LogFormat != "" {
+		c.Format = *config.LogFormat
+	}
+	return logger.Configure(c)
 }
 
-func registerWithServe(registryType string) http.HandlerFunc {
+func registerWithServe(registries []string) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		ctx := request.Context()
-		var register registry.Register
-		switch registryType {
-		case "http":
-			register = httpReg.NewRegisterHttp(request)
-		case "local":
-			register = localReg.NewRegisterLocal(request)
+		register := newRegister(registries, request)
+
+		if isGRPC(request) {
+			proxyGRPC(writer, request, register)
+			return
+		}
+		if p, codec := detectProtocol(request); p != protocolEnvelope {
+			serveWebRPC(writer, request, register, p, codec)
+			return
 		}
 
-		done := make(chan error)
+		ctx, cancel := withCallTimeout(request.Context())
+		defer cancel()
+		method, _, _ := registry.MethodFromRequest(request)
+		opts, err := jsonOptionsFor(request, method)
+		r := &reply{w: writer, opts: opts, env: ack.Ack, access: accessFrom(ctx)}
+		if err != nil {
+			r.fail(status.New(codes.InvalidArgument, err.Error()))
+			return
+		}
+		if r.env, err = envelopeFor(method, opts); err != nil {
+			logger.Ctx(ctx).Error(err)
+			r.env = ack.Ack
+			r.fail(status.New(codes.Internal, "system error"))
+			return
+		}
 
-		conn, err := dial(ctx, register)
+		// buffered, so that invoke never blocks on a handler that returned
+		done := make(chan error, 1)
+
+		reg, err := register.Register()
 		if err != nil {
-			logger.Error(err)
-			_, err = writer.Write([]byte(ack.ToFailResponse("system error")))
-			if err != nil {
-				return
+			logger.Ctx(ctx).Errorf("Failed to get register %+v: %+v", register, err)
+			r.fail(status.New(codes.Unavailable, "system error"))
+			return
+		}
+		// responses found in the cache, or shared by an identical call in
+		// flight, are written without connecting to the backend
+		call, err := lookupCall(ctx, request, reg, r.opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				r.fail(cancelledStatus(ctx))
+			} else {
+				r.fail(status.Convert(err))
 			}
+			return
+		}
+		if call != nil {
+			// waiters call the backend themselves unless the call succeeds
+			defer call.land(nil)
+		}
+		if call != nil && call.hit != nil {
+			r.access.setRPC(reg)
+			r.access.setCode(codes.OK)
+			call.write(writer, call.hit, call.state)
+			return
 		}
+		conn, err := dial(ctx, reg)
+		if err != nil {
+			logger.Ctx(ctx).Error(err)
+			r.fail(status.New(codes.Unavailable, "system error"))
+			return
+		}
+		defer conn.Close()
 
 		// do business
 		async.GO(func() {
-			r, dialErr := register.Register()
-			if dialErr != nil {
-				err = dialErr
-				//_, _ = writer.Write([]byte(err.Error()))
-				return
-			}
-
-			done <- invoke(ctx, request, writer, conn, r)
+			done <- invoke(ctx, request, r, conn, reg, call)
 		})
 
-		// 通过select监听多个channel
-		select {
-		case <-time.After(3 * time.Second):
-			// 如果两秒后接受到了一个消息后，意味请求已经处理完成
-			// 我们写入"request processed"作为响应
-			_, err = writer.Write([]byte(ack.ToFailResponse("request timeout")))
-			if err != nil {
-				return
-			}
-		case res := <-ctx.Done():
+		// invoke is made on ctx, so it returns soon after a timeout or a
+		// cancellation; it is waited for even then, so that the reply is
+		// never written by both
+		err = <-done
+		if ctx.Err() != nil {
 			// 如果处理完成前取消了，在STDERR中记录请求被取消的消息
-			logger.Errorf("request cancelled: %s\n", res)
-			_, err = writer.Write([]byte(ack.ToFailResponse("request cancelled")))
-			if err != nil {
-				return
-			}
-		case err = <-done:
+			logger.Ctx(ctx).Errorf("request cancelled: %s", context.Cause(ctx))
 			if err != nil {
-				_, err = writer.Write([]byte(ack.ToFailResponse(err.Error())))
+				err = cancelledStatus(ctx).Err()
 			}
 		}
+		if err != nil {
+			r.fail(status.Convert(err))
+		} else {
+			r.access.setCode(codes.OK)
+		}
+	}
+}
+
+// withCallTimeout bounds ctx by backends.call_timeout, if it is set.
+func withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t := serverConf().Backends.CallTimeout; t > 0 {
+		return context.WithTimeout(ctx, seconds(t))
 	}
+	return context.WithCancel(ctx)
 }
 
-func dial(ctx context.Context, register registry.Register) (*grpc.ClientConn, error) {
+// seconds converts a duration in seconds, as durations are given in the
+// config.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// serverConf returns the config, or the defaults if it failed to load.
+func serverConf() *config.Config {
+	if conf := config.Conf(); conf != nil {
+		return conf
+	}
+	return config.Defaults()
+}
+
+// dial connects to the backend that registry resolved to.
+func dial(ctx context.Context, registry *registry.Registry) (*grpc.ClientConn, error) {
+	cc, err := dialBackend(ctx, registry.Addr)
+	if err != nil {
+		logger.Ctx(ctx).Errorf("Failed to dial target host %q: %+v", registry.Addr, err)
+		return nil, err
+	}
+	return cc, nil
+}
+
+// dialBackend connects to the backend at addr as the backends section of the
+// config says.
+func dialBackend(ctx context.Context, addr string) (*grpc.ClientConn, error) {
+	conf := serverConf().Backends
 	dialTime := 10 * time.Second
-	if *config.ConnectTimeout > 0 {
-		dialTime = time.Duration(*config.ConnectTimeout * float64(time.Second))
+	if conf.ConnectTimeout > 0 {
+		dialTime = time.Duration(conf.ConnectTimeout * float64(time.Second))
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, dialTime)
 	defer cancel()
 
 	var opts []grpc.DialOption
-	if *config.KeepaliveTime > 0 {
-		timeout := time.Duration(*config.KeepaliveTime * float64(time.Second))
+	if conf.KeepaliveTime > 0 {
+		timeout := time.Duration(conf.KeepaliveTime * float64(time.Second))
 		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
 			Time:    timeout,
 			Timeout: timeout,
 		}))
 	}
 
-	if *config.MaxMsgSz > 0 {
-		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(*config.MaxMsgSz)))
+	if conf.MaxMsgSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(conf.MaxMsgSize)))
 	}
 
 	UA := "github.com/LCY2013/http-to-grpc-gateway/" + config.Version
 	if config.Version == config.NoVersion {
 		UA = "github.com/LCY2013/http-to-grpc-gateway/dev-build (no version set)"
 	}
-	if *config.UserAgent != "" {
-		UA = *config.UserAgent + " " + UA
+	if conf.UserAgent != "" {
+		UA = conf.UserAgent + " " + UA
 	}
 	opts = append(opts, grpc.WithUserAgent(UA))
 
+	var creds credentials.TransportCredentials
+	if tls := conf.TLS; tls.Enabled {
+		tlsConf, err := grpcgateway.ClientTLSConfig(tls.Insecure, tls.CACert, tls.Cert, tls.Key)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConf)
+		// the authority is also the name certificates are verified against
+		if tls.ServerName != "" {
+			opts = append(opts, grpc.WithAuthority(tls.ServerName))
+		}
+	}
+
 	network := "tcp"
 	if config.IsUnixSocket != nil && config.IsUnixSocket() {
 		network = "unix"
 	}
+	return grpcgateway.BlockingDial(ctx, network, addr, creds, opts...)
+}
+
+// descriptorSource builds the source used to resolve methods and messages for
+// the backend at addr, serving the given services, whose connection is cc.
+// In order of precedence, descriptors come from a protoset uploaded for the
+// backend through the admin API, from the schema registry, with reflection as
+// an optional fallback, or from the configured protoset or proto files,
+// server reflection, or both. Reflection is not used if cc is nil. The
+// returned func releases the reflection client.
+func descriptorSource(ctx context.Context, addr string, services []string, cc *grpc.ClientConn) (grpcgateway.DescriptorSource, func(), error) {
+	if source := uploadedSource(addr); source != nil {
+		return source, func() {}, nil
+	}
+	var refClient *grpcreflect.Client
+	reset := func() {
+		if refClient != nil {
+			refClient.Reset()
+			refClient = nil
+		}
+	}
+	reflectionSource := func() grpcgateway.DescriptorSource {
+		backends := serverConf().Backends
+		md := grpcgateway.MetadataFromHeaders(append(append([]string(nil), backends.Headers...), backends.ReflectHeaders...))
+		refCtx := metadata.NewOutgoingContext(ctx, md)
+		var version grpcgateway.ReflectionVersion
+		refClient, version = grpcgateway.NewReflectionClient(refCtx, cc)
+		if *config.Verbose || *config.VeryVerbose {
+			logger.Debugf("Using server reflection %s for %s", version, addr)
+		}
+		return grpcgateway.DescriptorSourceFromServer(ctx, refClient)
+	}
 
-	registry, err := register.Register()
+	schema, fallback, err := schemaSource(addr, services)
 	if err != nil {
-		logger.Errorf("Failed to get register %+v: %+v", register, err)
-		return nil, err
+		logger.Errorf("%+v Failed to process the schema registry.", err)
+		return nil, nil, err
+	}
+	if schema != nil {
+		if !fallback || cc == nil {
+			return schema, reset, nil
+		}
+		// CompositeSource prefers its Reflection source, which here is the
+		// schema, and falls back to its File source
+		return config.CompositeSource{Reflection: schema, File: reflectionSource()}, reset, nil
 	}
 
-	cc, err := grpcgateway.BlockingDial(ctx, network, registry.Addr, nil, opts...)
+	files, err := configuredFiles()
 	if err != nil {
-		logger.Errorf("Failed to dial target host %q: %+v", registry.Addr, err)
-		return nil, err
+		logger.Errorf("%+v Failed to process proto descriptor sets or source files.", err)
+		return nil, nil, err
 	}
-	return cc, nil
+	fileSource := files.Source()
+	if !reflectionEnabled() || cc == nil {
+		return fileSource, reset, nil
+	}
+	if fileSource != nil {
+		return config.CompositeSource{Reflection: reflectionSource(), File: fileSource}, reset, nil
+	}
+	return reflectionSource(), reset, nil
 }
 
-func invoke(ctx context.Context, req *http.Request, writer http.ResponseWriter, cc *grpc.ClientConn, registry *registry.Registry) error {
+// invoke calls the method of the backend registry resolves to, whose
+// connection is cc, writing the response through r. call is the call looked
+// up before connecting to the backend, if any.
+func invoke(ctx context.Context, req *http.Request, r *reply, cc *grpc.ClientConn, registry *registry.Registry, call *cachedCall) error {
 	// Invoke an RPC
 	if cc == nil {
 		return nil
@@ -154,52 +430,15 @@ func invoke(ctx context.Context, req *http.Request, writer http.ResponseWriter,
 		verbosityLevel = 2
 	}
 
-	var descSource grpcgateway.DescriptorSource
-	var refClient *grpcreflect.Client
-	var fileSource grpcgateway.DescriptorSource
-	if len(config.Protoset) > 0 {
-		var err error
-		fileSource, err = grpcgateway.DescriptorSourceFromProtoSets(config.Protoset...)
-		if err != nil {
-			logger.Errorf("%+v Failed to process proto descriptor sets.", err)
-			fmt.Fprintf(writer, ack.ToFailResponse(err.Error()))
-			return nil
-		}
-	} else if len(config.ProtoFiles) > 0 {
-		var err error
-		fileSource, err = grpcgateway.DescriptorSourceFromProtoFiles(config.ImportPaths, config.ProtoFiles...)
-		if err != nil {
-			logger.Errorf("%+v Failed to process proto source files.", err)
-			fmt.Fprintf(writer, ack.ToFailResponse(err.Error()))
-			return nil
-		}
-	}
-	if config.Reflection.Val {
-		md := grpcgateway.MetadataFromHeaders(append(config.AddlHeaders, config.ReflHeaders...))
-		refCtx := metadata.NewOutgoingContext(ctx, md)
-		refClient = grpcreflect.NewClientV1Alpha(refCtx, reflectpb.NewServerReflectionClient(cc))
-		reflSource := grpcgateway.DescriptorSourceFromServer(ctx, refClient)
-		if fileSource != nil {
-			descSource = config.CompositeSource{Reflection: reflSource, File: fileSource}
-		} else {
-			descSource = reflSource
-		}
-	} else {
-		descSource = fileSource
+	accessFrom(ctx).setRPC(registry)
+	descSource, resetSource, err := descriptorSource(ctx, registry.Addr, []string{registry.Service}, cc)
+	if err != nil {
+		return err
 	}
 
-	// arrange for the RPCs to be cleanly shutdown
-	reset := func() {
-		if refClient != nil {
-			refClient.Reset()
-			refClient = nil
-		}
-		if cc != nil {
-			cc.Close()
-			cc = nil
-		}
-	}
-	defer reset()
+	// arrange for the RPCs to be cleanly shutdown; cc is closed by the
+	// caller that dialled it
+	defer resetSource()
 
 	/*services, err := refClient.ListServices()
 	if err == nil {
@@ -220,53 +459,86 @@ func invoke(ctx context.Context, req *http.Request, writer http.ResponseWriter,
 	// between each message, so output could potentially be piped
 	// to another grpcgateway process
 	includeSeparators := verbosityLevel == 0
-	options := grpcgateway.FormatOptions{
-		EmitJSONDefaultFields: *config.EmitDefaults,
-		IncludeTextSeparator:  includeSeparators,
-		AllowUnknownFields:    *config.AllowUnknownFields,
-	}
+	options := r.opts.formatOptions(includeSeparators)
 
-	rf, formatter, err := grpcgateway.RequestParserAndFormatter(grpcgateway.Format(*config.Format), descSource, req.Body, options)
+	// the parser is chosen by Content-Type and the formatter by Accept, with
+	// the -format flag as the default for both
+	reqFormat := requestFormat(req, grpcgateway.Format(*config.Format))
+	respFormat := responseFormat(req, grpcgateway.Format(*config.Format))
+	// binary messages are length-delimited only on the sides that stream
+	reqOptions, respOptions := options, options
+	if mtd := findMethod(descSource, registry.Method); mtd != nil {
+		reqOptions.SingleMessage = !mtd.IsClientStreaming()
+		respOptions.SingleMessage = !mtd.IsServerStreaming()
+	}
+	rf, _, err := grpcgateway.RequestParserAndFormatter(reqFormat, descSource, req.Body, reqOptions)
 	if err != nil {
-		logger.Errorf("%+v Failed to construct request parser and formatter for %q", err, *config.Format)
-		fmt.Fprintf(writer, ack.ToFailResponse(err.Error()))
-		return nil
+		logger.Ctx(ctx).Errorf("%+v Failed to construct request parser for %q", err, reqFormat)
+		return err
 	}
-	h := &grpcgateway.DefaultEventHandler{
-		Out:            writer,
-		Formatter:      formatter,
-		VerbosityLevel: verbosityLevel,
-	}
-
-	switch grpcgateway.Format(*config.Format) {
-	case grpcgateway.FormatJSON:
-		writer.Header().Add("Content-Type", "application/json; charset=utf-8")
-	case grpcgateway.FormatText:
-		writer.Header().Add("Content-Type", "text/plain; charset=utf-8")
-	default:
-		for k, v := range req.Header {
-			if v != nil && len(v) > 0 {
-				writer.Header().Add(k, v[0])
-			}
-		}
+	_, formatter, err := grpcgateway.RequestParserAndFormatter(respFormat, descSource, nil, respOptions)
+	if err != nil {
+		logger.Ctx(ctx).Errorf("%+v Failed to construct formatter for %q", err, respFormat)
+		return err
 	}
-
-	rpcHeader := append(config.AddlHeaders, config.RpcHeaders...)
-	for k, v := range req.Header {
-		if strings.ToTitle(k) != "X-" {
-			continue
-		}
-		if v != nil && len(v) > 0 {
-			rpcHeader = append(rpcHeader, k+":"+v[0])
-		}
+	// responses to cacheable methods are buffered, to be stored before they
+	// are written to the client
+	orig := r
+	conf := cacheConf()
+	policy := cachePolicyFor(conf, descSource, registry.Method)
+	if responseCache == nil {
+		policy.ttl = 0
+	}
+	rememberPolicy(conf, registry.Addr, registry.Method, policy)
+	if !policy.cached() && !policy.coalesce {
+		call = nil
+	} else if call == nil {
+		// waiting for another call ends with the call itself
+		call = newCachedCall(req.WithContext(ctx), registry.Method, policy, cacheVariant(registry.Addr, req, r.opts))
+	}
+	if call != nil {
+		call.policy = policy
+		// waiters call the backend themselves unless the call succeeds
+		defer call.land(nil)
+		buffered := *r
+		buffered.w = call.buf
+		r = &buffered
+	}
+	h := &replyHandler{
+		DefaultEventHandler: &grpcgateway.DefaultEventHandler{
+			Out:            r.w,
+			Formatter:      formatter,
+			VerbosityLevel: verbosityLevel,
+		},
+		reply:    r,
+		binary:   respFormat == grpcgateway.FormatBinary,
+		pipeline: transformFor(registry.Method),
+		log:      logFormatter(descSource),
 	}
 
-	err = grpcgateway.InvokeRPC(ctx, descSource, cc, registry.Method, rpcHeader, h, rf.Next)
-	if err != nil {
-		logger.Errorf("%+v Error invoking method %q", err, registry.Method)
-		fmt.Fprintf(writer, ack.ToFailResponse(err.Error()))
+	r.w.Header().Set("Content-Type", contentType(respFormat))
+
+	rpcHeader := rpcHeaders(req)
+
+	var invalid error
+	supplier := validated(logged(h.pipeline.Request(rf.Next, req), h.log), &invalid)
+	if call != nil {
+		supplier = call.supplier(supplier)
+	}
+	err = grpcgateway.InvokeRPC(ctx, descSource, cc, registry.Method, rpcHeader, h, supplier)
+	accessFrom(ctx).setCounts(rf.NumRequests(), h.NumResponses)
+	if call != nil && call.hit != nil {
+		call.write(orig.w, call.hit, call.state)
+		orig.wroteHeader = true
 		return nil
 	}
+	if err != nil {
+		if invalid != nil {
+			return invalid
+		}
+		logger.Ctx(ctx).Errorf("%+v Error invoking method %q", err, registry.Method)
+		return err
+	}
 	reqSuffix := ""
 	respSuffix := ""
 	reqCount := rf.NumRequests()
@@ -277,14 +549,18 @@ func invoke(ctx context.Context, req *http.Request, writer http.ResponseWriter,
 		respSuffix = "s"
 	}
 	if verbosityLevel > 0 {
-		logger.Infof("Sent %d request%s and received %d response%s\n", reqCount, reqSuffix, h.NumResponses, respSuffix)
+		logger.Ctx(ctx).Infof("Sent %d request%s and received %d response%s\n", reqCount, reqSuffix, h.NumResponses, respSuffix)
 	}
 	if h.Status.Code() != codes.OK {
-		if *config.FormatError {
-			grpcgateway.PrintStatus(writer, h.Status, formatter)
-		} else {
-			grpcgateway.PrintStatus(writer, h.Status, formatter)
+		stat := h.Status
+		if ctx.Err() != nil {
+			stat = cancelledStatus(ctx)
 		}
+		r.fail(stat)
+	}
+	if call != nil {
+		call.finish(orig.w, h.Status.Code() == codes.OK)
+		orig.wroteHeader = true
 	}
 
 	return nil
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gatewaytesting "github.com/LCY2013/http-to-grpc-gateway/internal/testing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+)
+
+// newTestBackend starts a gRPC server with the given options, returning its
+// address. register registers its services; if nil, they are the test
+// service and server reflection.
+func newTestBackend(t *testing.T, register func(*grpc.Server), opts ...grpc.ServerOption) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	svr := grpc.NewServer(opts...)
+	if register == nil {
+		register = func(s *grpc.Server) {
+			gatewaytesting.RegisterTestServiceServer(s, gatewaytesting.TestServer{})
+			reflection.Register(s)
+		}
+	}
+	register(svr)
+	go svr.Serve(l)
+	t.Cleanup(svr.Stop)
+	return l.Addr().String()
+}
+
+func TestEnvelope(t *testing.T) {
+	addr := newTestBackend(t, nil)
+	svr := httptest.NewServer(registerWithServe([]string{"http"}))
+	defer svr.Close()
+
+	post := func(path string) (int, string) {
+		body := `{"payload":{"body":"aGVsbG8="}}`
+		if strings.Contains(path, "Streaming") {
+			body = `{"response_parameters":[{"size":1},{"size":2}]}`
+		}
+		req, _ := http.NewRequest("POST", svr.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Addr", addr)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	code, body := post("/testing.TestService/UnaryCall")
+	if expected := `{"code":200,"msg":"ok","data":{"payload":{"body":"aGVsbG8="}`; code != http.StatusOK || !strings.HasPrefix(body, expected) {
+		t.Errorf("ack envelope: expecting %q, got %d %q", expected, code, body)
+	}
+	// the body is the compact envelope alone, unless pretty is set
+	if strings.ContainsAny(body, "\n ") {
+		t.Errorf("ack envelope: expecting compact JSON, got %q", body)
+	}
+	if _, body := post("/testing.TestService/UnaryCall?pretty"); !strings.HasPrefix(body, "{\n  \"code\": 200,") {
+		t.Errorf("ack envelope: expecting indented JSON, got %q", body)
+	}
+	// the messages of streams are separated by newlines
+	code, body = post("/testing.TestService/StreamingOutputCall")
+	if lines := strings.Split(body, "\n"); code != http.StatusOK || len(lines) != 2 || !json.Valid([]byte(lines[0])) || !json.Valid([]byte(lines[1])) {
+		t.Errorf("ack envelope: expecting a line per message, got %d %q", code, body)
+	}
+
+	code, body = post("/testing.TestService/UnaryCall?raw")
+	if expected := `{"payload":{"body":"aGVsbG8="}`; code != http.StatusOK || !strings.HasPrefix(body, expected) {
+		t.Errorf("no envelope: expecting %q, got %d %q", expected, code, body)
+	}
+
+	code, body = post("/testing.TestService/NoSuchMethod?raw")
+	var stat struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(body), &stat); err != nil || code == http.StatusOK || stat.Code == 0 || stat.Message == "" {
+		t.Errorf("no envelope: unexpected error response %d %q", code, body)
+	}
+}
+
+func TestForwardHeaders(t *testing.T) {
+	// the backend records the metadata it is sent
+	sent := make(chan metadata.MD, 1)
+	addr := newTestBackend(t, nil, grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		sent <- md
+		return handler(ctx, req)
+	}))
+	svr := httptest.NewServer(registerWithServe([]string{"http"}))
+	defer svr.Close()
+
+	req, _ := http.NewRequest("POST", svr.URL+"/testing.TestService/UnaryCall", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Addr", addr)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Add("X-Tenant", "acme")
+	req.Header.Add("X-Tenant", "other")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	md := <-sent
+	if got := md.Get("authorization"); len(got) != 1 || got[0] != "Bearer token" {
+		t.Errorf("expecting the authorization header to be forwarded, got %v", got)
+	}
+	if got := md.Get("x-tenant"); len(got) != 2 || got[0] != "acme" || got[1] != "other" {
+		t.Errorf("expecting every value of x-tenant to be forwarded, got %v", got)
+	}
+	if got := md.Get("addr"); len(got) != 0 {
+		t.Errorf("expecting the addr header not to be forwarded, got %v", got)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	addr := newTestBackend(t, nil)
+	svr := httptest.NewServer(openAPIHandler([]string{"http"}))
+	defer svr.Close()
+
+	resp, err := http.Get(svr.URL + "/openapi.json?addr=" + addr)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var doc struct {
+		Paths map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatalf("failed to decode document: %v", err)
+	}
+	if _, ok := doc.Paths["/testing.TestService/UnaryCall"]; !ok {
+		t.Errorf("expecting a path for UnaryCall, got %v", doc.Paths)
+	}
+
+	resp, err = http.Get(svr.URL + "/openapi.json")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expecting 400 without a backend, got %d", resp.StatusCode)
+	}
+}
+
+func TestCancelledCall(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	defer close(release)
+	addr := newTestBackend(t, func(s *grpc.Server) {
+		gatewaytesting.RegisterTestServiceServer(s, countingServer{calls: &calls, release: release})
+		reflection.Register(s)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/testing.TestService/UnaryCall?raw", strings.NewReader(`{"payload":{"body":"aGVsbG8="}}`)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Addr", addr)
+	rec := httptest.NewRecorder()
+	go func() {
+		for atomic.LoadInt32(&calls) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	registerWithServe([]string{"http"}).ServeHTTP(rec, req)
+
+	// the call has returned with the handler, so the recorder is only
+	// written by the handler
+	if body := rec.Body.String(); !strings.Contains(body, "request cancelled") {
+		t.Errorf("expecting the call to be cancelled, got %d %q", rec.Code, body)
+	}
+}
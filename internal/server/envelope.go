@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/ack"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/transform"
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 Formatter is built on the v1 API
+	"google.golang.org/grpc/status"
+)
+
+// templateEnvelopes caches envelopes parsed from config, keyed by their
+// config.EnvelopeConfig.
+var templateEnvelopes sync.Map
+
+// envelopeFor returns the envelope for a request to the given method: the
+// top-level envelope from config, unless a matching route sets its own, or
+// none at all if the raw option is set.
+func envelopeFor(method string, opts jsonOptions) (ack.Envelope, error) {
+	if opts.raw {
+		return ack.Raw, nil
+	}
+	var ec config.EnvelopeConfig
+	if conf := config.Conf(); conf != nil {
+		ec = conf.Envelope
+		for _, r := range conf.MatchRoutes(method) {
+			if r.Envelope != nil {
+				ec = *r.Envelope
+			}
+		}
+	}
+	return newEnvelope(ec)
+}
+
+// transformFor returns the transforms of the routes matching the given
+// method, or nil if there are none.
+func transformFor(method string) *transform.Pipeline {
+	conf := config.Conf()
+	if conf == nil {
+		return nil
+	}
+	var transforms []config.Transform
+	for _, r := range conf.MatchRoutes(method) {
+		transforms = append(transforms, r.Transform)
+	}
+	return transform.New(transforms...)
+}
+
+func newEnvelope(ec config.EnvelopeConfig) (ack.Envelope, error) {
+	switch ec.Name {
+	case "":
+		return ack.Ack, nil
+	case "template":
+		if e, ok := templateEnvelopes.Load(ec); ok {
+			return e.(ack.Envelope), nil
+		}
+		e, err := ack.NewTemplateEnvelope(ec.Success, ec.Failure)
+		if err != nil {
+			return nil, err
+		}
+		templateEnvelopes.Store(ec, e)
+		return e, nil
+	}
+	if e, ok := ack.LookupEnvelope(ec.Name); ok {
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown envelope %q", ec.Name)
+}
+
+// reply writes the body of a response in the gateway's own protocol, wrapping
+// responses and errors in the envelope selected for the request.
+type reply struct {
+	w           http.ResponseWriter
+	opts        jsonOptions
+	env         ack.Envelope
+	wroteHeader bool
+	// wroteBody is set once a response message is written, after which
+	// anything written is preceded by a newline
+	wroteBody bool
+	access    *accessEntry
+}
+
+// wrap returns the body for one formatted response message. Messages of
+// streams are separated by newlines, so that they can be read incrementally,
+// while the body of unary calls is the envelope alone.
+func (r *reply) wrap(data string) string {
+	body := r.separator() + r.indent(r.env.Success(data))
+	r.wroteHeader, r.wroteBody = true, true
+	return body
+}
+
+func (r *reply) separator() string {
+	if r.wroteBody {
+		return "\n"
+	}
+	return ""
+}
+
+// fail writes an error. The HTTP status code of the envelope is only used if
+// no response has been written yet.
+func (r *reply) fail(stat *status.Status) {
+	r.access.setCode(stat.Code())
+	code, body := r.env.Failure(stat)
+	if !r.wroteHeader {
+		r.w.Header().Set("Content-Type", contentType(grpcgateway.FormatJSON))
+		r.w.WriteHeader(code)
+		r.wroteHeader = true
+	}
+	_, _ = fmt.Fprint(r.w, r.separator()+r.indent(body))
+}
+
+// indent indents s if the pretty option is set and s is JSON.
+func (r *reply) indent(s string) string {
+	if !r.opts.pretty || !json.Valid([]byte(s)) {
+		return s
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(s), "", "  "); err != nil {
+		return s
+	}
+	return buf.String()
+}
+
+// replyHandler writes response messages through a reply. Binary responses
+// cannot be embedded in an envelope, which is then only used for errors.
+type replyHandler struct {
+	*grpcgateway.DefaultEventHandler
+	reply    *reply
+	binary   bool
+	pipeline *transform.Pipeline
+	// log formats messages for the log, if they are logged
+	log grpcgateway.Formatter
+}
+
+func (h *replyHandler) OnReceiveResponse(resp proto.Message) {
+	h.NumResponses++
+	logMessage(h.log, "response", h.NumResponses, resp)
+	resp, err := h.pipeline.Response(resp)
+	if err != nil {
+		logger.Errorf("Failed to transform response message %d: %v", h.NumResponses, err)
+		return
+	}
+	respStr, err := h.Formatter(resp)
+	if err != nil {
+		logger.Errorf("Failed to format response message %d: %v", h.NumResponses, err)
+		return
+	}
+	if h.binary {
+		h.reply.wroteHeader = true
+		_, _ = fmt.Fprint(h.Out, respStr)
+	} else {
+		_, _ = fmt.Fprint(h.Out, h.reply.wrap(respStr))
+	}
+}
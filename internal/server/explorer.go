@@ -0,0 +1,140 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	"github.com/jhump/protoreflect/desc"
+)
+
+// explorerPage is the API explorer, a single page that lists the methods
+// reachable through the gateway and invokes them with the Connect protocol,
+// so that response headers and trailers are visible to the browser.
+//
+//go:embed explorer.html
+var explorerPage []byte
+
+type explorerService struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Methods     []explorerMethod `json:"methods"`
+}
+
+type explorerMethod struct {
+	Name            string          `json:"name"`
+	Description     string          `json:"description,omitempty"`
+	RequestType     string          `json:"requestType"`
+	ResponseType    string          `json:"responseType"`
+	ClientStreaming bool            `json:"clientStreaming"`
+	ServerStreaming bool            `json:"serverStreaming"`
+	Template        json.RawMessage `json:"template"`
+}
+
+// explorerHandler serves the API explorer under /explorer/: the page itself,
+// and the services it lists at /explorer/services.
+func explorerHandler(registries []string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/explorer/", func(writer http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/explorer/" {
+			http.NotFound(writer, req)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = writer.Write(explorerPage)
+	})
+	mux.HandleFunc("/explorer/services", func(writer http.ResponseWriter, req *http.Request) {
+		// errors are reported in the body too, since the page needs to know
+		// whether to ask for the backend address either way
+		body := struct {
+			AddrHeader bool              `json:"addrHeader"`
+			Services   []explorerService `json:"services,omitempty"`
+			Error      string            `json:"error,omitempty"`
+		}{AddrHeader: hasRegistry(registries, "http")}
+		code := http.StatusOK
+
+		sources, closeSources, err := backendSources(req.Context(), req, registries)
+		if err != nil {
+			body.Error, code = err.Error(), http.StatusBadRequest
+		} else {
+			defer closeSources()
+			for _, s := range sources {
+				svcs, err := explorerServices(s)
+				if err != nil {
+					logger.Errorf("Failed to list services for the explorer: %v", err)
+					body.Services, body.Error, code = nil, err.Error(), http.StatusBadGateway
+					break
+				}
+				body.Services = append(body.Services, svcs...)
+			}
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(code)
+		_ = json.NewEncoder(writer).Encode(body)
+	})
+	return mux
+}
+
+// explorerServices describes the services of a backend, with a request
+// template for each method.
+func explorerServices(s backendSource) ([]explorerService, error) {
+	names, err := grpcgateway.ListServices(s.source)
+	if err != nil {
+		return nil, err
+	}
+	formatter := grpcgateway.NewJSONFormatter(true, grpcgateway.AnyResolverFromDescriptorSource(s.source))
+
+	var services []explorerService
+	for _, name := range names {
+		if !routable(name, s.services) {
+			continue
+		}
+		dsc, err := s.source.FindSymbol(name)
+		if err != nil {
+			return nil, err
+		}
+		sd, ok := dsc.(*desc.ServiceDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a service", name)
+		}
+		svc := explorerService{Name: name, Description: leadingComment(sd)}
+		for _, md := range sd.GetMethods() {
+			tmpl, err := formatter(grpcgateway.MakeTemplate(md.GetInputType()))
+			if err != nil {
+				return nil, fmt.Errorf("failed to make a template for %s: %v", md.GetFullyQualifiedName(), err)
+			}
+			svc.Methods = append(svc.Methods, explorerMethod{
+				Name:            md.GetName(),
+				Description:     leadingComment(md),
+				RequestType:     md.GetInputType().GetFullyQualifiedName(),
+				ResponseType:    md.GetOutputType().GetFullyQualifiedName(),
+				ClientStreaming: md.IsClientStreaming(),
+				ServerStreaming: md.IsServerStreaming(),
+				Template:        json.RawMessage(tmpl),
+			})
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+// routable reports whether svc is one of services, or if services is nil.
+func routable(svc string, services []string) bool {
+	if services == nil {
+		return true
+	}
+	for _, s := range services {
+		if strings.EqualFold(s, svc) {
+			return true
+		}
+	}
+	return false
+}
+
+func leadingComment(d desc.Descriptor) string {
+	return strings.TrimSpace(d.GetSourceInfo().GetLeadingComments())
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExplorer(t *testing.T) {
+	addr := newTestBackend(t, nil)
+	svr := httptest.NewServer(explorerHandler([]string{"http"}))
+	defer svr.Close()
+
+	resp, err := http.Get(svr.URL + "/explorer/")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("expecting the page, got %s", resp.Header.Get("Content-Type"))
+	}
+
+	resp, err = http.Get(svr.URL + "/explorer/services?addr=" + addr)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AddrHeader bool              `json:"addrHeader"`
+		Services   []explorerService `json:"services"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode services: %v", err)
+	}
+	if !body.AddrHeader {
+		t.Error("expecting the Addr header to be required with the http registry")
+	}
+	var unary *explorerMethod
+	for _, svc := range body.Services {
+		for i, m := range svc.Methods {
+			if svc.Name == "testing.TestService" && m.Name == "UnaryCall" {
+				unary = &svc.Methods[i]
+			}
+		}
+	}
+	if unary == nil {
+		t.Fatalf("expecting UnaryCall to be listed, got %+v", body.Services)
+	}
+	var tmpl map[string]interface{}
+	if err := json.Unmarshal(unary.Template, &tmpl); err != nil || len(tmpl) == 0 {
+		t.Errorf("expecting a request template, got %s (%v)", unary.Template, err)
+	}
+
+	resp, err = http.Get(svr.URL + "/explorer/services")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expecting 400 without a backend, got %d", resp.StatusCode)
+	}
+}
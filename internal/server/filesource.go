@@ -0,0 +1,246 @@
+package server
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay is how long changes must settle before the files are reloaded,
+// since editors and tools often write a file in several steps.
+const reloadDelay = 200 * time.Millisecond
+
+// fileSource holds the descriptors of the configured protosets or proto
+// files. They are loaded once and, once watched, reloaded whenever the files
+// change. A reload that fails is logged and the previous descriptors are kept.
+type fileSource struct {
+	protosets   []string
+	protoFiles  []string
+	importPaths []string
+
+	// current holds a loadedSource, swapped as a whole on reload
+	current atomic.Value
+
+	mu      sync.Mutex
+	watcher *fsnotify.Watcher
+	timer   *time.Timer
+
+	// reloading serializes reloads, so that a slow one cannot overwrite the
+	// result of a later one
+	reloading sync.Mutex
+}
+
+type loadedSource struct {
+	source grpcgateway.DescriptorSource
+}
+
+// newFileSource loads the given protosets or proto files. Each may also be a
+// directory, in which case all the protosets or proto files under it are
+// loaded; a directory of proto files is also used as an import path.
+func newFileSource(protosets, protoFiles, importPaths []string) (*fileSource, error) {
+	f := &fileSource{protosets: protosets, protoFiles: protoFiles, importPaths: importPaths}
+	source, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	f.current.Store(loadedSource{source})
+	return f, nil
+}
+
+// Source returns the current descriptors, or nil if no files are configured.
+func (f *fileSource) Source() grpcgateway.DescriptorSource {
+	return f.current.Load().(loadedSource).source
+}
+
+func (f *fileSource) load() (grpcgateway.DescriptorSource, error) {
+	switch {
+	case len(f.protosets) > 0:
+		files, _, err := expandFiles(f.protosets, ".protoset")
+		if err != nil {
+			return nil, err
+		}
+		return grpcgateway.DescriptorSourceFromProtoSets(files...)
+	case len(f.protoFiles) > 0:
+		files, dirs, err := expandFiles(f.protoFiles, ".proto")
+		if err != nil {
+			return nil, err
+		}
+		return grpcgateway.DescriptorSourceFromProtoFiles(append(append([]string(nil), f.importPaths...), dirs...), files...)
+	}
+	return nil, nil
+}
+
+// expandFiles replaces the directories among paths with the files under them
+// that have the given extension, named relative to the directory, which is
+// returned among dirs.
+func expandFiles(paths []string, ext string) (files, dirs []string, err error) {
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		var found []string
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || filepath.Ext(path) != ext {
+				return err
+			}
+			if ext == ".proto" {
+				// proto files are named relative to their import path
+				if path, err = filepath.Rel(p, path); err != nil {
+					return err
+				}
+			}
+			found = append(found, filepath.ToSlash(path))
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		sort.Strings(found)
+		files = append(files, found...)
+		dirs = append(dirs, p)
+	}
+	return files, dirs, nil
+}
+
+// Watch reloads the descriptors whenever the configured files, or files in
+// the configured directories or import paths, change, until Close is called.
+func (f *fileSource) Watch() error {
+	if len(f.protosets) == 0 && len(f.protoFiles) == 0 {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.watcher = watcher
+	f.mu.Unlock()
+	f.addWatches()
+
+	go func() {
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if ev.Op&fsnotify.Create != 0 {
+					if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+						// a new directory may hold files to load
+						f.addWatches()
+						f.scheduleReload()
+						continue
+					}
+				}
+				if ext := filepath.Ext(ev.Name); ext == ".proto" || ext == ".protoset" {
+					f.scheduleReload()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Errorf("Failed to watch descriptor files: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// addWatches watches the directories the descriptors may be loaded from.
+// Directories are watched rather than files, since files are often replaced
+// rather than written to.
+func (f *fileSource) addWatches() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.watcher == nil {
+		return
+	}
+	add := func(dir string) {
+		if err := f.watcher.Add(dir); err != nil {
+			logger.Errorf("Failed to watch %s: %v", dir, err)
+		}
+	}
+	for _, p := range append(append(append([]string(nil), f.protosets...), f.protoFiles...), f.importPaths...) {
+		info, err := os.Stat(p)
+		if err != nil || !info.IsDir() {
+			add(filepath.Dir(p))
+			continue
+		}
+		_ = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				add(path)
+			}
+			return nil
+		})
+	}
+}
+
+func (f *fileSource) scheduleReload() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.watcher == nil {
+		return
+	}
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	f.timer = time.AfterFunc(reloadDelay, f.reload)
+}
+
+func (f *fileSource) reload() {
+	f.reloading.Lock()
+	defer f.reloading.Unlock()
+	source, err := f.load()
+	if err != nil {
+		logger.Errorf("Failed to reload descriptors, keeping the previous ones: %v", err)
+		return
+	}
+	f.current.Store(loadedSource{source})
+	logger.Infof("Reloaded descriptors from %s", strings.Join(append(append([]string(nil), f.protosets...), f.protoFiles...), ", "))
+}
+
+// Close stops watching the files.
+func (f *fileSource) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	if f.watcher == nil {
+		return nil
+	}
+	err := f.watcher.Close()
+	f.watcher = nil
+	return err
+}
+
+var (
+	configured     *fileSource
+	configuredErr  error
+	configuredOnce sync.Once
+)
+
+// configuredFiles returns the descriptors of the files configured with the
+// -protoset or -proto flags. They are loaded on first use, or by Run at
+// startup, which also watches them.
+func configuredFiles() (*fileSource, error) {
+	configuredOnce.Do(func() {
+		configured, configuredErr = newFileSource(config.Protoset, config.ProtoFiles, config.ImportPaths)
+	})
+	return configured, configuredErr
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+func TestFileSourceReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(src string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "test.proto"), []byte(src), 0644); err != nil {
+			t.Fatalf("failed to write proto: %v", err)
+		}
+	}
+	// hasField reports whether test.Msg has the named field
+	hasField := func(f *fileSource, name string) bool {
+		dsc, err := f.Source().FindSymbol("test.Msg")
+		if err != nil {
+			t.Fatalf("failed to find test.Msg: %v", err)
+		}
+		return dsc.(*desc.MessageDescriptor).FindFieldByName(name) != nil
+	}
+	waitFor := func(cond func() bool) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			if cond() {
+				return true
+			}
+		}
+		return false
+	}
+
+	write(`syntax = "proto3"; package test; message Msg { string a = 1; }`)
+	f, err := newFileSource(nil, []string{dir}, nil)
+	if err != nil {
+		t.Fatalf("failed to load protos: %v", err)
+	}
+	defer f.Close()
+	if err := f.Watch(); err != nil {
+		t.Fatalf("failed to watch protos: %v", err)
+	}
+	if !hasField(f, "a") {
+		t.Fatal("expecting field a")
+	}
+
+	write(`syntax = "proto3"; package test; message Msg { string a = 1; int32 b = 2; }`)
+	if !waitFor(func() bool { return hasField(f, "b") }) {
+		t.Fatal("expecting field b after the proto was edited")
+	}
+
+	// broken edits keep the previous descriptors
+	write(`syntax = "proto3"; package test; message Msg { string a = 1; int32 b = 2; nope c = 3; }`)
+	time.Sleep(2 * reloadDelay)
+	if !hasField(f, "b") {
+		t.Error("expecting the previous descriptors to be kept after a broken edit")
+	}
+
+	write(`syntax = "proto3"; package test; message Msg { string c = 3; }`)
+	if !waitFor(func() bool { return hasField(f, "c") }) {
+		t.Fatal("expecting field c after the proto was fixed")
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	localReg "github.com/LCY2013/http-to-grpc-gateway/internal/registry/local"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// healthProbeTimeout bounds each probe of a backend.
+	healthProbeTimeout = 2 * time.Second
+	// healthWatchInterval is how often the status sent to Watch is updated.
+	healthWatchInterval = 5 * time.Second
+)
+
+// readiness checks whether the gateway is ready to serve calls.
+type readiness struct {
+	drainer *drainer
+	conf    func() *config.Config
+	// endpoints returns the addresses of the backends of a service
+	endpoints func(service string) []string
+	// probe checks the health of the backend at addr for the given service
+	probe func(ctx context.Context, addr, service string) error
+}
+
+func newReadiness(d *drainer) *readiness {
+	return &readiness{drainer: d, conf: config.Conf, endpoints: localEndpoints, probe: probeBackend}
+}
+
+// check returns the reasons the gateway is not ready, if any: it is draining,
+// its config or descriptors failed to load, or a required service has no
+// healthy endpoint.
+func (r *readiness) check(ctx context.Context) []string {
+	if !r.drainer.ready() {
+		return []string{"draining"}
+	}
+	conf := r.conf()
+	if conf == nil {
+		return []string{"config not loaded"}
+	}
+	var problems []string
+	if _, err := configuredFiles(); err != nil {
+		problems = append(problems, fmt.Sprintf("descriptors: %v", err))
+	}
+	if _, err := configuredSchemas(); err != nil {
+		problems = append(problems, fmt.Sprintf("schema registry: %v", err))
+	}
+	for _, svc := range conf.Health.RequiredServices {
+		if err := r.checkService(ctx, svc); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+// checkService returns an error unless one of the endpoints of the service
+// is healthy.
+func (r *readiness) checkService(ctx context.Context, svc string) error {
+	endpoints := r.endpoints(svc)
+	if len(endpoints) == 0 {
+		return fmt.Errorf("%s: no endpoint", svc)
+	}
+	var errs []string
+	for _, addr := range endpoints {
+		err := r.probe(ctx, addr, svc)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", addr, err))
+	}
+	return fmt.Errorf("%s: no healthy endpoint (%s)", svc, strings.Join(errs, "; "))
+}
+
+// localEndpoints returns the addresses of the backends of a service in the
+// local registry.
+func localEndpoints(svc string) []string {
+	if addr, ok := localReg.Lookup(svc); ok {
+		return []string{addr}
+	}
+	return nil
+}
+
+// endpointState is what probing an endpoint found out.
+type endpointState struct {
+	// Connected reports whether the endpoint could be connected to.
+	Connected bool `json:"connected"`
+	// Health is the status reported by its grpc.health.v1.Health service,
+	// or UNIMPLEMENTED if it has none.
+	Health string `json:"health,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (s endpointState) healthy() bool {
+	return s.Connected && (s.Health == healthpb.HealthCheckResponse_SERVING.String() || s.Health == codes.Unimplemented.String())
+}
+
+// probeEndpoint connects to the backend at addr and checks the health of the
+// service with the gRPC health service.
+func probeEndpoint(ctx context.Context, addr, service string) endpointState {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+	cc, err := dialBackend(ctx, addr)
+	if err != nil {
+		return endpointState{Error: err.Error()}
+	}
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if status.Code(err) == codes.NotFound && service != "" {
+		// the backend only reports its overall health, or knows the service
+		// by another case than the registry
+		resp, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	}
+	if status.Code(err) == codes.Unimplemented {
+		return endpointState{Connected: true, Health: codes.Unimplemented.String()}
+	}
+	if err != nil {
+		return endpointState{Connected: true, Error: status.Convert(err).Message()}
+	}
+	return endpointState{Connected: true, Health: resp.GetStatus().String()}
+}
+
+// probeBackend checks a backend with the gRPC health service. Backends that
+// do not implement it are healthy as long as they can be reached.
+func probeBackend(ctx context.Context, addr, service string) error {
+	st := probeEndpoint(ctx, addr, service)
+	switch {
+	case st.healthy():
+		return nil
+	case st.Error != "":
+		return errors.New(st.Error)
+	default:
+		return errors.New(st.Health)
+	}
+}
+
+// ServeHTTP serves /readyz: "ok" if the gateway is ready, or else 503 with
+// the reasons it is not, one per line.
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if problems := r.check(req.Context()); len(problems) > 0 {
+		http.Error(w, strings.Join(problems, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// healthzHandler serves /healthz, which reports that the gateway is alive.
+func healthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
+
+// healthServer implements grpc.health.v1.Health for the gateway itself. The
+// status of the empty service is the readiness of the gateway, and that of
+// other services the health of their endpoints.
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	readiness *readiness
+}
+
+func (s *healthServer) status(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, bool) {
+	if service == "" {
+		if len(s.readiness.check(ctx)) > 0 {
+			return healthpb.HealthCheckResponse_NOT_SERVING, true
+		}
+		return healthpb.HealthCheckResponse_SERVING, true
+	}
+	if len(s.readiness.endpoints(service)) == 0 {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, false
+	}
+	if !s.readiness.drainer.ready() || s.readiness.checkService(ctx, service) != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING, true
+	}
+	return healthpb.HealthCheckResponse_SERVING, true
+}
+
+func (s *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, ok := s.status(ctx, req.GetService())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends the status of the service whenever it changes, until the
+// gateway starts draining.
+func (s *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx := stream.Context()
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+	for {
+		st, _ := s.status(ctx, req.GetService())
+		if st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		if !s.readiness.drainer.ready() {
+			return status.Error(codes.Unavailable, errShuttingDown.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// grpcHealthHandler serves the gRPC health service of the gateway to native
+// gRPC clients and passes other requests, including gRPC calls of other
+// services that are proxied to backends, on to next.
+func grpcHealthHandler(r *readiness, next http.Handler) http.Handler {
+	gs := grpc.NewServer()
+	healthpb.RegisterHealthServer(gs, &healthServer{readiness: r})
+	prefix := "/" + healthpb.Health_ServiceDesc.ServiceName + "/"
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isGRPC(req) || !strings.HasPrefix(req.URL.Path, prefix) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		gs.ServeHTTP(w, req)
+	})
+}
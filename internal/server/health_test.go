@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	gatewaytesting "github.com/LCY2013/http-to-grpc-gateway/internal/testing"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// withHealth registers the test service and the health service, which
+// reports the given status for the test service.
+func withHealth(st healthpb.HealthCheckResponse_ServingStatus) func(*grpc.Server) {
+	return func(s *grpc.Server) {
+		gatewaytesting.RegisterTestServiceServer(s, gatewaytesting.TestServer{})
+		hs := health.NewServer()
+		hs.SetServingStatus("testing.TestService", st)
+		healthpb.RegisterHealthServer(s, hs)
+	}
+}
+
+// endpointsIn returns the endpoints of services in registry.
+func endpointsIn(registry map[string]string) func(string) []string {
+	return func(svc string) []string {
+		if addr, ok := registry[svc]; ok {
+			return []string{addr}
+		}
+		return nil
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	conf := &config.Config{}
+	conf.Health.RequiredServices = []string{"testing.TestService"}
+	registry := map[string]string{}
+	r := &readiness{
+		drainer:   newDrainer(),
+		conf:      func() *config.Config { return conf },
+		endpoints: endpointsIn(registry),
+		probe:     probeBackend,
+	}
+	ready := func() (int, string) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code, w.Body.String()
+	}
+
+	// no endpoint
+	if code, body := ready(); code != http.StatusServiceUnavailable || !strings.Contains(body, "testing.TestService: no endpoint") {
+		t.Errorf("expecting not ready without an endpoint, got %d %q", code, body)
+	}
+	// backends without the health service are healthy if reachable
+	registry["testing.TestService"] = newTestBackend(t, nil)
+	if code, body := ready(); code != http.StatusOK {
+		t.Errorf("expecting ready, got %d %q", code, body)
+	}
+	registry["testing.TestService"] = newTestBackend(t, withHealth(healthpb.HealthCheckResponse_NOT_SERVING))
+	if code, body := ready(); code != http.StatusServiceUnavailable || !strings.Contains(body, "NOT_SERVING") {
+		t.Errorf("expecting not ready with an unhealthy endpoint, got %d %q", code, body)
+	}
+	registry["testing.TestService"] = newTestBackend(t, withHealth(healthpb.HealthCheckResponse_SERVING))
+	if code, body := ready(); code != http.StatusOK {
+		t.Errorf("expecting ready, got %d %q", code, body)
+	}
+
+	r.drainer.startDraining()
+	if code, body := ready(); code != http.StatusServiceUnavailable || body != "draining\n" {
+		t.Errorf("expecting not ready when draining, got %d %q", code, body)
+	}
+}
+
+func TestGRPCHealth(t *testing.T) {
+	registry := map[string]string{"testing.TestService": newTestBackend(t, withHealth(healthpb.HealthCheckResponse_SERVING))}
+	r := &readiness{
+		drainer:   newDrainer(),
+		conf:      func() *config.Config { return &config.Config{} },
+		endpoints: endpointsIn(registry),
+		probe:     probeBackend,
+	}
+	svr := httptest.NewServer(h2c.NewHandler(grpcHealthHandler(r, registerWithServe([]string{"http"})), &http2.Server{}))
+	defer svr.Close()
+
+	cc, err := grpc.Dial(svr.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial gateway: %v", err)
+	}
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+	ctx := context.Background()
+
+	for _, service := range []string{"", "testing.TestService"} {
+		rsp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("check of %q failed: %v", service, err)
+		}
+		if rsp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("expecting %q to be serving, got %s", service, rsp.GetStatus())
+		}
+	}
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown.Service"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expecting NotFound for an unknown service, got %v", err)
+	}
+
+	str, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("failed to watch: %v", err)
+	}
+	if rsp, err := str.Recv(); err != nil || rsp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expecting serving, got %v %v", rsp, err)
+	}
+
+	// other services are still proxied to backends
+	md := metadata.AppendToOutgoingContext(ctx, "addr", registry["testing.TestService"])
+	if _, err := gatewaytesting.NewTestServiceClient(cc).EmptyCall(md, &gatewaytesting.Empty{}); err != nil {
+		t.Errorf("expecting calls to the test service to be proxied, got %v", err)
+	}
+
+	r.drainer.startDraining()
+	rsp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if rsp.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("expecting not serving when draining, got %s", rsp.GetStatus())
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/jhump/protoreflect/desc"
+)
+
+// introspectionHandler serves JSON equivalents of the list and describe verbs
+// and of the -msg-template flag:
+//
+//	GET /_gateway/services                   the services of the backend
+//	GET /_gateway/services/{svc}/methods     the methods of a service
+//	GET /_gateway/describe/{symbol}          the proto source of a symbol
+//	GET /_gateway/template/{message}         a JSON template of a message
+//
+// The backend is resolved as for the OpenAPI document: with the http registry
+// it is given by the Addr header or the addr query parameter.
+func introspectionHandler(registries []string) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		var run func([]backendSource) (interface{}, error)
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/_gateway/"), "/"), "/")
+		switch {
+		case len(parts) == 1 && parts[0] == "services":
+			run = listServices
+		case len(parts) == 3 && parts[0] == "services" && parts[2] == "methods":
+			run = func(sources []backendSource) (interface{}, error) {
+				return listMethods(sources, strings.TrimPrefix(parts[1], "."))
+			}
+		case len(parts) == 2 && parts[0] == "describe":
+			run = func(sources []backendSource) (interface{}, error) {
+				return describeSymbol(sources, strings.TrimPrefix(parts[1], "."))
+			}
+		case len(parts) == 2 && parts[0] == "template":
+			run = func(sources []backendSource) (interface{}, error) {
+				return messageTemplate(req, sources, strings.TrimPrefix(parts[1], "."))
+			}
+		default:
+			writeIntrospectionError(writer, http.StatusNotFound, fmt.Errorf("unknown endpoint: %s", req.URL.Path))
+			return
+		}
+
+		sources, closeSources, err := backendSources(req.Context(), req, registries)
+		if err != nil {
+			writeIntrospectionError(writer, http.StatusBadRequest, err)
+			return
+		}
+		defer closeSources()
+
+		body, err := run(sources)
+		if err != nil {
+			code := http.StatusBadGateway
+			if e, ok := err.(introspectionError); ok {
+				code, err = e.code, e.err
+			}
+			writeIntrospectionError(writer, code, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(body)
+	}
+}
+
+// introspectionError is an error with the HTTP status to report it with.
+type introspectionError struct {
+	code int
+	err  error
+}
+
+func (e introspectionError) Error() string {
+	return e.err.Error()
+}
+
+func writeIntrospectionError(writer http.ResponseWriter, code int, err error) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	_ = json.NewEncoder(writer).Encode(map[string]string{"error": err.Error()})
+}
+
+func listServices(sources []backendSource) (interface{}, error) {
+	services := []string{}
+	for _, s := range sources {
+		names, err := grpcgateway.ListServices(s.source)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if routable(name, s.services) {
+				services = append(services, name)
+			}
+		}
+	}
+	sort.Strings(services)
+	return map[string]interface{}{"services": services}, nil
+}
+
+func listMethods(sources []backendSource, svc string) (interface{}, error) {
+	for _, s := range sources {
+		if !routable(svc, s.services) {
+			continue
+		}
+		methods, err := grpcgateway.ListMethods(s.source, svc)
+		if grpcgateway.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"service": svc, "methods": methods}, nil
+	}
+	return nil, introspectionError{http.StatusNotFound, fmt.Errorf("service not found: %s", svc)}
+}
+
+// findSymbol returns the descriptor of a symbol and the source it was found
+// in, looking through each backend in turn.
+func findSymbol(sources []backendSource, symbol string) (desc.Descriptor, grpcgateway.DescriptorSource, error) {
+	for _, s := range sources {
+		dsc, err := s.source.FindSymbol(symbol)
+		if grpcgateway.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		return dsc, s.source, nil
+	}
+	return nil, nil, introspectionError{http.StatusNotFound, fmt.Errorf("symbol not found: %s", symbol)}
+}
+
+func describeSymbol(sources []backendSource, symbol string) (interface{}, error) {
+	dsc, source, err := findSymbol(sources, symbol)
+	if err != nil {
+		return nil, err
+	}
+	txt, err := grpcgateway.GetDescriptorText(dsc, source)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"symbol":     dsc.GetFullyQualifiedName(),
+		"type":       symbolType(dsc),
+		"descriptor": txt,
+	}, nil
+}
+
+// symbolType names the kind of element a descriptor describes.
+func symbolType(dsc desc.Descriptor) string {
+	switch d := dsc.(type) {
+	case *desc.MessageDescriptor:
+		return "message"
+	case *desc.FieldDescriptor:
+		if d.IsExtension() {
+			return "extension"
+		}
+		return "field"
+	case *desc.OneOfDescriptor:
+		return "oneof"
+	case *desc.EnumDescriptor:
+		return "enum"
+	case *desc.EnumValueDescriptor:
+		return "enum value"
+	case *desc.ServiceDescriptor:
+		return "service"
+	case *desc.MethodDescriptor:
+		return "method"
+	}
+	return "unknown"
+}
+
+// messageTemplate renders a template of a message with the JSON options of
+// the request, so that it can be sent back as-is.
+func messageTemplate(req *http.Request, sources []backendSource, message string) (interface{}, error) {
+	dsc, source, err := findSymbol(sources, message)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := dsc.(*desc.MessageDescriptor)
+	if !ok {
+		return nil, introspectionError{http.StatusBadRequest, fmt.Errorf("%s is not a message", message)}
+	}
+	jsonOpts, err := jsonOptionsFor(req, "")
+	if err != nil {
+		return nil, introspectionError{http.StatusBadRequest, err}
+	}
+	opts := jsonOpts.formatOptions(false)
+	opts.EmitJSONDefaultFields = true
+	_, formatter, err := grpcgateway.RequestParserAndFormatter(grpcgateway.FormatJSON, source, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := formatter(grpcgateway.MakeTemplate(md))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"message":  md.GetFullyQualifiedName(),
+		"template": json.RawMessage(tmpl),
+	}, nil
+}
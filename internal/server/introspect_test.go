@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIntrospection(t *testing.T) {
+	addr := newTestBackend(t, nil)
+	svr := httptest.NewServer(introspectionHandler([]string{"http"}))
+	defer svr.Close()
+
+	get := func(path string, expectedCode int) map[string]interface{} {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, svr.URL+path, nil)
+		req.Header.Set("Addr", addr)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != expectedCode {
+			t.Errorf("%s: expecting status %d, got %d", path, expectedCode, resp.StatusCode)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("%s: failed to decode body: %v", path, err)
+		}
+		return body
+	}
+
+	body := get("/_gateway/services", http.StatusOK)
+	if !strings.Contains(toJSON(body["services"]), `"testing.TestService"`) {
+		t.Errorf("expecting testing.TestService to be listed, got %v", body)
+	}
+	body = get("/_gateway/services/testing.TestService/methods", http.StatusOK)
+	if !strings.Contains(toJSON(body["methods"]), `"testing.TestService.UnaryCall"`) {
+		t.Errorf("expecting UnaryCall to be listed, got %v", body)
+	}
+	body = get("/_gateway/describe/testing.SimpleRequest", http.StatusOK)
+	if body["type"] != "message" || !strings.Contains(body["descriptor"].(string), "message SimpleRequest") {
+		t.Errorf("unexpected description: %v", body)
+	}
+	body = get("/_gateway/template/testing.SimpleRequest?use_proto_names", http.StatusOK)
+	if !strings.Contains(toJSON(body["template"]), `"response_type"`) {
+		t.Errorf("expecting a template with proto names, got %v", body)
+	}
+
+	get("/_gateway/describe/testing.NoSuchMessage", http.StatusNotFound)
+	get("/_gateway/services/testing.NoSuchService/methods", http.StatusNotFound)
+	get("/_gateway/template/testing.TestService", http.StatusBadRequest)
+	get("/_gateway/nothing", http.StatusNotFound)
+}
+
+func toJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
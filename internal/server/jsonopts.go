@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+)
+
+// jsonOptions are the effective JSON options for one request.
+type jsonOptions struct {
+	useProtoNames  bool
+	enumsAsInts    bool
+	int64AsNumbers bool
+	emitDefaults   bool
+	pretty         bool
+	raw            bool
+	discardUnknown bool
+}
+
+// params maps the query parameters that may override each option.
+func (o *jsonOptions) params() map[string]*bool {
+	return map[string]*bool{
+		"use_proto_names":  &o.useProtoNames,
+		"enums_as_ints":    &o.enumsAsInts,
+		"int64_as_numbers": &o.int64AsNumbers,
+		"emit_defaults":    &o.emitDefaults,
+		"pretty":           &o.pretty,
+		"raw":              &o.raw,
+		"discard_unknown":  &o.discardUnknown,
+	}
+}
+
+func (o *jsonOptions) apply(c config.JSONOptions) {
+	set := func(dst, src *bool) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	set(&o.useProtoNames, c.UseProtoNames)
+	set(&o.enumsAsInts, c.EnumsAsInts)
+	set(&o.int64AsNumbers, c.Int64AsNumbers)
+	set(&o.emitDefaults, c.EmitDefaults)
+	set(&o.pretty, c.Pretty)
+	set(&o.raw, c.Raw)
+	set(&o.discardUnknown, c.DiscardUnknown)
+}
+
+// formatOptions converts the options for use with RequestParserAndFormatter.
+func (o *jsonOptions) formatOptions(includeSeparators bool) grpcgateway.FormatOptions {
+	return grpcgateway.FormatOptions{
+		EmitJSONDefaultFields: o.emitDefaults,
+		AllowUnknownFields:    o.discardUnknown,
+		UseProtoNames:         o.useProtoNames,
+		EnumsAsInts:           o.enumsAsInts,
+		Int64AsNumbers:        o.int64AsNumbers,
+		CompactJSON:           !o.pretty,
+		IncludeTextSeparator:  includeSeparators,
+		MaxMessageSize:        maxMsgSz(),
+	}
+}
+
+// jsonOptionsFor resolves the JSON options for a request to the given method.
+// Flags provide the defaults, which are overridden in turn by the json section
+// of the config, by matching routes and finally by query parameters such as
+// "?pretty&use_proto_names". A query parameter without a value means true.
+func jsonOptionsFor(req *http.Request, method string) (jsonOptions, error) {
+	opts := jsonOptions{
+		emitDefaults:   *config.EmitDefaults,
+		discardUnknown: *config.AllowUnknownFields,
+	}
+	if conf := config.Conf(); conf != nil {
+		opts.apply(conf.JSON)
+		for _, r := range conf.MatchRoutes(method) {
+			opts.apply(r.JSON)
+		}
+	}
+
+	query := req.URL.Query()
+	for name, field := range opts.params() {
+		vs, ok := query[name]
+		if !ok {
+			continue
+		}
+		v := true
+		if vs[0] != "" {
+			var err error
+			if v, err = strconv.ParseBool(vs[0]); err != nil {
+				return opts, fmt.Errorf("invalid value for query parameter %q: %q", name, vs[0])
+			}
+		}
+		*field = v
+	}
+	return opts, nil
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+)
+
+func TestMatchRoutes(t *testing.T) {
+	yes, no := true, false
+	conf := &config.Config{Routes: []config.Route{
+		{Method: "pkg.Svc/Method", JSON: config.JSONOptions{Pretty: &no}},
+		{Method: "pkg.Svc", JSON: config.JSONOptions{Pretty: &yes, Raw: &yes}},
+		{Method: "pkg.Other"},
+	}}
+
+	routes := conf.MatchRoutes("pkg.Svc/Method")
+	if len(routes) != 2 || routes[0].Method != "pkg.Svc" || routes[1].Method != "pkg.Svc/Method" {
+		t.Fatalf("unexpected routes for method: %+v", routes)
+	}
+	var opts jsonOptions
+	for _, r := range routes {
+		opts.apply(r.JSON)
+	}
+	if opts.pretty || !opts.raw {
+		t.Errorf("method route should override service route: %+v", opts)
+	}
+
+	if routes := conf.MatchRoutes("pkg.Svc.Other"); len(routes) != 1 || routes[0].Method != "pkg.Svc" {
+		t.Errorf("unexpected routes for other method: %+v", routes)
+	}
+	if routes := conf.MatchRoutes("pkg.Svc2/Method"); len(routes) != 0 {
+		t.Errorf("unexpected routes for other service: %+v", routes)
+	}
+}
+
+func TestJSONOptionsFromQuery(t *testing.T) {
+	req := httptest.NewRequest("POST", "/pkg.Svc/Method?pretty&use_proto_names=true&raw=0", nil)
+	opts, err := jsonOptionsFor(req, "pkg.Svc/Method")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !opts.pretty || !opts.useProtoNames || opts.raw {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+
+	req = httptest.NewRequest("POST", "/pkg.Svc/Method?enums_as_ints=maybe", nil)
+	if _, err := jsonOptionsFor(req, "pkg.Svc/Method"); err == nil {
+		t.Error("expecting an error for an invalid boolean")
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+)
+
+// mediaTypes maps the media types understood by the gateway to the format
+// used to parse or print message data. Binary bodies are a single bare
+// message, except on the streaming sides of methods, where each message is
+// prefixed with its size as a varint.
+var mediaTypes = map[string]grpcgateway.Format{
+	"application/json":       grpcgateway.FormatJSON,
+	"text/plain":             grpcgateway.FormatText,
+	"application/x-protobuf": grpcgateway.FormatBinary,
+	"application/protobuf":   grpcgateway.FormatBinary,
+}
+
+// contentTypes is the Content-Type written for each format.
+var contentTypes = map[grpcgateway.Format]string{
+	grpcgateway.FormatJSON:   "application/json; charset=utf-8",
+	grpcgateway.FormatText:   "text/plain; charset=utf-8",
+	grpcgateway.FormatBinary: "application/x-protobuf",
+}
+
+// requestFormat picks the format of the request body from its Content-Type
+// header. A missing or unrecognized media type yields def, so that clients
+// which do not bother setting the header (like `curl -d`) keep working.
+func requestFormat(req *http.Request, def grpcgateway.Format) grpcgateway.Format {
+	mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return def
+	}
+	if f, ok := mediaTypes[mt]; ok {
+		return f
+	}
+	return def
+}
+
+// responseFormat picks the format of the response body from the Accept header,
+// honoring quality values. Wildcards, a missing header, or a header that lists
+// nothing the gateway can produce all yield def.
+func responseFormat(req *http.Request, def grpcgateway.Format) grpcgateway.Format {
+	accept := req.Header.Values("Accept")
+	if len(accept) == 0 {
+		return def
+	}
+
+	type acceptRange struct {
+		format grpcgateway.Format
+		q      float64
+	}
+	var ranges []acceptRange
+	for _, v := range accept {
+		for _, part := range strings.Split(v, ",") {
+			mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if qs, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(qs, 64); err != nil {
+					continue
+				}
+			}
+			if q <= 0 {
+				continue
+			}
+			f, ok := mediaTypes[mt]
+			if !ok {
+				if mt != "*/*" && !strings.HasSuffix(mt, "/*") {
+					continue
+				}
+				f = def
+			}
+			ranges = append(ranges, acceptRange{format: f, q: q})
+		}
+	}
+	if len(ranges) == 0 {
+		return def
+	}
+	// stable, so that ties are broken by the order given by the client
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	return ranges[0].format
+}
+
+// contentType returns the Content-Type header value for the given format.
+func contentType(f grpcgateway.Format) string {
+	if ct, ok := contentTypes[f]; ok {
+		return ct
+	}
+	return "application/octet-stream"
+}
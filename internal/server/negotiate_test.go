@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	gatewaytesting "github.com/LCY2013/http-to-grpc-gateway/internal/testing"
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
+)
+
+func TestRequestFormat(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		expected    grpcgateway.Format
+	}{
+		{"", grpcgateway.FormatJSON},
+		{"application/json", grpcgateway.FormatJSON},
+		{"application/json; charset=utf-8", grpcgateway.FormatJSON},
+		{"text/plain", grpcgateway.FormatText},
+		{"application/x-protobuf", grpcgateway.FormatBinary},
+		{"application/protobuf", grpcgateway.FormatBinary},
+		{"application/x-www-form-urlencoded", grpcgateway.FormatJSON},
+		{"not a media type;;", grpcgateway.FormatJSON},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("POST", "/", nil)
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		if f := requestFormat(req, grpcgateway.FormatJSON); f != tc.expected {
+			t.Errorf("Content-Type %q: expecting %q, got %q", tc.contentType, tc.expected, f)
+		}
+	}
+}
+
+func TestResponseFormat(t *testing.T) {
+	testCases := []struct {
+		accept   string
+		expected grpcgateway.Format
+	}{
+		{"", grpcgateway.FormatText},
+		{"*/*", grpcgateway.FormatText},
+		{"application/json", grpcgateway.FormatJSON},
+		{"application/x-protobuf, application/json;q=0.5", grpcgateway.FormatBinary},
+		{"application/x-protobuf;q=0.2, application/json;q=0.5", grpcgateway.FormatJSON},
+		{"application/xml, text/plain", grpcgateway.FormatText},
+		{"application/json;q=0, application/x-protobuf", grpcgateway.FormatBinary},
+		{"image/png", grpcgateway.FormatText},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("POST", "/", nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		if f := responseFormat(req, grpcgateway.FormatText); f != tc.expected {
+			t.Errorf("Accept %q: expecting %q, got %q", tc.accept, tc.expected, f)
+		}
+	}
+}
+
+func TestBinaryBodies(t *testing.T) {
+	addr := newTestBackend(t, nil)
+	svr := httptest.NewServer(registerWithServe([]string{"http"}))
+	defer svr.Close()
+	post := func(method string, body []byte) []byte {
+		t.Helper()
+		req, _ := http.NewRequest("POST", svr.URL+"/testing.TestService/"+method, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Accept", "application/x-protobuf")
+		req.Header.Set("Addr", addr)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expecting 200, got %d %q", resp.StatusCode, b)
+		}
+		return b
+	}
+
+	// unary requests and responses are bare messages
+	b, _ := proto.Marshal(&gatewaytesting.SimpleRequest{Payload: &gatewaytesting.Payload{Body: []byte("hello")}})
+	var rsp gatewaytesting.SimpleResponse
+	if err := proto.Unmarshal(post("UnaryCall", b), &rsp); err != nil || string(rsp.GetPayload().GetBody()) != "hello" {
+		t.Errorf("expecting the payload echoed, got %v %v", &rsp, err)
+	}
+
+	// streamed responses are length-delimited
+	b, _ = proto.Marshal(&gatewaytesting.StreamingOutputCallRequest{
+		ResponseParameters: []*gatewaytesting.ResponseParameters{{Size: 1}, {Size: 2}},
+	})
+	r := bufio.NewReader(bytes.NewReader(post("StreamingOutputCall", b)))
+	for _, size := range []int{1, 2} {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatalf("failed to read size: %v", err)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatalf("failed to read message: %v", err)
+		}
+		var rsp gatewaytesting.StreamingOutputCallResponse
+		if err := proto.Unmarshal(msg, &rsp); err != nil || len(rsp.GetPayload().GetBody()) != size {
+			t.Errorf("expecting a payload of %d bytes, got %v %v", size, &rsp, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/openapi"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/registry"
+	localReg "github.com/LCY2013/http-to-grpc-gateway/internal/registry/local"
+	"google.golang.org/grpc"
+)
+
+// backendSource is the descriptor source of a backend along with the
+// services of it that are routable through the gateway, or nil if all are.
+type backendSource struct {
+	// addr is the address of the backend, or empty for the configured files
+	addr     string
+	source   grpcgateway.DescriptorSource
+	services []string
+}
+
+// openAPIHandler serves an OpenAPI document for the methods reachable through
+// the gateway. Documents are cached by the fingerprint of the descriptors they
+// were generated from, so that they are regenerated only when the descriptors
+// change.
+func openAPIHandler(registries []string) http.HandlerFunc {
+	var (
+		mu    sync.Mutex
+		cache = map[string][]byte{}
+	)
+	return func(writer http.ResponseWriter, req *http.Request) {
+		jsonOpts, err := jsonOptionsFor(req, "")
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts := openapi.Options{
+			Version:        config.Version,
+			AddrHeader:     hasRegistry(registries, "http"),
+			UseProtoNames:  jsonOpts.useProtoNames,
+			EnumsAsInts:    jsonOpts.enumsAsInts,
+			Int64AsNumbers: jsonOpts.int64AsNumbers,
+			Envelope:       "ack",
+		}
+		if config.Version == config.NoVersion {
+			opts.Version = ""
+		}
+		if jsonOpts.raw {
+			opts.Envelope = "none"
+		} else if conf := config.Conf(); conf != nil && conf.Envelope.Name != "" {
+			opts.Envelope = conf.Envelope.Name
+		}
+
+		sources, closeSources, err := backendSources(req.Context(), req, registries)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer closeSources()
+
+		key := fmt.Sprintf("%+v", opts)
+		for _, s := range sources {
+			fp, err := openapi.Fingerprint(s.source)
+			if err != nil {
+				logger.Errorf("Failed to load descriptors for OpenAPI document: %v", err)
+				http.Error(writer, err.Error(), http.StatusBadGateway)
+				return
+			}
+			key += "," + fp + ":" + strings.Join(s.services, "|")
+		}
+
+		mu.Lock()
+		body, ok := cache[key]
+		mu.Unlock()
+		if !ok {
+			doc := openapi.New(opts)
+			for _, s := range sources {
+				o := opts
+				o.Services = s.services
+				if err := doc.Add(s.source, o); err != nil {
+					logger.Errorf("Failed to generate OpenAPI document: %v", err)
+					http.Error(writer, err.Error(), http.StatusBadGateway)
+					return
+				}
+			}
+			if body, err = json.MarshalIndent(doc, "", "  "); err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			mu.Lock()
+			if len(cache) >= 32 {
+				// stale documents are never requested again, so start over
+				// rather than tracking their use
+				cache = map[string][]byte{}
+			}
+			cache[key] = body
+			mu.Unlock()
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write(body)
+	}
+}
+
+// hasSource reports whether one of the sources is that of the backend at addr.
+func hasSource(sources []backendSource, addr string) bool {
+	for _, s := range sources {
+		if s.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// backendSources returns the descriptor sources of the backends reachable
+// through the gateway, for describing them to clients. With the local
+// registry, every registered backend is documented, limited to the services
+// registered for it. With the http registry, the backend is given by the Addr
+// header or the addr query parameter; without one, and without backends from
+// the local registry, only the configured protoset or proto files are
+// documented.
+func backendSources(ctx context.Context, req *http.Request, registries []string) ([]backendSource, func(), error) {
+	var sources []backendSource
+	var closers []func()
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+
+	// add adds the source for the backend at addr, or for the configured
+	// files only if addr is empty
+	add := func(addr string, services []string) error {
+		var cc *grpc.ClientConn
+		if addr != "" && needsReflection(addr, services) {
+			var err error
+			if cc, err = dial(ctx, &registry.Registry{Addr: addr}); err != nil {
+				return err
+			}
+			closers = append(closers, func() { _ = cc.Close() })
+		}
+		source, reset, err := descriptorSource(ctx, addr, services, cc)
+		if err != nil {
+			return err
+		}
+		closers = append(closers, reset)
+		if source == nil {
+			return fmt.Errorf("no descriptors for %q: enable reflection, configure protoset or proto files, or add it to the schema registry", addr)
+		}
+		sources = append(sources, backendSource{addr: addr, source: source, services: services})
+		return nil
+	}
+
+	var err error
+	if hasRegistry(registries, "local") {
+		byAddr := map[string][]string{}
+		for svc, addr := range localReg.Services() {
+			byAddr[addr] = append(byAddr[addr], svc)
+		}
+		addrs := make([]string, 0, len(byAddr))
+		for addr := range byAddr {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			sort.Strings(byAddr[addr])
+			if err = add(addr, byAddr[addr]); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil && hasRegistry(registries, "http") {
+		addr := req.Header.Get("Addr")
+		if addr == "" {
+			addr = req.URL.Query().Get("addr")
+		}
+		switch {
+		case addr != "":
+			if !hasSource(sources, addr) {
+				err = add(addr, nil)
+			}
+		case len(sources) > 0:
+		case len(config.Protoset) > 0 || len(config.ProtoFiles) > 0:
+			err = add("", nil)
+		default:
+			err = fmt.Errorf("no backend to describe: set the Addr header or the addr query parameter")
+		}
+	}
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	return sources, closeAll, nil
+}
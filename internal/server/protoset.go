@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 the descriptor API is built on the v1 API
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// uploadedSources holds the descriptor sources uploaded through the admin
+// API, by backend address. They take the place of reflection and of the
+// configured files for that backend.
+var uploadedSources sync.Map
+
+// uploadedSource returns the source uploaded for the backend at addr, or nil
+// if there is none.
+func uploadedSource(addr string) grpcgateway.DescriptorSource {
+	if addr == "" {
+		return nil
+	}
+	if source, ok := uploadedSources.Load(addr); ok {
+		return source.(grpcgateway.DescriptorSource)
+	}
+	return nil
+}
+
+// protosetHandler serves /_gateway/admin/protoset, where the backend is given
+// by the Addr header or the addr query parameter:
+//
+//	GET     downloads a FileDescriptorSet with the services of the backend,
+//	        or those given with the service query parameter, and everything
+//	        they depend on
+//	POST    uploads a FileDescriptorSet to use for the backend instead of
+//	        reflection or the configured files
+//	DELETE  removes an uploaded FileDescriptorSet
+func protosetHandler(registries []string) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		addr := req.Header.Get("Addr")
+		if addr == "" {
+			addr = req.URL.Query().Get("addr")
+		}
+		switch req.Method {
+		case http.MethodGet:
+			downloadProtoset(writer, req, registries, addr)
+		case http.MethodPost, http.MethodPut:
+			uploadProtoset(writer, req, addr)
+		case http.MethodDelete:
+			if addr == "" {
+				http.Error(writer, "missing backend: set the Addr header or the addr query parameter", http.StatusBadRequest)
+				return
+			}
+			if _, ok := uploadedSources.LoadAndDelete(addr); !ok {
+				http.Error(writer, fmt.Sprintf("no protoset uploaded for %s", addr), http.StatusNotFound)
+				return
+			}
+			logger.Infof("Removed protoset uploaded for %s", addr)
+			writer.WriteHeader(http.StatusNoContent)
+		default:
+			writer.Header().Set("Allow", "GET, POST, PUT, DELETE")
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func downloadProtoset(writer http.ResponseWriter, req *http.Request, registries []string, addr string) {
+	sources, closeSources, err := backendSources(req.Context(), req, registries)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer closeSources()
+
+	// with the local registry all backends are described, so narrow them
+	// down to the requested one
+	services := req.URL.Query()["service"]
+	var source *backendSource
+	for i, s := range sources {
+		if addr != "" && s.addr != addr {
+			continue
+		}
+		if len(services) > 0 && !hasServices(s, services) {
+			continue
+		}
+		if source != nil {
+			http.Error(writer, "several backends match: select one with addr or service", http.StatusBadRequest)
+			return
+		}
+		source = &sources[i]
+	}
+	if source == nil {
+		http.Error(writer, "no backend has the requested services", http.StatusNotFound)
+		return
+	}
+
+	symbols := services
+	if len(symbols) == 0 {
+		all, err := grpcgateway.ListServices(source.source)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadGateway)
+			return
+		}
+		for _, svc := range all {
+			if routable(svc, source.services) {
+				symbols = append(symbols, svc)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := grpcgateway.WriteProtoset(&buf, source.source, symbols...); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Disposition", `attachment; filename="descriptors.protoset"`)
+	_, _ = writer.Write(buf.Bytes())
+}
+
+// hasServices reports whether all services are routable to the backend and
+// known to its source.
+func hasServices(s backendSource, services []string) bool {
+	for _, svc := range services {
+		if !routable(svc, s.services) {
+			return false
+		}
+		if _, err := s.source.FindSymbol(svc); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func uploadProtoset(writer http.ResponseWriter, req *http.Request, addr string) {
+	if addr == "" {
+		http.Error(writer, "missing backend: set the Addr header or the addr query parameter", http.StatusBadRequest)
+		return
+	}
+	body := req.Body
+	if n := serverConf().Limits.MaxProtosetBytes; n > 0 {
+		body = http.MaxBytesReader(writer, body, n)
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var fds descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(b, &fds); err != nil {
+		http.Error(writer, fmt.Sprintf("invalid FileDescriptorSet: %v", err), http.StatusBadRequest)
+		return
+	}
+	source, err := grpcgateway.DescriptorSourceFromFileDescriptorSet(&fds)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("invalid FileDescriptorSet: %v", err), http.StatusBadRequest)
+		return
+	}
+	services, err := grpcgateway.ListServices(source)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	uploadedSources.Store(addr, source)
+	logger.Infof("Uploaded protoset with %d files for %s", len(fds.File), addr)
+
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(map[string]interface{}{
+		"addr":     addr,
+		"files":    len(fds.File),
+		"services": services,
+	})
+}
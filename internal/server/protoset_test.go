@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 the descriptor API is built on the v1 API
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestProtoset(t *testing.T) {
+	// nothing listens there: descriptors must come from the upload
+	const addr = "127.0.0.1:1"
+	svr := httptest.NewServer(protosetHandler([]string{"http"}))
+	defer svr.Close()
+
+	do := func(method string, body []byte, expectedCode int) []byte {
+		t.Helper()
+		req, _ := http.NewRequest(method, svr.URL+"/_gateway/admin/protoset?addr="+addr, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != expectedCode {
+			t.Fatalf("%s: expecting status %d, got %d: %s", method, expectedCode, resp.StatusCode, b)
+		}
+		return b
+	}
+
+	protoset, err := os.ReadFile("../testing/test.protoset")
+	if err != nil {
+		t.Fatalf("failed to read protoset: %v", err)
+	}
+	do(http.MethodPost, []byte("not a protoset"), http.StatusBadRequest)
+	if b := do(http.MethodPost, protoset, http.StatusOK); !strings.Contains(string(b), "testing.TestService") {
+		t.Errorf("expecting the uploaded services to be listed, got %s", b)
+	}
+	defer uploadedSources.Delete(addr)
+
+	var fds descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(do(http.MethodGet, nil, http.StatusOK), &fds); err != nil {
+		t.Fatalf("failed to decode downloaded protoset: %v", err)
+	}
+	found := false
+	for _, fd := range fds.File {
+		for _, sd := range fd.Service {
+			found = found || fd.GetPackage()+"."+sd.GetName() == "testing.TestService"
+		}
+	}
+	if !found {
+		t.Errorf("expecting testing.TestService in the downloaded protoset")
+	}
+
+	do(http.MethodDelete, nil, http.StatusNoContent)
+	do(http.MethodDelete, nil, http.StatusNotFound)
+}
+
+func TestAdminOnly(t *testing.T) {
+	svr := httptest.NewServer(adminOnly(protosetHandler([]string{"http"})))
+	defer svr.Close()
+
+	// without a config there is no admin token, so the API is disabled
+	resp, err := http.Get(svr.URL + "/_gateway/admin/protoset")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expecting 403, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/registry"
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 the status details API is built on the v1 API
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// rawCodec passes message bytes through untouched, so that the gateway can
+// forward native gRPC calls without knowing the message types.
+type rawCodec struct {
+	name string
+}
+
+func (c rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *(v.(*[]byte)), nil
+}
+
+func (c rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*(v.(*[]byte)) = append([]byte(nil), data...)
+	return nil
+}
+
+func (c rawCodec) Name() string {
+	return c.name
+}
+
+// isGRPC reports whether the request is a native gRPC call.
+func isGRPC(req *http.Request) bool {
+	ct := req.Header.Get("Content-Type")
+	return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+") || strings.HasPrefix(ct, "application/grpc;")
+}
+
+// proxyGRPC forwards a native gRPC call to the backend resolved from the
+// registry. Messages are copied byte-for-byte, so no descriptors are needed.
+func proxyGRPC(writer http.ResponseWriter, req *http.Request, register registry.Register) {
+	ctx, cancelCall := withCallTimeout(req.Context())
+	defer cancelCall()
+	access := accessFrom(ctx)
+	writeStatus := func(stat *status.Status, md metadata.MD, asTrailers bool) {
+		access.setCode(stat.Code())
+		writeGRPCStatus(writer, stat, md, asTrailers)
+	}
+	if timeout := requestTimeout(req); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	r, err := register.Register()
+	if err != nil {
+		writeStatus(status.New(codes.Unimplemented, err.Error()), nil, false)
+		return
+	}
+	access.setRPC(r)
+	cc, err := dial(ctx, r)
+	if err != nil {
+		writeStatus(status.Newf(codes.Unavailable, "failed to dial %q: %v", r.Addr, err), nil, false)
+		return
+	}
+	defer cc.Close()
+
+	subtype := "proto"
+	if _, s, ok := strings.Cut(req.Header.Get("Content-Type"), "+"); ok {
+		subtype = s
+	}
+
+	if enc := req.Header.Get("Grpc-Encoding"); enc != "" && enc != "identity" {
+		// compressed messages would have to be decompressed and then
+		// compressed again by grpc-go, which defeats the point of a proxy
+		writeStatus(status.Newf(codes.Unimplemented, "unsupported compression: %s", enc), nil, false)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, grpcgateway.MetadataFromHeaders(rpcHeaders(req)))
+
+	desc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+	str, err := cc.NewStream(ctx, desc, req.URL.Path, grpc.ForceCodec(rawCodec{name: subtype}))
+	if err != nil {
+		writeStatus(status.Convert(err), nil, false)
+		return
+	}
+
+	// upload request messages concurrently with downloading responses, since
+	// either side of a bidi stream may go first
+	go func() {
+		for {
+			b, err := readGRPCFrame(req.Body)
+			if err == io.EOF {
+				_ = str.CloseSend()
+				return
+			}
+			if err != nil {
+				logger.Ctx(ctx).Errorf("Failed to read request message for %q: %v", r.Method, err)
+				cancel()
+				return
+			}
+			if err := str.SendMsg(&b); err != nil {
+				// the actual status is reported by RecvMsg
+				return
+			}
+			access.addRequest()
+		}
+	}()
+
+	md, err := str.Header()
+	if err == nil {
+		writeMetadata(writer.Header(), "", md)
+	}
+	writer.Header().Set("Content-Type", req.Header.Get("Content-Type"))
+	flusher, _ := writer.(http.Flusher)
+
+	wroteHeader := false
+	for {
+		var b []byte
+		if err = str.RecvMsg(&b); err != nil {
+			break
+		}
+		access.addResponse()
+		if !wroteHeader {
+			writer.WriteHeader(http.StatusOK)
+			wroteHeader = true
+		}
+		var prefix [5]byte
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(b)))
+		if _, err = writer.Write(prefix[:]); err == nil {
+			_, err = writer.Write(b)
+		}
+		if err != nil {
+			// client went away
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	stat := status.Convert(err)
+	if errors.Is(context.Cause(ctx), errShuttingDown) {
+		stat = cancelledStatus(ctx)
+	}
+	// if nothing was sent, this can be a trailers-only response
+	writeStatus(stat, str.Trailer(), wroteHeader)
+}
+
+// readGRPCFrame reads one length-prefixed message from a native gRPC body.
+func readGRPCFrame(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	if prefix[0]&flagCompressed != 0 {
+		return nil, status.Error(codes.Unimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if int64(size) > int64(maxMsgSz()) {
+		return nil, status.Errorf(codes.ResourceExhausted, "message of %d bytes exceeds the limit of %d bytes", size, maxMsgSz())
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// writeGRPCStatus writes the status and trailing metadata of a native gRPC
+// response. Once the response body has started they must be sent as HTTP
+// trailers; before that they are sent as headers, which makes for a
+// trailers-only response.
+func writeGRPCStatus(writer http.ResponseWriter, stat *status.Status, md metadata.MD, asTrailers bool) {
+	p := ""
+	header := writer.Header()
+	if asTrailers {
+		p = http.TrailerPrefix
+	} else {
+		header.Set("Content-Type", "application/grpc")
+	}
+	writeMetadata(header, p, md)
+	header.Set(p+"Grpc-Status", strconv.Itoa(int(stat.Code())))
+	if stat.Message() != "" {
+		header.Set(p+"Grpc-Message", encodeGrpcMessage(stat.Message()))
+	}
+	if len(stat.Proto().GetDetails()) > 0 {
+		if b, err := proto.Marshal(stat.Proto()); err == nil {
+			header.Set(p+"Grpc-Status-Details-Bin", base64.RawStdEncoding.EncodeToString(b))
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	gatewaytesting "github.com/LCY2013/http-to-grpc-gateway/internal/testing"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestProxyGRPC(t *testing.T) {
+	addr := newTestBackend(t, nil)
+	svr := httptest.NewServer(h2c.NewHandler(registerWithServe([]string{"http"}), &http2.Server{}))
+	defer svr.Close()
+
+	cc, err := grpc.Dial(svr.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial gateway: %v", err)
+	}
+	defer cc.Close()
+	client := gatewaytesting.NewTestServiceClient(cc)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "addr", addr)
+
+	var trailers metadata.MD
+	rsp, err := client.UnaryCall(metadata.AppendToOutgoingContext(ctx, gatewaytesting.MetadataReplyTrailers, "foo: bar"),
+		&gatewaytesting.SimpleRequest{Payload: &gatewaytesting.Payload{Body: []byte("hello")}}, grpc.Trailer(&trailers))
+	if err != nil {
+		t.Fatalf("unary call failed: %v", err)
+	}
+	if string(rsp.GetPayload().GetBody()) != "hello" {
+		t.Errorf("wrong payload echoed: %q", rsp.GetPayload().GetBody())
+	}
+	if v := trailers.Get("foo"); len(v) != 1 || v[0] != "bar" {
+		t.Errorf("trailers not proxied: %v", trailers)
+	}
+
+	str, err := client.HalfDuplexCall(metadata.AppendToOutgoingContext(ctx, gatewaytesting.MetadataFailLate, "9"))
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+	for _, s := range []string{"a", "b", "c"} {
+		if err := str.Send(&gatewaytesting.StreamingOutputCallRequest{Payload: &gatewaytesting.Payload{Body: []byte(s)}}); err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+	}
+	if err := str.CloseSend(); err != nil {
+		t.Fatalf("failed to close stream: %v", err)
+	}
+	var got string
+	for {
+		rsp, err := str.Recv()
+		if err == io.EOF {
+			t.Fatal("expecting error at end of stream")
+		}
+		if err != nil {
+			if status.Code(err) != codes.FailedPrecondition {
+				t.Errorf("wrong error at end of stream: %v", err)
+			}
+			break
+		}
+		got += string(rsp.GetPayload().GetBody())
+	}
+	if got != "abc" {
+		t.Errorf("wrong payloads echoed: %q", got)
+	}
+
+	// trailers-only response from the gateway itself
+	_, err = gatewaytesting.NewTestServiceClient(cc).EmptyCall(context.Background(), &gatewaytesting.Empty{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("expecting Unimplemented without an address, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 Formatter is built on the v1 API
+)
+
+// logFormatter returns the formatter for messages written to the logs, which
+// redacts sensitive fields, or nil unless verbose, when messages are not
+// logged at all.
+func logFormatter(source grpcgateway.DescriptorSource) grpcgateway.Formatter {
+	if !*config.Verbose && !*config.VeryVerbose {
+		return nil
+	}
+	var fields []string
+	if conf := config.Conf(); conf != nil {
+		fields = conf.Redact
+	}
+	opts := grpcgateway.FormatOptions{CompactJSON: true}
+	f := grpcgateway.NewJSONFormatterWithOptions(opts, grpcgateway.AnyResolverFromDescriptorSource(source))
+	return grpcgateway.NewRedactingFormatter(f, fields...)
+}
+
+// logged wraps a request supplier so that the requests it supplies are logged
+// with f, unless f is nil.
+func logged(next grpcgateway.RequestSupplier, f grpcgateway.Formatter) grpcgateway.RequestSupplier {
+	if f == nil {
+		return next
+	}
+	n := 0
+	return func(m proto.Message) error {
+		if err := next(m); err != nil {
+			return err
+		}
+		n++
+		logMessage(f, "request", n, m)
+		return nil
+	}
+}
+
+// logMessage logs the nth request or response message with f, unless f is
+// nil.
+func logMessage(f grpcgateway.Formatter, kind string, n int, m proto.Message) {
+	if f == nil {
+		return
+	}
+	str, err := f(m)
+	if err != nil {
+		logger.Errorf("Failed to format %s message %d for the log: %v", kind, n, err)
+		return
+	}
+	logger.Debugf("Contents of %s message %d: %s", kind, n, str)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	localReg "github.com/LCY2013/http-to-grpc-gateway/internal/registry/local"
+)
+
+// registryPath is where the admin API serves the local registry.
+const registryPath = "/_gateway/admin/registry"
+
+// registryEntry describes a mapping of the local registry.
+type registryEntry struct {
+	Service  string `json:"service"`
+	Endpoint string `json:"endpoint"`
+	// State is the result of probing the endpoint, unless disabled with
+	// probe=false.
+	State *endpointState `json:"state,omitempty"`
+}
+
+// registryHandler serves the local registry under /_gateway/admin/registry:
+//
+//	GET     /registry            lists the mappings with the connection and
+//	                             health state of their endpoints
+//	GET     /registry/{service}  shows one mapping
+//	PUT     /registry/{service}  adds or updates a mapping, with a body of
+//	                             the form {"endpoint":"host:port"}
+//	DELETE  /registry/{service}  removes a mapping
+//
+// Endpoints are probed unless the probe query parameter is false.
+func registryHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		service := strings.Trim(strings.TrimPrefix(req.URL.Path, registryPath), "/")
+		probe := req.URL.Query().Get("probe") != "false"
+		if service == "" {
+			if req.Method != http.MethodGet {
+				writer.Header().Set("Allow", "GET")
+				http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			entries := registryEntries(localReg.Services())
+			if probe {
+				probeEntries(req, entries)
+			}
+			writeRegistryJSON(writer, http.StatusOK, map[string]interface{}{"services": entries})
+			return
+		}
+
+		switch req.Method {
+		case http.MethodGet:
+			addr, ok := localReg.Lookup(service)
+			if !ok {
+				http.Error(writer, fmt.Sprintf("service %s is not registered", service), http.StatusNotFound)
+				return
+			}
+			entries := []registryEntry{{Service: service, Endpoint: addr}}
+			if probe {
+				probeEntries(req, entries)
+			}
+			writeRegistryJSON(writer, http.StatusOK, entries[0])
+		case http.MethodPut:
+			var body struct {
+				Endpoint string `json:"endpoint"`
+			}
+			if err := json.NewDecoder(io.LimitReader(req.Body, 1<<20)).Decode(&body); err != nil {
+				http.Error(writer, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+				return
+			}
+			created, err := localReg.Set(service, body.Endpoint)
+			if err != nil {
+				code := http.StatusInternalServerError
+				if errors.Is(err, localReg.ErrInvalid) {
+					code = http.StatusBadRequest
+				}
+				http.Error(writer, err.Error(), code)
+				return
+			}
+			logger.Infof("Registered %s at %s", service, body.Endpoint)
+			code := http.StatusOK
+			if created {
+				code = http.StatusCreated
+			}
+			writeRegistryJSON(writer, code, registryEntry{Service: service, Endpoint: body.Endpoint})
+		case http.MethodDelete:
+			removed, err := localReg.Remove(service)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !removed {
+				http.Error(writer, fmt.Sprintf("service %s is not registered", service), http.StatusNotFound)
+				return
+			}
+			logger.Infof("Unregistered %s", service)
+			writer.WriteHeader(http.StatusNoContent)
+		default:
+			writer.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// registryEntries returns the mappings sorted by service.
+func registryEntries(services map[string]string) []registryEntry {
+	entries := make([]registryEntry, 0, len(services))
+	for svc, addr := range services {
+		entries = append(entries, registryEntry{Service: svc, Endpoint: addr})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].Service) < strings.ToLower(entries[j].Service)
+	})
+	return entries
+}
+
+// probeEntries sets the state of the endpoints, probing them concurrently.
+func probeEntries(req *http.Request, entries []registryEntry) {
+	var wg sync.WaitGroup
+	for i := range entries {
+		wg.Add(1)
+		go func(e *registryEntry) {
+			defer wg.Done()
+			st := probeEndpoint(req.Context(), e.Endpoint, e.Service)
+			e.State = &st
+		}(&entries[i])
+	}
+	wg.Wait()
+}
+
+func writeRegistryJSON(writer http.ResponseWriter, code int, v interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	_ = json.NewEncoder(writer).Encode(v)
+}
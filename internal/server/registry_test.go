@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	localReg "github.com/LCY2013/http-to-grpc-gateway/internal/registry/local"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestRegistryAdmin(t *testing.T) {
+	svr := httptest.NewServer(registryHandler())
+	defer svr.Close()
+	t.Cleanup(func() {
+		_, _ = localReg.Remove("testing.TestService")
+		_, _ = localReg.Remove("pkg.Down")
+	})
+
+	do := func(method, path, body string, code int) []byte {
+		t.Helper()
+		req, _ := http.NewRequest(method, svr.URL+registryPath+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != code {
+			t.Errorf("%s %s: expecting %d, got %d: %s", method, path, code, resp.StatusCode, b)
+		}
+		return b
+	}
+
+	addr := newTestBackend(t, withHealth(healthpb.HealthCheckResponse_SERVING))
+	do(http.MethodPut, "/testing.TestService", `{"endpoint":"`+addr+`"}`, http.StatusCreated)
+	do(http.MethodPut, "/testing.TestService", `{"endpoint":"`+addr+`"}`, http.StatusOK)
+	do(http.MethodPut, "/pkg.Down", `{"endpoint":"127.0.0.1:1"}`, http.StatusCreated)
+	do(http.MethodPut, "/pkg.Bad", `{"endpoint":"nowhere"}`, http.StatusBadRequest)
+	do(http.MethodPost, "", "", http.StatusMethodNotAllowed)
+
+	var list struct {
+		Services []registryEntry `json:"services"`
+	}
+	if err := json.Unmarshal(do(http.MethodGet, "", "", http.StatusOK), &list); err != nil {
+		t.Fatalf("invalid list: %v", err)
+	}
+	states := map[string]*endpointState{}
+	for _, e := range list.Services {
+		states[e.Service] = e.State
+	}
+	if st := states["testing.TestService"]; st == nil || !st.Connected || st.Health != "SERVING" {
+		t.Errorf("expecting testing.TestService to be healthy, got %+v", st)
+	}
+	if st := states["pkg.Down"]; st == nil || st.Connected || st.Error == "" {
+		t.Errorf("expecting pkg.Down to be unreachable, got %+v", st)
+	}
+
+	var entry registryEntry
+	if err := json.Unmarshal(do(http.MethodGet, "/testing.testservice?probe=false", "", http.StatusOK), &entry); err != nil {
+		t.Fatalf("invalid entry: %v", err)
+	}
+	if entry.Endpoint != addr || entry.State != nil {
+		t.Errorf("wrong entry: %+v", entry)
+	}
+
+	do(http.MethodDelete, "/pkg.Down", "", http.StatusNoContent)
+	do(http.MethodDelete, "/pkg.Down", "", http.StatusNotFound)
+	do(http.MethodGet, "/pkg.Down", "", http.StatusNotFound)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	localReg "github.com/LCY2013/http-to-grpc-gateway/internal/registry/local"
+)
+
+// certificate holds the TLS certificate of the gateway, which is loaded again
+// when the config is reloaded so that renewed certificates are picked up.
+type certificate struct {
+	cert atomic.Pointer[tls.Certificate]
+}
+
+func (c *certificate) load(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// applyReload updates the state built from the config when it is reloaded.
+// The certificate is nil unless the gateway serves TLS. Changes to the
+// listener, the registries, the gRPC health service and cache.max_entries
+// only apply on restart.
+func applyReload(cert *certificate) func(old, new *config.Config) {
+	return func(old, new *config.Config) {
+		if err := configureLogging(new); err != nil {
+			logger.Errorf("Failed to apply the log config: %v", err)
+		}
+		if !reflect.DeepEqual(old.LocalRegistry.Registry, new.LocalRegistry.Registry) {
+			if err := localReg.Reload(old.LocalRegistry.Registry, new.LocalRegistry.Registry); err != nil {
+				logger.Errorf("Failed to apply the local registry: %v", err)
+			}
+		}
+		if cert != nil && new.Server.CertFile != "" {
+			if err := cert.load(new.Server.CertFile, new.Server.KeyFile); err != nil {
+				logger.Errorf("Failed to reload the TLS certificate, keeping the previous one: %v", err)
+			}
+		}
+		if old.Server.Addr != new.Server.Addr || (old.Server.CertFile == "") != (new.Server.CertFile == "") ||
+			old.Health.GRPC != new.Health.GRPC || !reflect.DeepEqual(old.Server.Registries, new.Server.Registries) {
+			logger.Warn("Changes to the server address, to whether it serves TLS, to its registries or to health.grpc apply on restart")
+		}
+		if old.Cache.MaxEntries != new.Cache.MaxEntries {
+			logger.Warn("Changes to cache.max_entries apply on restart")
+		}
+		if !reflect.DeepEqual(old.SchemaRegistry, new.SchemaRegistry) {
+			if err := reloadSchemas(new.SchemaRegistry); err != nil {
+				logger.Errorf("Failed to reload the schema registry, keeping the previous one: %v", err)
+			}
+		}
+	}
+}
+
+// configInfoHandler serves /_gateway/admin/config, which describes the active
+// config: its version, file, checksum, when it was loaded and why the last
+// reload failed, if it did.
+func configInfoHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(config.Active())
+	})
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	localReg "github.com/LCY2013/http-to-grpc-gateway/internal/registry/local"
+)
+
+func TestApplyReload(t *testing.T) {
+	t.Cleanup(func() {
+		for _, svc := range []string{"pkg.a", "pkg.b", "pkg.C"} {
+			_, _ = localReg.Remove(svc)
+		}
+	})
+	old, updated := &config.Config{}, &config.Config{}
+	old.LocalRegistry.Registry = map[string]string{"pkg.a": "127.0.0.1:1"}
+	updated.LocalRegistry.Registry = map[string]string{"pkg.b": "127.0.0.1:2"}
+
+	// the config's changes to the registry are applied, keeping changes
+	// made through the admin API
+	if _, err := localReg.Set("pkg.C", "127.0.0.1:3"); err != nil {
+		t.Fatal(err)
+	}
+	applyReload(nil)(old, old)
+	if _, ok := localReg.Lookup("pkg.C"); !ok {
+		t.Errorf("expecting the registry to be kept")
+	}
+	applyReload(nil)(old, updated)
+	got := localReg.Services()
+	if len(got) != 2 || got["pkg.b"] != "127.0.0.1:2" || got["pkg.C"] != "127.0.0.1:3" {
+		t.Errorf("expecting the registry of the new config and the admin API, got %v", got)
+	}
+}
+
+func TestReloadSchemas(t *testing.T) {
+	old, updated := &config.Config{}, &config.Config{}
+	updated.SchemaRegistry = []config.Schema{{Services: []string{"testing.TestService"}, Protosets: []string{"../testing/test.protoset"}}}
+	setSchemas(t)
+	t.Cleanup(func() {
+		entries, _ := configuredSchemas()
+		for _, f := range schemaFiles(entries) {
+			_ = f.Close()
+		}
+	})
+
+	applyReload(nil)(old, updated)
+	if source, _, err := schemaSource("", []string{"testing.TestService"}); source == nil || err != nil {
+		t.Fatalf("expecting the reloaded schema registry, got %v %v", source, err)
+	}
+	// a schema registry that fails to load is not applied
+	broken := &config.Config{}
+	broken.SchemaRegistry = []config.Schema{{Services: []string{"testing.TestService"}, Protosets: []string{"./missing.protoset"}}}
+	applyReload(nil)(updated, broken)
+	if source, _, err := schemaSource("", []string{"testing.TestService"}); source == nil || err != nil {
+		t.Errorf("expecting the previous schema registry to be kept, got %v %v", source, err)
+	}
+	applyReload(nil)(updated, old)
+	if source, _, err := schemaSource("", []string{"testing.TestService"}); source != nil || err != nil {
+		t.Errorf("expecting no schema, got %v %v", source, err)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/registry"
+	httpReg "github.com/LCY2013/http-to-grpc-gateway/internal/registry/http"
+	localReg "github.com/LCY2013/http-to-grpc-gateway/internal/registry/local"
+)
+
+// registers creates the register of each registry for a request.
+var registers = map[string]func(*http.Request) registry.Register{
+	"local": localReg.NewRegisterLocal,
+	"http":  httpReg.NewRegisterHttp,
+}
+
+// serverRegistries returns the registries given as arguments of server mode,
+// as in "local,http" or "local http", or else those of the config.
+func serverRegistries(args []string, conf *config.Config) ([]string, error) {
+	registries := conf.Server.Registries
+	if len(args) > 0 {
+		registries = nil
+		for _, arg := range args {
+			for _, r := range strings.Split(arg, ",") {
+				if r = strings.TrimSpace(r); r != "" {
+					registries = append(registries, r)
+				}
+			}
+		}
+	}
+	if err := config.ValidateRegistries(registries); err != nil {
+		return nil, err
+	}
+	for _, r := range registries {
+		if registers[r] == nil {
+			return nil, fmt.Errorf("registry %q is not supported by server mode", r)
+		}
+	}
+	return registries, nil
+}
+
+// newRegister returns the register that asks the given registries in order
+// for the backend of the request.
+func newRegister(registries []string, req *http.Request) registry.Register {
+	if len(registries) == 1 {
+		return registers[registries[0]](req)
+	}
+	chain := make(registry.Chain, 0, len(registries))
+	for _, r := range registries {
+		chain = append(chain, registers[r](req))
+	}
+	return chain
+}
+
+// hasRegistry reports whether name is one of the registries.
+func hasRegistry(registries []string, name string) bool {
+	for _, r := range registries {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/registry"
+	localReg "github.com/LCY2013/http-to-grpc-gateway/internal/registry/local"
+)
+
+func TestServerRegistries(t *testing.T) {
+	conf := config.Defaults()
+	for _, tc := range []struct {
+		args []string
+		want string
+		err  string
+	}{
+		{args: nil, want: "local"},
+		{args: []string{"http"}, want: "http"},
+		{args: []string{"local,http"}, want: "local,http"},
+		{args: []string{"http", "local"}, want: "http,local"},
+		{args: []string{"consul"}, err: `unknown registry "consul"`},
+		{args: []string{"local,local"}, err: "more than once"},
+		{args: []string{","}, err: "no registry given"},
+	} {
+		registries, err := serverRegistries(tc.args, conf)
+		switch {
+		case tc.err != "":
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: expecting an error with %q, got %v", tc.args, tc.err, err)
+			}
+		case err != nil:
+			t.Errorf("%q: unexpected error: %v", tc.args, err)
+		case strings.Join(registries, ",") != tc.want:
+			t.Errorf("%q: expecting %s, got %q", tc.args, tc.want, registries)
+		}
+	}
+	for _, r := range config.RegistryTypes {
+		if registers[r] == nil {
+			t.Errorf("registry %q is not supported by server mode", r)
+		}
+	}
+}
+
+func TestRegisterFallback(t *testing.T) {
+	if _, err := localReg.Set("pkg.Local", "127.0.0.1:1"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = localReg.Remove("pkg.Local") })
+
+	const local, header, notFound, invalid = "127.0.0.1:1", "127.0.0.1:2", "not found", "invalid"
+	for _, tc := range []struct {
+		registries []string
+		method     string
+		addr       string
+		want       string
+	}{
+		{[]string{"local"}, "pkg.Local/Call", "", local},
+		{[]string{"local"}, "pkg.Local/Call", header, local},
+		{[]string{"local"}, "pkg.Other/Call", header, notFound},
+		{[]string{"http"}, "pkg.Local/Call", "", notFound},
+		{[]string{"http"}, "pkg.Other/Call", header, header},
+		{[]string{"local", "http"}, "pkg.Local/Call", header, local},
+		{[]string{"local", "http"}, "pkg.Other/Call", header, header},
+		{[]string{"local", "http"}, "pkg.Other/Call", "", notFound},
+		{[]string{"http", "local"}, "pkg.Local/Call", header, header},
+		{[]string{"http", "local"}, "pkg.Local/Call", "", local},
+		{[]string{"http", "local"}, "pkg.Other/Call", "", notFound},
+		// malformed methods are not looked up any further
+		{[]string{"local", "http"}, "nomethod", header, invalid},
+	} {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("Method", tc.method)
+		if tc.addr != "" {
+			req.Header.Set("Addr", tc.addr)
+		}
+		reg, err := newRegister(tc.registries, req).Register()
+		var got string
+		switch {
+		case errors.Is(err, registry.ErrNotFound):
+			got = notFound
+		case err != nil:
+			got = invalid
+		default:
+			got = reg.Addr
+		}
+		if got != tc.want {
+			t.Errorf("%v %s with Addr %q: expecting %s, got %s (%v)", tc.registries, tc.method, tc.addr, tc.want, got, err)
+		}
+	}
+}
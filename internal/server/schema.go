@@ -0,0 +1,205 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	"github.com/jhump/protoreflect/desc"
+)
+
+// schemaEntry is a schema of the schema registry along with its descriptors.
+type schemaEntry struct {
+	schema config.Schema
+	files  *fileSource
+}
+
+// matches reports whether the schema describes the backend at addr or any of
+// the given services.
+func (e *schemaEntry) matches(addr string, services []string) bool {
+	if e.schema.Backend != "" && e.schema.Backend == addr {
+		return true
+	}
+	for _, svc := range services {
+		for _, s := range e.schema.Services {
+			if strings.EqualFold(s, svc) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var (
+	schemasMu   sync.RWMutex
+	schemas     []*schemaEntry
+	schemasErr  error
+	schemasOnce sync.Once
+)
+
+// configuredSchemas returns the schema registry of the config, loading its
+// files on first use, or by Run at startup, which also watches them.
+func configuredSchemas() ([]*schemaEntry, error) {
+	schemasOnce.Do(func() {
+		if conf := config.Conf(); conf != nil {
+			entries, err := loadSchemas(conf.SchemaRegistry)
+			schemasMu.Lock()
+			schemas, schemasErr = entries, err
+			schemasMu.Unlock()
+		}
+	})
+	schemasMu.RLock()
+	defer schemasMu.RUnlock()
+	return schemas, schemasErr
+}
+
+// reloadSchemas replaces the schema registry with that of a reloaded config,
+// watching its files, and stops watching those of the previous one, which is
+// kept if the new one fails to load.
+func reloadSchemas(confs []config.Schema) error {
+	entries, err := loadSchemas(confs)
+	if err != nil {
+		return err
+	}
+	for _, f := range schemaFiles(entries) {
+		if err := f.Watch(); err != nil {
+			logger.Errorf("Failed to watch descriptor files, changes will not be reloaded: %v", err)
+		}
+	}
+	schemasOnce.Do(func() {})
+	schemasMu.Lock()
+	old := schemas
+	schemas, schemasErr = entries, nil
+	schemasMu.Unlock()
+	for _, f := range schemaFiles(old) {
+		_ = f.Close()
+	}
+	return nil
+}
+
+func loadSchemas(confs []config.Schema) ([]*schemaEntry, error) {
+	entries := make([]*schemaEntry, 0, len(confs))
+	for _, s := range confs {
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+		files, err := newFileSource(s.Protosets, s.Protos, s.ImportPaths)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &schemaEntry{schema: s, files: files})
+	}
+	return entries, nil
+}
+
+// schemaSource returns the descriptors of the schema registry for the backend
+// at addr, serving the given services, or nil if none of its schemas apply.
+// It also reports whether reflection should be used as a fallback. Schemas
+// for services are only used when the services are known, i.e. when invoking
+// a method or when listing the backends of the local registry.
+func schemaSource(addr string, services []string) (grpcgateway.DescriptorSource, bool, error) {
+	entries, err := configuredSchemas()
+	if err != nil {
+		return nil, false, err
+	}
+	var sources multiSource
+	reflection := false
+	for _, e := range entries {
+		if e.matches(addr, services) {
+			sources = append(sources, e.files.Source())
+			reflection = reflection || e.schema.Reflection
+		}
+	}
+	switch len(sources) {
+	case 0:
+		return nil, false, nil
+	case 1:
+		return sources[0], reflection, nil
+	}
+	return sources, reflection, nil
+}
+
+// multiSource combines the descriptors of several schemas.
+type multiSource []grpcgateway.DescriptorSource
+
+func (ms multiSource) ListServices() ([]string, error) {
+	seen := map[string]bool{}
+	var services []string
+	for _, s := range ms {
+		svcs, err := s.ListServices()
+		if err != nil {
+			return nil, err
+		}
+		for _, svc := range svcs {
+			if !seen[svc] {
+				seen[svc] = true
+				services = append(services, svc)
+			}
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+func (ms multiSource) FindSymbol(fullyQualifiedName string) (desc.Descriptor, error) {
+	var err error
+	for _, s := range ms {
+		var d desc.Descriptor
+		if d, err = s.FindSymbol(fullyQualifiedName); err == nil {
+			return d, nil
+		}
+	}
+	return nil, err
+}
+
+func (ms multiSource) AllExtensionsForType(typeName string) ([]*desc.FieldDescriptor, error) {
+	tags := map[int32]bool{}
+	var exts []*desc.FieldDescriptor
+	for _, s := range ms {
+		found, err := s.AllExtensionsForType(typeName)
+		if err != nil {
+			return nil, err
+		}
+		for _, ext := range found {
+			if !tags[ext.GetNumber()] {
+				tags[ext.GetNumber()] = true
+				exts = append(exts, ext)
+			}
+		}
+	}
+	return exts, nil
+}
+
+func schemaFiles(entries []*schemaEntry) []*fileSource {
+	files := make([]*fileSource, 0, len(entries))
+	for _, e := range entries {
+		files = append(files, e.files)
+	}
+	return files
+}
+
+// needsReflection reports whether describing the backend at addr, serving
+// the given services, may use server reflection, so that a connection to it
+// is needed.
+func needsReflection(addr string, services []string) bool {
+	if uploadedSource(addr) != nil {
+		return false
+	}
+	schema, fallback, err := schemaSource(addr, services)
+	if err != nil || schema == nil {
+		return reflectionEnabled()
+	}
+	return fallback
+}
+
+// reflectionEnabled reports whether backends are described with server
+// reflection when no schema applies to them.
+func reflectionEnabled() bool {
+	if r := serverConf().Backends.Reflection; r != nil {
+		return *r
+	}
+	return config.Reflection.Val
+}
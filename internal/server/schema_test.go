@@ -0,0 +1,80 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	gatewaytesting "github.com/LCY2013/http-to-grpc-gateway/internal/testing"
+	"google.golang.org/grpc"
+)
+
+// setSchemas replaces the schema registry for the duration of a test.
+func setSchemas(t *testing.T, confs ...config.Schema) {
+	t.Helper()
+	schemasOnce.Do(func() {})
+	entries, err := loadSchemas(confs)
+	if err != nil {
+		t.Fatalf("failed to load schemas: %v", err)
+	}
+	schemasMu.Lock()
+	schemas = entries
+	schemasMu.Unlock()
+	t.Cleanup(func() {
+		schemasMu.Lock()
+		schemas = nil
+		schemasMu.Unlock()
+	})
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	// the backend does not serve reflection
+	addr := newTestBackend(t, func(s *grpc.Server) {
+		gatewaytesting.RegisterTestServiceServer(s, gatewaytesting.TestServer{})
+	})
+	svr := httptest.NewServer(registerWithServe([]string{"http"}))
+	defer svr.Close()
+
+	call := func() (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", svr.URL+"/testing.TestService/UnaryCall?raw", strings.NewReader(`{"payload":{"body":"aGVsbG8="}}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Addr", addr)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	if code, _ := call(); code == http.StatusOK {
+		t.Error("expecting the call to fail without reflection or a schema")
+	}
+
+	setSchemas(t, config.Schema{Services: []string{"testing.TestService"}, Protosets: []string{"../testing/test.protoset"}})
+	if code, body := call(); code != http.StatusOK || !strings.Contains(body, `"aGVsbG8="`) {
+		t.Errorf("schema for the service: unexpected response %d %s", code, body)
+	}
+
+	setSchemas(t, config.Schema{Backend: addr, Protosets: []string{"../testing/test.protoset"}})
+	if code, body := call(); code != http.StatusOK || !strings.Contains(body, `"aGVsbG8="`) {
+		t.Errorf("schema for the backend: unexpected response %d %s", code, body)
+	}
+
+	// backends are described from the schema registry too
+	introspect := httptest.NewServer(introspectionHandler([]string{"http"}))
+	defer introspect.Close()
+	resp, err := http.Get(introspect.URL + "/_gateway/services?addr=" + addr)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if b, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || !strings.Contains(string(b), "testing.TestService") {
+		t.Errorf("expecting the services of the schema, got %d %s", resp.StatusCode, b)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// cancelGrace is how long cancelled calls are given to report their status
+// before connections are closed.
+const cancelGrace = 5 * time.Second
+
+// errShuttingDown is the cause of the cancellation of the calls still running
+// when the drain timeout expires.
+var errShuttingDown = errors.New("server is shutting down")
+
+// drainer tracks in-flight requests so that shutdown can wait for them, and
+// cancels those still running once draining times out.
+type drainer struct {
+	draining atomic.Bool
+
+	mu       sync.Mutex
+	inflight int
+	idle     chan struct{} // closed once draining and there are no requests
+	killed   chan struct{} // closed to cancel the requests
+	kill     sync.Once
+}
+
+func newDrainer() *drainer {
+	return &drainer{idle: make(chan struct{}), killed: make(chan struct{})}
+}
+
+// ready reports whether the server accepts new calls, i.e. is not draining.
+func (d *drainer) ready() bool {
+	return !d.draining.Load()
+}
+
+// track wraps a handler so that its requests are waited for when draining,
+// and cancelled with errShuttingDown as the cause if they take too long.
+func (d *drainer) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		d.mu.Lock()
+		d.inflight++
+		d.mu.Unlock()
+		defer d.done()
+
+		ctx, cancel := context.WithCancelCause(req.Context())
+		defer cancel(nil)
+		finished := make(chan struct{})
+		defer close(finished)
+		go func() {
+			select {
+			case <-d.killed:
+				cancel(errShuttingDown)
+			case <-finished:
+			}
+		}()
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+func (d *drainer) done() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inflight--
+	if d.inflight == 0 && d.draining.Load() {
+		d.closeIdle()
+	}
+}
+
+// closeIdle closes idle, with mu held.
+func (d *drainer) closeIdle() {
+	select {
+	case <-d.idle:
+	default:
+		close(d.idle)
+	}
+}
+
+// startDraining flips readiness, after which wait returns once there are no
+// requests in flight.
+func (d *drainer) startDraining() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.draining.Store(true)
+	if d.inflight == 0 {
+		d.closeIdle()
+	}
+}
+
+// wait waits for in-flight requests to finish once draining. If ctx is done
+// first, the requests still running are cancelled and waited for until grace
+// is over, and the number of them is returned.
+func (d *drainer) wait(ctx context.Context, grace time.Duration) int {
+	select {
+	case <-d.idle:
+		return 0
+	case <-ctx.Done():
+	}
+	d.mu.Lock()
+	n := d.inflight
+	d.mu.Unlock()
+	d.kill.Do(func() { close(d.killed) })
+	select {
+	case <-d.idle:
+	case <-time.After(grace):
+	}
+	return n
+}
+
+// cancelledStatus returns the status of a call whose context was cancelled,
+// which is Unavailable if the server is shutting down and DeadlineExceeded if
+// the call timed out.
+func cancelledStatus(ctx context.Context) *status.Status {
+	if errors.Is(context.Cause(ctx), errShuttingDown) {
+		return status.New(codes.Unavailable, errShuttingDown.Error())
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return status.New(codes.DeadlineExceeded, "request timeout")
+	}
+	return status.New(codes.Canceled, "request cancelled")
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"google.golang.org/grpc/codes"
+)
+
+func TestDrainer(t *testing.T) {
+	d := newDrainer()
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	codesc := make(chan codes.Code, 2)
+	svr := httptest.NewServer(d.track(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		if req.URL.Path == "/quick" {
+			<-release
+			codesc <- codes.OK
+			return
+		}
+		<-req.Context().Done()
+		codesc <- cancelledStatus(req.Context()).Code()
+	})))
+	defer svr.Close()
+
+	for _, path := range []string{"/quick", "/slow"} {
+		go func(path string) {
+			resp, err := http.Get(svr.URL + path)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}(path)
+		<-started
+	}
+
+	ready := func() int {
+		w := httptest.NewRecorder()
+		(&readiness{drainer: d, conf: func() *config.Config { return &config.Config{} }}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("expecting ready before draining, got %d", code)
+	}
+	d.startDraining()
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("expecting not ready when draining, got %d", code)
+	}
+
+	close(release)
+	if code := <-codesc; code != codes.OK {
+		t.Errorf("expecting the quick call to complete, got %s", code)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if n := d.wait(ctx, time.Second); n != 1 {
+		t.Errorf("expecting 1 call to be cancelled, got %d", n)
+	}
+	if code := <-codesc; code != codes.Unavailable {
+		t.Errorf("expecting the slow call to be cancelled with Unavailable, got %s", code)
+	}
+
+	// nothing is left to wait for
+	if n := d.wait(context.Background(), time.Second); n != 0 {
+		t.Errorf("expecting no calls in flight, got %d", n)
+	}
+}
+
+func TestCancelledStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	if code := cancelledStatus(ctx).Code(); code != codes.DeadlineExceeded {
+		t.Errorf("expecting %v for a call that timed out, got %v", codes.DeadlineExceeded, code)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if code := cancelledStatus(ctx).Code(); code != codes.Canceled {
+		t.Errorf("expecting %v for a cancelled call, got %v", codes.Canceled, code)
+	}
+}
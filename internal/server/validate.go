@@ -0,0 +1,44 @@
+package server
+
+import (
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/validate"
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 RequestSupplier is built on the v1 API
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc/status"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// validated wraps a request supplier so that each request message is checked
+// against the validation rules of its descriptor before it is sent to the
+// backend. Since InvokeRPC does not preserve the status of errors returned by
+// the supplier, the status error for invalid requests, or any status error
+// returned by next, is also stored in invalid for the caller to report.
+func validated(next grpcgateway.RequestSupplier, invalid *error) grpcgateway.RequestSupplier {
+	return func(m proto.Message) error {
+		if err := next(m); err != nil {
+			if _, ok := status.FromError(err); ok {
+				*invalid = err
+			}
+			return err
+		}
+		dm, ok := m.(*dynamic.Message)
+		if !ok {
+			return nil
+		}
+		b, err := dm.Marshal()
+		if err != nil {
+			return err
+		}
+		msg := dynamicpb.NewMessage(dm.GetMessageDescriptor().UnwrapMessage())
+		if err := protov2.Unmarshal(b, msg); err != nil {
+			return err
+		}
+		if err := validate.Error(validate.Message(msg)); err != nil {
+			*invalid = err
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+)
+
+// validatedProto describes the test service with a validation rule on the
+// response size, and the subset of buf/validate/validate.proto it uses.
+var validatedProto = map[string]string{
+	"buf/validate/validate.proto": `
+syntax = "proto3";
+package buf.validate;
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.FieldOptions {
+  FieldConstraints field = 1159;
+}
+
+message FieldConstraints {
+  oneof type {
+    Int32Rules int32 = 3;
+  }
+}
+
+message Int32Rules {
+  optional int32 lte = 3;
+}
+`,
+	"test.proto": `
+syntax = "proto3";
+package testing;
+import "buf/validate/validate.proto";
+
+service TestService {
+  rpc UnaryCall(SimpleRequest) returns (SimpleResponse);
+}
+
+message SimpleRequest {
+  int32 response_size = 2 [(buf.validate.field).int32.lte = 10];
+}
+
+message SimpleResponse {}
+`,
+}
+
+func TestValidatedRequest(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range validatedProto {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setSchemas(t, config.Schema{Services: []string{"testing.TestService"}, Protos: []string{filepath.Join(dir, "test.proto")}, ImportPaths: []string{dir}})
+
+	addr := newTestBackend(t, nil)
+	svr := httptest.NewServer(registerWithServe([]string{"http"}))
+	defer svr.Close()
+	post := func(body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", svr.URL+"/testing.TestService/UnaryCall", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Addr", addr)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	if code, body := post(`{"response_size":1}`); code != http.StatusOK || !strings.HasPrefix(body, `{"code":200,"msg":"ok"`) {
+		t.Errorf("expecting a valid request to succeed, got %d %s", code, body)
+	}
+	code, body := post(`{"response_size":11}`)
+	if code != http.StatusBadRequest || !strings.HasPrefix(body, `{"code":400,`) ||
+		!strings.Contains(body, `google.rpc.BadRequest`) || !strings.Contains(body, `"field":"response_size"`) {
+		t.Errorf("expecting the request to be rejected with its field violations, got %d %s", code, body)
+	}
+}
@@ -0,0 +1,641 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/ack"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/logger"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/registry"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/transform"
+	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 the dynamic message API is built on the v1 API
+	"github.com/golang/protobuf/proto"  //lint:ignore SA1019 the dynamic message API is built on the v1 API
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// protocol is the wire protocol spoken by an HTTP request.
+type protocol int
+
+const (
+	// protocolEnvelope is the gateway's own mode: JSON, text or binary bodies
+	// with responses wrapped in an ack.Envelope.
+	protocolEnvelope protocol = iota
+	// protocolGRPCWeb is gRPC-Web with binary framing.
+	protocolGRPCWeb
+	// protocolGRPCWebText is gRPC-Web with base64-encoded framing.
+	protocolGRPCWebText
+	// protocolConnectUnary is the Connect protocol for unary methods, where
+	// the body is a single, unframed message.
+	protocolConnectUnary
+	// protocolConnectStream is the Connect protocol for streaming methods.
+	protocolConnectStream
+)
+
+const (
+	// flagCompressed marks a compressed message in gRPC-Web and Connect framing.
+	flagCompressed = 0x01
+	// flagEndStream marks the final message of a Connect stream.
+	flagEndStream = 0x02
+	// flagTrailers marks the trailers frame of a gRPC-Web response.
+	flagTrailers = 0x80
+
+	// defaultMaxMsgSz mirrors the default maximum message size of grpc-go.
+	defaultMaxMsgSz = 4 * 1024 * 1024
+)
+
+// detectProtocol inspects the request headers to determine which protocol the
+// client speaks, and which codec ("proto" or "json") encodes its messages.
+func detectProtocol(req *http.Request) (protocol, string) {
+	mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return protocolEnvelope, ""
+	}
+	base, codec, _ := strings.Cut(mt, "+")
+	if codec == "" {
+		codec = "proto"
+	}
+	switch base {
+	case "application/grpc-web":
+		return protocolGRPCWeb, codec
+	case "application/grpc-web-text":
+		return protocolGRPCWebText, codec
+	case "application/connect":
+		return protocolConnectStream, codec
+	}
+	if req.Header.Get("Connect-Protocol-Version") != "" {
+		switch mt {
+		case "application/proto":
+			return protocolConnectUnary, "proto"
+		case "application/json":
+			return protocolConnectUnary, "json"
+		}
+	}
+	return protocolEnvelope, ""
+}
+
+// messageCodec encodes and decodes the payload of a single message.
+type messageCodec struct {
+	name      string
+	marshal   func(proto.Message) ([]byte, error)
+	unmarshal func([]byte, proto.Message) error
+}
+
+func newMessageCodec(name string, descSource grpcgateway.DescriptorSource) (messageCodec, error) {
+	switch name {
+	case "proto":
+		return messageCodec{name: name, marshal: proto.Marshal, unmarshal: proto.Unmarshal}, nil
+	case "json":
+		resolver := grpcgateway.AnyResolverFromDescriptorSource(descSource)
+		marshaler := jsonpb.Marshaler{AnyResolver: resolver}
+		unmarshaler := jsonpb.Unmarshaler{AnyResolver: resolver, AllowUnknownFields: *config.AllowUnknownFields}
+		return messageCodec{
+			name: name,
+			marshal: func(m proto.Message) ([]byte, error) {
+				var buf bytes.Buffer
+				err := marshaler.Marshal(&buf, m)
+				return buf.Bytes(), err
+			},
+			unmarshal: func(b []byte, m proto.Message) error {
+				return unmarshaler.Unmarshal(bytes.NewReader(b), m)
+			},
+		}, nil
+	default:
+		return messageCodec{}, status.Errorf(codes.Unimplemented, "unsupported codec: %s", name)
+	}
+}
+
+func maxMsgSz() int {
+	if n := serverConf().Backends.MaxMsgSize; n > 0 {
+		return n
+	}
+	return defaultMaxMsgSz
+}
+
+// envelopeParser is a RequestParser for the framing shared by gRPC-Web and
+// Connect streams: a flags byte and a 4-byte big-endian size before each
+// message.
+type envelopeParser struct {
+	r            io.Reader
+	codec        messageCodec
+	requestCount int
+}
+
+func (p *envelopeParser) Next(m proto.Message) error {
+	var prefix [5]byte
+	if _, err := io.ReadFull(p.r, prefix[:]); err != nil {
+		return err
+	}
+	if prefix[0]&flagCompressed != 0 {
+		return status.Error(codes.Unimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if int64(size) > int64(maxMsgSz()) {
+		return status.Errorf(codes.ResourceExhausted, "message of %d bytes exceeds the limit of %d bytes", size, maxMsgSz())
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(p.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	p.requestCount++
+
+	return p.codec.unmarshal(b, m)
+}
+
+func (p *envelopeParser) NumRequests() int {
+	return p.requestCount
+}
+
+// unaryParser is a RequestParser for Connect unary requests, whose body is a
+// single message without any framing.
+type unaryParser struct {
+	r            io.Reader
+	codec        messageCodec
+	requestCount int
+}
+
+func (p *unaryParser) Next(m proto.Message) error {
+	if p.r == nil {
+		return io.EOF
+	}
+	b, err := io.ReadAll(io.LimitReader(p.r, int64(maxMsgSz())+1))
+	p.r = nil
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMsgSz() {
+		return status.Errorf(codes.ResourceExhausted, "message exceeds the limit of %d bytes", maxMsgSz())
+	}
+	if len(b) == 0 {
+		// an empty request message will be sent
+		return io.EOF
+	}
+
+	p.requestCount++
+
+	return p.codec.unmarshal(b, m)
+}
+
+func (p *unaryParser) NumRequests() int {
+	return p.requestCount
+}
+
+// decodeWebText decodes a grpc-web-text body. Clients may send the body in
+// several independently padded base64 chunks, so it is decoded one 4-byte
+// quantum at a time rather than as a single base64 string. Bodies larger than
+// needed for a message of maxSize bytes are rejected before being decoded.
+func decodeWebText(in io.Reader, maxSize int) (io.Reader, error) {
+	// twice the encoded size of a frame leaves room for the whitespace and
+	// padding of chunks
+	limit := 2 * int64(base64.StdEncoding.EncodedLen(maxSize+5))
+	b, err := io.ReadAll(io.LimitReader(in, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, status.Errorf(codes.ResourceExhausted, "grpc-web-text body exceeds the limit of %d bytes", limit)
+	}
+	b = bytes.Join(bytes.Fields(b), nil)
+	if len(b)%4 != 0 {
+		return nil, status.Error(codes.InvalidArgument, "grpc-web-text body is not valid base64")
+	}
+	out := make([]byte, 0, base64.StdEncoding.DecodedLen(len(b)))
+	var quantum [3]byte
+	for i := 0; i < len(b); i += 4 {
+		n, err := base64.StdEncoding.Decode(quantum[:], b[i:i+4])
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "grpc-web-text body is not valid base64: %v", err)
+		}
+		out = append(out, quantum[:n]...)
+	}
+	return bytes.NewReader(out), nil
+}
+
+// webEventHandler writes the events of an RPC invocation back to the client
+// in the framing of the protocol the client used.
+type webEventHandler struct {
+	w           http.ResponseWriter
+	out         io.Writer
+	protocol    protocol
+	codec       messageCodec
+	contentType string
+	pipeline    *transform.Pipeline
+	log         grpcgateway.Formatter
+	access      *accessEntry
+
+	wroteHeader  bool
+	unary        []byte
+	numResponses int
+	stat         *status.Status
+	trailers     metadata.MD
+}
+
+var _ grpcgateway.InvocationEventHandler = (*webEventHandler)(nil)
+
+func newWebEventHandler(w http.ResponseWriter, req *http.Request, p protocol) *webEventHandler {
+	h := &webEventHandler{
+		w:           w,
+		out:         w,
+		protocol:    p,
+		contentType: req.Header.Get("Content-Type"),
+		access:      accessFrom(req.Context()),
+	}
+	if p == protocolGRPCWebText {
+		h.out = base64.NewEncoder(base64.StdEncoding, w)
+	}
+	return h
+}
+
+func (h *webEventHandler) OnResolveMethod(md *desc.MethodDescriptor) {
+	if *config.Verbose || *config.VeryVerbose {
+		logger.Debugf("Resolved method descriptor: %s", md.GetFullyQualifiedName())
+	}
+}
+
+func (h *webEventHandler) OnSendHeaders(metadata.MD) {
+}
+
+func (h *webEventHandler) OnReceiveHeaders(md metadata.MD) {
+	writeMetadata(h.w.Header(), "", md)
+}
+
+func (h *webEventHandler) OnReceiveResponse(resp proto.Message) {
+	h.numResponses++
+	logMessage(h.log, "response", h.numResponses, resp)
+	resp, err := h.pipeline.Response(resp)
+	if err != nil {
+		logger.Errorf("Failed to transform response message %d: %v", h.numResponses, err)
+		return
+	}
+	b, err := h.codec.marshal(resp)
+	if err != nil {
+		logger.Errorf("Failed to encode response message %d: %v", h.numResponses, err)
+		return
+	}
+	if h.protocol == protocolConnectUnary {
+		// written by finish, since trailers must be sent as headers
+		h.unary = b
+		return
+	}
+	h.writeHeader(http.StatusOK)
+	h.writeFrame(0, b)
+	if f, ok := h.w.(http.Flusher); ok && h.protocol != protocolGRPCWebText {
+		f.Flush()
+	}
+}
+
+func (h *webEventHandler) OnReceiveTrailers(stat *status.Status, md metadata.MD) {
+	h.stat = stat
+	h.trailers = md
+}
+
+func (h *webEventHandler) writeHeader(code int) {
+	if h.wroteHeader {
+		return
+	}
+	h.wroteHeader = true
+	h.w.Header().Set("Content-Type", h.contentType)
+	h.w.WriteHeader(code)
+}
+
+func (h *webEventHandler) writeFrame(flags byte, b []byte) {
+	var prefix [5]byte
+	prefix[0] = flags
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(b)))
+	if _, err := h.out.Write(prefix[:]); err != nil {
+		return
+	}
+	_, _ = h.out.Write(b)
+}
+
+// finish completes the response once the invocation is over. If err is not
+// nil, it takes precedence over any status received from the backend.
+func (h *webEventHandler) finish(err error) {
+	stat := h.stat
+	if err != nil {
+		stat = status.Convert(err)
+	} else if stat == nil {
+		stat = status.New(codes.OK, "")
+	}
+	h.access.setCode(stat.Code())
+
+	switch h.protocol {
+	case protocolGRPCWeb, protocolGRPCWebText:
+		h.writeHeader(http.StatusOK)
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "grpc-status: %d\r\n", stat.Code())
+		if stat.Message() != "" {
+			fmt.Fprintf(&buf, "grpc-message: %s\r\n", encodeGrpcMessage(stat.Message()))
+		}
+		if details := stat.Proto().GetDetails(); len(details) > 0 {
+			if b, err := proto.Marshal(stat.Proto()); err == nil {
+				fmt.Fprintf(&buf, "grpc-status-details-bin: %s\r\n", base64.RawStdEncoding.EncodeToString(b))
+			}
+		}
+		trailers := http.Header{}
+		writeMetadata(trailers, "", h.trailers)
+		for k, vs := range trailers {
+			for _, v := range vs {
+				fmt.Fprintf(&buf, "%s: %s\r\n", strings.ToLower(k), v)
+			}
+		}
+		h.writeFrame(flagTrailers, buf.Bytes())
+		if enc, ok := h.out.(io.Closer); ok {
+			_ = enc.Close()
+		}
+
+	case protocolConnectStream:
+		h.writeHeader(http.StatusOK)
+		end := connectEndStream{}
+		if stat.Code() != codes.OK {
+			end.Error = newConnectError(stat)
+		}
+		if len(h.trailers) > 0 {
+			end.Metadata = http.Header{}
+			writeMetadata(end.Metadata, "", h.trailers)
+		}
+		b, err := json.Marshal(end)
+		if err != nil {
+			logger.Errorf("Failed to encode end of stream: %v", err)
+			return
+		}
+		h.writeFrame(flagEndStream, b)
+
+	case protocolConnectUnary:
+		writeMetadata(h.w.Header(), "Trailer-", h.trailers)
+		if stat.Code() == codes.OK {
+			h.w.Header().Set("Content-Type", "application/"+h.codec.name)
+			h.w.WriteHeader(http.StatusOK)
+			_, _ = h.w.Write(h.unary)
+			return
+		}
+		h.w.Header().Set("Content-Type", "application/json")
+		h.w.WriteHeader(ack.HTTPStatus(stat.Code()))
+		_ = json.NewEncoder(h.w).Encode(newConnectError(stat))
+	}
+}
+
+// writeMetadata copies gRPC metadata into HTTP headers, adding the given
+// prefix to each key. Binary values are base64-encoded.
+func writeMetadata(header http.Header, prefix string, md metadata.MD) {
+	for k, vs := range md {
+		if strings.HasPrefix(k, ":") || k == "content-type" {
+			continue
+		}
+		for _, v := range vs {
+			if strings.HasSuffix(k, "-bin") {
+				v = base64.RawStdEncoding.EncodeToString([]byte(v))
+			}
+			header.Add(prefix+k, v)
+		}
+	}
+}
+
+// encodeGrpcMessage percent-encodes a status message, as required for the
+// grpc-message header and trailer.
+func encodeGrpcMessage(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+type connectError struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message,omitempty"`
+	Details []connectDetail `json:"details,omitempty"`
+}
+
+type connectDetail struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type connectEndStream struct {
+	Error    *connectError `json:"error,omitempty"`
+	Metadata http.Header   `json:"metadata,omitempty"`
+}
+
+func newConnectError(stat *status.Status) *connectError {
+	ce := &connectError{
+		Code:    connectCodes[stat.Code()],
+		Message: stat.Message(),
+	}
+	for _, d := range stat.Proto().GetDetails() {
+		typeName := d.GetTypeUrl()
+		if slash := strings.LastIndex(typeName, "/"); slash >= 0 {
+			typeName = typeName[slash+1:]
+		}
+		ce.Details = append(ce.Details, connectDetail{
+			Type:  typeName,
+			Value: base64.RawStdEncoding.EncodeToString(d.GetValue()),
+		})
+	}
+	return ce
+}
+
+var connectCodes = map[codes.Code]string{
+	codes.OK:                 "ok",
+	codes.Canceled:           "canceled",
+	codes.Unknown:            "unknown",
+	codes.InvalidArgument:    "invalid_argument",
+	codes.DeadlineExceeded:   "deadline_exceeded",
+	codes.NotFound:           "not_found",
+	codes.AlreadyExists:      "already_exists",
+	codes.PermissionDenied:   "permission_denied",
+	codes.ResourceExhausted:  "resource_exhausted",
+	codes.FailedPrecondition: "failed_precondition",
+	codes.Aborted:            "aborted",
+	codes.OutOfRange:         "out_of_range",
+	codes.Unimplemented:      "unimplemented",
+	codes.Internal:           "internal",
+	codes.Unavailable:        "unavailable",
+	codes.DataLoss:           "data_loss",
+	codes.Unauthenticated:    "unauthenticated",
+}
+
+// hopHeaders are request headers that describe the HTTP exchange itself (or
+// that the gateway consumes) and so are not forwarded as gRPC metadata.
+// Cookies are the browser's credentials for the gateway's site, not for
+// backends, and are not forwarded either.
+var hopHeaders = map[string]bool{
+	"Accept":               true,
+	"Accept-Encoding":      true,
+	"Addr":                 true,
+	"Connection":           true,
+	"Content-Encoding":     true,
+	"Content-Length":       true,
+	"Content-Type":         true,
+	"Cookie":               true,
+	"Grpc-Accept-Encoding": true,
+	"Grpc-Encoding":        true,
+	"Grpc-Timeout":         true,
+	"Host":                 true,
+	"Keep-Alive":           true,
+	"Method":               true,
+	"Te":                   true,
+	"Trailer":              true,
+	"Transfer-Encoding":    true,
+	"Upgrade":              true,
+	"User-Agent":           true,
+	"X-Grpc-Web":           true,
+	"X-User-Agent":         true,
+	"Proxy-Authorization":  true,
+	"Proxy-Connection":     true,
+	"Sec-Fetch-Mode":       true,
+	"Sec-Fetch-Site":       true,
+	"Sec-Fetch-Dest":       true,
+}
+
+// rpcHeaders returns the headers, in 'name: value' form, sent as metadata
+// when invoking the backend: the configured -H and -rpc-header values plus the
+// request's own headers.
+func rpcHeaders(req *http.Request) []string {
+	backends := serverConf().Backends
+	headers := append(append([]string(nil), backends.Headers...), backends.RPCHeaders...)
+	for k, vs := range req.Header {
+		if hopHeaders[k] || strings.HasPrefix(k, "Connect-") {
+			continue
+		}
+		for _, v := range vs {
+			headers = append(headers, k+": "+v)
+		}
+	}
+	return headers
+}
+
+// requestTimeout returns the deadline requested by the client via the
+// grpc-timeout or Connect-Timeout-Ms header, or zero if there is none.
+func requestTimeout(req *http.Request) time.Duration {
+	if ms := req.Header.Get("Connect-Timeout-Ms"); ms != "" {
+		if n, err := strconv.ParseInt(ms, 10, 64); err == nil && n > 0 {
+			return time.Duration(n) * time.Millisecond
+		}
+	}
+	t := req.Header.Get("Grpc-Timeout")
+	if len(t) < 2 {
+		return 0
+	}
+	n, err := strconv.ParseInt(t[:len(t)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[t[len(t)-1]]
+	if !ok {
+		return 0
+	}
+	return time.Duration(n) * unit
+}
+
+// serveWebRPC handles a gRPC-Web or Connect request. Unlike the envelope
+// mode, every outcome, including failures to route or dial, is reported in
+// the shape the protocol defines so generated clients can consume it.
+func serveWebRPC(writer http.ResponseWriter, req *http.Request, register registry.Register, p protocol, codecName string) {
+	h := newWebEventHandler(writer, req, p)
+	err := invokeWebRPC(req, register, p, codecName, h)
+	if errors.Is(context.Cause(req.Context()), errShuttingDown) {
+		err = cancelledStatus(req.Context()).Err()
+	}
+	h.finish(err)
+}
+
+func invokeWebRPC(req *http.Request, register registry.Register, p protocol, codecName string, h *webEventHandler) error {
+	ctx, cancelCall := withCallTimeout(req.Context())
+	defer cancelCall()
+	if timeout := requestTimeout(req); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	for _, k := range []string{"Content-Encoding", "Grpc-Encoding", "Connect-Content-Encoding"} {
+		if enc := req.Header.Get(k); enc != "" && enc != "identity" {
+			return status.Errorf(codes.Unimplemented, "unsupported compression: %s", enc)
+		}
+	}
+
+	r, err := register.Register()
+	if err != nil {
+		return status.Error(codes.Unimplemented, err.Error())
+	}
+	h.access.setRPC(r)
+	cc, err := dial(ctx, r)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to dial %q: %v", r.Addr, err)
+	}
+	defer cc.Close()
+
+	descSource, reset, err := descriptorSource(ctx, r.Addr, []string{r.Service}, cc)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer reset()
+
+	h.codec, err = newMessageCodec(codecName, descSource)
+	if err != nil {
+		return err
+	}
+
+	body := io.Reader(req.Body)
+	if p == protocolGRPCWebText {
+		if body, err = decodeWebText(body, maxMsgSz()); err != nil {
+			return err
+		}
+	}
+	var rf grpcgateway.RequestParser
+	if p == protocolConnectUnary {
+		rf = &unaryParser{r: body, codec: h.codec}
+	} else {
+		rf = &envelopeParser{r: body, codec: h.codec}
+	}
+
+	h.pipeline = transformFor(r.Method)
+	h.log = logFormatter(descSource)
+	var invalid error
+	err = grpcgateway.InvokeRPC(ctx, descSource, cc, r.Method, rpcHeaders(req), h, validated(logged(h.pipeline.Request(rf.Next, req), h.log), &invalid))
+	h.access.setCounts(rf.NumRequests(), h.numResponses)
+	if err != nil {
+		if invalid != nil {
+			return invalid
+		}
+		logger.Ctx(ctx).Errorf("%+v Error invoking method %q", err, r.Method)
+		return err
+	}
+	if *config.Verbose || *config.VeryVerbose {
+		logger.Ctx(ctx).Infof("Sent %d request(s) and received %d response(s)", rf.NumRequests(), h.numResponses)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gatewaytesting "github.com/LCY2013/http-to-grpc-gateway/internal/testing"
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDecodeWebText(t *testing.T) {
+	// two independently padded chunks
+	in := base64.StdEncoding.EncodeToString([]byte{0, 1}) + base64.StdEncoding.EncodeToString([]byte{2, 3, 4, 5})
+	r, err := decodeWebText(strings.NewReader(in), 10)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	b, _ := io.ReadAll(r)
+	if !bytes.Equal(b, []byte{0, 1, 2, 3, 4, 5}) {
+		t.Errorf("wrong bytes decoded: %v", b)
+	}
+
+	if _, err := decodeWebText(strings.NewReader("AAE"), 10); err == nil {
+		t.Error("expecting error for truncated base64")
+	}
+
+	// 10 bytes and a frame header take 20 base64 bytes, at most 40 are read
+	if _, err := decodeWebText(strings.NewReader(strings.Repeat("AAAA", 10)), 10); err != nil {
+		t.Errorf("expecting a body within the limit to be decoded, got %v", err)
+	}
+	if _, err := decodeWebText(strings.NewReader(strings.Repeat("AAAA", 11)), 10); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expecting a body over the limit to be rejected, got %v", err)
+	}
+}
+
+func TestRPCHeaders(t *testing.T) {
+	req := httptest.NewRequest("POST", "/testing.TestService/UnaryCall", nil)
+	req.Header.Set("Content-Type", "application/grpc-web")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Tenant", "acme")
+	if got := strings.Join(rpcHeaders(req), ","); got != "X-Tenant: acme" {
+		t.Errorf("expecting only X-Tenant to be forwarded, got %q", got)
+	}
+}
+
+func TestEncodeGrpcMessage(t *testing.T) {
+	if s := encodeGrpcMessage("100% done\n"); s != "100%25 done%0A" {
+		t.Errorf("wrong encoding: %q", s)
+	}
+}
+
+func frame(flags byte, b []byte) []byte {
+	var prefix [5]byte
+	prefix[0] = flags
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(b)))
+	return append(prefix[:], b...)
+}
+
+// readFrames splits a gRPC-Web or Connect stream body into its frames.
+func readFrames(t *testing.T, b []byte) (flags []byte, payloads [][]byte) {
+	t.Helper()
+	for len(b) > 0 {
+		if len(b) < 5 {
+			t.Fatalf("truncated frame prefix: %v", b)
+		}
+		size := int(binary.BigEndian.Uint32(b[1:5]))
+		if len(b) < 5+size {
+			t.Fatalf("truncated frame")
+		}
+		flags = append(flags, b[0])
+		payloads = append(payloads, b[5:5+size])
+		b = b[5+size:]
+	}
+	return flags, payloads
+}
+
+func TestGRPCWeb(t *testing.T) {
+	addr := newTestBackend(t, nil)
+	svr := httptest.NewServer(registerWithServe([]string{"http"}))
+	defer svr.Close()
+
+	reqMsg := &gatewaytesting.SimpleRequest{Payload: &gatewaytesting.Payload{Body: []byte("hello")}}
+	b, err := proto.Marshal(reqMsg)
+	if err != nil {
+		t.Fatalf("failed to marshal request: %v", err)
+	}
+
+	for _, text := range []bool{false, true} {
+		body := frame(0, b)
+		contentType := "application/grpc-web+proto"
+		if text {
+			body = []byte(base64.StdEncoding.EncodeToString(body))
+			contentType = "application/grpc-web-text"
+		}
+		req, _ := http.NewRequest("POST", svr.URL+"/testing.TestService/UnaryCall", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Addr", addr)
+		req.Header.Set("Reply-With-Trailers", "foo: bar")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != contentType {
+			t.Errorf("wrong Content-Type: %q", ct)
+		}
+		if text {
+			if respBody, err = base64.StdEncoding.DecodeString(string(respBody)); err != nil {
+				t.Fatalf("response is not base64: %v", err)
+			}
+		}
+
+		flags, payloads := readFrames(t, respBody)
+		if len(flags) != 2 || flags[0] != 0 || flags[1] != flagTrailers {
+			t.Fatalf("unexpected frames: %v", flags)
+		}
+		var respMsg gatewaytesting.SimpleResponse
+		if err := proto.Unmarshal(payloads[0], &respMsg); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if string(respMsg.GetPayload().GetBody()) != "hello" {
+			t.Errorf("wrong payload echoed: %q", respMsg.GetPayload().GetBody())
+		}
+		trailers := string(payloads[1])
+		if !strings.Contains(trailers, "grpc-status: 0\r\n") || !strings.Contains(trailers, "foo: bar\r\n") {
+			t.Errorf("unexpected trailers: %q", trailers)
+		}
+	}
+}
+
+func TestConnectUnary(t *testing.T) {
+	addr := newTestBackend(t, nil)
+	svr := httptest.NewServer(registerWithServe([]string{"http"}))
+	defer svr.Close()
+
+	post := func(failEarly string) *http.Response {
+		req, _ := http.NewRequest("POST", svr.URL+"/testing.TestService/UnaryCall", strings.NewReader(`{"payload":{"body":"aGVsbG8="}}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Connect-Protocol-Version", "1")
+		req.Header.Set("Addr", addr)
+		req.Header.Set("Reply-With-Trailers", "foo: bar")
+		if failEarly != "" {
+			req.Header.Set("Fail-Early", failEarly)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := post("")
+	var ok struct {
+		Payload struct {
+			Body string `json:"body"`
+		} `json:"payload"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&ok)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || ok.Payload.Body != "aGVsbG8=" {
+		t.Errorf("unexpected response: %d %+v", resp.StatusCode, ok)
+	}
+	if v := resp.Header.Get("Trailer-Foo"); v != "bar" {
+		t.Errorf("expecting trailer as header, got %q", v)
+	}
+
+	resp = post("5")
+	var ce connectError
+	err = json.NewDecoder(resp.Body).Decode(&ce)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	if resp.StatusCode != http.StatusNotFound || ce.Code != "not_found" || ce.Message != "fail" {
+		t.Errorf("unexpected error response: %d %+v", resp.StatusCode, ce)
+	}
+}
+
+func TestConnectStream(t *testing.T) {
+	addr := newTestBackend(t, nil)
+	svr := httptest.NewServer(registerWithServe([]string{"http"}))
+	defer svr.Close()
+
+	var body []byte
+	for _, s := range []string{"a", "b"} {
+		b, _ := proto.Marshal(&gatewaytesting.StreamingOutputCallRequest{Payload: &gatewaytesting.Payload{Body: []byte(s)}})
+		body = append(body, frame(0, b)...)
+	}
+	req, _ := http.NewRequest("POST", svr.URL+"/testing.TestService/HalfDuplexCall", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/connect+proto")
+	req.Header.Set("Addr", addr)
+	req.Header.Set("Fail-Late", "9")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	flags, payloads := readFrames(t, respBody)
+	if len(flags) != 3 || flags[2] != flagEndStream {
+		t.Fatalf("unexpected frames: %v", flags)
+	}
+	var end connectEndStream
+	if err := json.Unmarshal(payloads[2], &end); err != nil {
+		t.Fatalf("failed to decode end of stream: %v", err)
+	}
+	if end.Error == nil || end.Error.Code != "failed_precondition" {
+		t.Errorf("unexpected end of stream: %s", payloads[2])
+	}
+}
@@ -0,0 +1,317 @@
+// Package transform rewrites messages between their HTTP and gRPC forms, as
+// configured per method: renaming, defaulting and dropping fields, setting
+// fields from HTTP headers and query parameters, and exchanging a message for
+// one of its fields as the HTTP body.
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 the dynamic message API is built on the v1 API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Pipeline applies a sequence of transforms to the requests and responses of
+// a method. The nil Pipeline leaves messages as they are.
+type Pipeline struct {
+	request  []config.MessageTransform
+	response []config.MessageTransform
+}
+
+// New returns a pipeline that applies the given transforms in order, or nil
+// if they are all empty.
+func New(transforms ...config.Transform) *Pipeline {
+	p := &Pipeline{}
+	for _, t := range transforms {
+		if !empty(t.Request) {
+			p.request = append(p.request, t.Request)
+		}
+		if !empty(t.Response) {
+			p.response = append(p.response, t.Response)
+		}
+	}
+	if len(p.request) == 0 && len(p.response) == 0 {
+		return nil
+	}
+	return p
+}
+
+func empty(t config.MessageTransform) bool {
+	return len(t.Headers) == 0 && len(t.Query) == 0 && len(t.Rename) == 0 &&
+		len(t.Defaults) == 0 && len(t.Drop) == 0 && t.Body == ""
+}
+
+// body returns the body field of the given transforms, the last one set
+// taking precedence.
+func body(transforms []config.MessageTransform) string {
+	b := ""
+	for _, t := range transforms {
+		if t.Body != "" {
+			b = t.Body
+		}
+	}
+	return b
+}
+
+// Request wraps a supplier of parsed requests so that the requests it
+// supplies are transformed. If a body field is configured, next parses the
+// value of that field rather than the whole request.
+func (p *Pipeline) Request(next func(proto.Message) error, req *http.Request) func(proto.Message) error {
+	if p == nil || len(p.request) == 0 {
+		return next
+	}
+	return func(m proto.Message) error {
+		dm, ok := m.(*dynamic.Message)
+		if !ok {
+			var err error
+			if dm, err = dynamic.AsDynamicMessage(m); err != nil {
+				return status.Errorf(codes.Internal, "failed to transform request: %v", err)
+			}
+		}
+		if path := body(p.request); path != "" {
+			parent, fd, err := resolve(dm, path, true)
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to transform request: %v", err)
+			}
+			if fd.GetMessageType() == nil || fd.IsRepeated() {
+				return status.Errorf(codes.Internal, "failed to transform request: body %s is not a singular message field", path)
+			}
+			inner := dynamic.NewMessage(fd.GetMessageType())
+			if err := next(inner); err != nil {
+				return err
+			}
+			parent.SetField(fd, inner)
+		} else if err := next(dm); err != nil {
+			return err
+		}
+		if err := p.ApplyRequest(dm, req.Header, req.URL.Query()); err != nil {
+			return status.Errorf(codes.InvalidArgument, "failed to transform request: %v", err)
+		}
+		if !ok {
+			return dm.ConvertTo(m)
+		}
+		return nil
+	}
+}
+
+// ApplyRequest applies the request transforms to msg, other than the body,
+// with the given headers and query parameters.
+func (p *Pipeline) ApplyRequest(msg *dynamic.Message, header http.Header, query url.Values) error {
+	if p == nil {
+		return nil
+	}
+	for _, t := range p.request {
+		for _, h := range t.Headers {
+			if err := setFromStrings(msg, h.Field, header.Values(h.Name)); err != nil {
+				return fmt.Errorf("header %s: %v", h.Name, err)
+			}
+		}
+		for _, q := range t.Query {
+			if err := setFromStrings(msg, q.Field, query[q.Name]); err != nil {
+				return fmt.Errorf("query parameter %s: %v", q.Name, err)
+			}
+		}
+		if err := apply(msg, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Response transforms a response. If a body field is configured, the
+// result is the value of that field rather than the whole response.
+func (p *Pipeline) Response(resp proto.Message) (proto.Message, error) {
+	if p == nil || len(p.response) == 0 {
+		return resp, nil
+	}
+	dm, ok := resp.(*dynamic.Message)
+	if !ok {
+		var err error
+		if dm, err = dynamic.AsDynamicMessage(resp); err != nil {
+			return nil, err
+		}
+	}
+	return p.ApplyResponse(dm)
+}
+
+// ApplyResponse applies the response transforms to msg, returning the body.
+func (p *Pipeline) ApplyResponse(msg *dynamic.Message) (proto.Message, error) {
+	if p == nil {
+		return msg, nil
+	}
+	for _, t := range p.response {
+		if err := apply(msg, t); err != nil {
+			return nil, err
+		}
+	}
+	path := body(p.response)
+	if path == "" {
+		return msg, nil
+	}
+	parent, fd, err := resolve(msg, path, false)
+	if err != nil {
+		return nil, err
+	}
+	if fd.GetMessageType() == nil || fd.IsRepeated() {
+		return nil, fmt.Errorf("body %s is not a singular message field", path)
+	}
+	if parent == nil || !parent.HasField(fd) {
+		return dynamic.NewMessage(fd.GetMessageType()), nil
+	}
+	return parent.GetField(fd).(proto.Message), nil
+}
+
+// apply applies the renames, defaults and drops of t to msg.
+func apply(msg *dynamic.Message, t config.MessageTransform) error {
+	for _, r := range t.Rename {
+		parent, fd, err := resolve(msg, r.From, false)
+		if err != nil {
+			return err
+		}
+		if parent == nil || !parent.HasField(fd) {
+			continue
+		}
+		v := parent.GetField(fd)
+		parent.ClearField(fd)
+		to, toFd, err := resolve(msg, r.To, true)
+		if err != nil {
+			return err
+		}
+		if err := to.TrySetField(toFd, v); err != nil {
+			return fmt.Errorf("cannot rename %s to %s: %v", r.From, r.To, err)
+		}
+	}
+	for _, d := range t.Defaults {
+		parent, fd, err := resolve(msg, d.Field, true)
+		if err != nil {
+			return err
+		}
+		if parent.HasField(fd) {
+			continue
+		}
+		if err := setJSON(parent, fd, d.Value); err != nil {
+			return fmt.Errorf("default of %s: %v", d.Field, err)
+		}
+	}
+	for _, path := range t.Drop {
+		parent, fd, err := resolve(msg, path, false)
+		if err != nil {
+			return err
+		}
+		if parent != nil {
+			parent.ClearField(fd)
+		}
+	}
+	return nil
+}
+
+// resolve returns the field at the end of path and the message that holds
+// it. Intermediate messages that are not set are created if create is true;
+// otherwise the returned message is nil.
+func resolve(msg *dynamic.Message, path string, create bool) (*dynamic.Message, *desc.FieldDescriptor, error) {
+	names := strings.Split(path, ".")
+	md := msg.GetMessageDescriptor()
+	for i, name := range names {
+		fd := md.FindFieldByName(name)
+		if fd == nil {
+			fd = md.FindFieldByJSONName(name)
+		}
+		if fd == nil {
+			return nil, nil, fmt.Errorf("%s: %s has no field %q", path, md.GetFullyQualifiedName(), name)
+		}
+		if i == len(names)-1 {
+			return msg, fd, nil
+		}
+		if fd.GetMessageType() == nil || fd.IsRepeated() || fd.IsMap() {
+			return nil, nil, fmt.Errorf("%s: %s is not a singular message field", path, name)
+		}
+		md = fd.GetMessageType()
+		if msg == nil {
+			// the rest of the path is only checked
+			continue
+		}
+		if !msg.HasField(fd) {
+			if !create {
+				msg = nil
+				continue
+			}
+			msg.SetField(fd, dynamic.NewMessage(fd.GetMessageType()))
+		}
+		sub, ok := msg.GetField(fd).(*dynamic.Message)
+		if !ok {
+			var err error
+			if sub, err = dynamic.AsDynamicMessage(msg.GetField(fd).(proto.Message)); err != nil {
+				return nil, nil, err
+			}
+			// replaced, so that changes to sub are changes to msg
+			msg.SetField(fd, sub)
+		}
+		msg = sub
+	}
+	return nil, nil, fmt.Errorf("empty field path")
+}
+
+// setJSON sets a field to a value given as it would be in JSON.
+func setJSON(msg *dynamic.Message, fd *desc.FieldDescriptor, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	name, err := json.Marshal(fd.GetName())
+	if err != nil {
+		return err
+	}
+	msg.ClearField(fd)
+	return msg.UnmarshalMergeJSON([]byte(fmt.Sprintf("{%s:%s}", name, b)))
+}
+
+// setFromStrings sets the field at path from header or query parameter
+// values, if there are any. Only repeated fields take more than one value.
+func setFromStrings(msg *dynamic.Message, path string, values []string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	parent, fd, err := resolve(msg, path, true)
+	if err != nil {
+		return err
+	}
+	if !fd.IsRepeated() {
+		return setJSON(parent, fd, fromString(fd, values[0]))
+	}
+	list := make([]interface{}, len(values))
+	for i, v := range values {
+		list[i] = fromString(fd, v)
+	}
+	return setJSON(parent, fd, list)
+}
+
+// fromString converts a string to the JSON value of a field: numbers and
+// booleans are parsed, anything else is used as a JSON string. Enums may be
+// given by name or number.
+func fromString(fd *desc.FieldDescriptor, s string) interface{} {
+	switch fd.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+		descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		return s
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		if _, err := strconv.ParseInt(s, 10, 32); err == nil {
+			return json.RawMessage(s)
+		}
+		return s
+	}
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	return s
+}
@@ -0,0 +1,181 @@
+package transform
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	grpcgateway "github.com/LCY2013/http-to-grpc-gateway"
+	"github.com/LCY2013/http-to-grpc-gateway/internal/config"
+	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 the dynamic message API is built on the v1 API
+	"github.com/golang/protobuf/proto"  //lint:ignore SA1019 the dynamic message API is built on the v1 API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func messageType(t *testing.T, name string) *desc.MessageDescriptor {
+	t.Helper()
+	source, err := grpcgateway.DescriptorSourceFromProtoSets("../testing/test.protoset")
+	if err != nil {
+		t.Fatalf("failed to load protoset: %v", err)
+	}
+	d, err := source.FindSymbol(name)
+	if err != nil {
+		t.Fatalf("failed to find %s: %v", name, err)
+	}
+	return d.(*desc.MessageDescriptor)
+}
+
+func newMessage(t *testing.T, name, js string) *dynamic.Message {
+	t.Helper()
+	msg := dynamic.NewMessage(messageType(t, name))
+	if err := msg.UnmarshalJSON([]byte(js)); err != nil {
+		t.Fatalf("failed to parse %s: %v", js, err)
+	}
+	return msg
+}
+
+func toJSON(t *testing.T, msg proto.Message) string {
+	t.Helper()
+	js, err := (&jsonpb.Marshaler{OrigName: true}).MarshalToString(msg)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	return js
+}
+
+func TestNew(t *testing.T) {
+	if p := New(); p != nil {
+		t.Errorf("expecting nil pipeline without transforms, got %+v", p)
+	}
+	if p := New(config.Transform{}, config.Transform{}); p != nil {
+		t.Errorf("expecting nil pipeline for empty transforms, got %+v", p)
+	}
+
+	// the nil pipeline leaves messages as they are
+	var p *Pipeline
+	msg := newMessage(t, "testing.SimpleResponse", `{"username":"joe"}`)
+	resp, err := p.Response(msg)
+	if err != nil || resp != msg {
+		t.Errorf("expecting response to be unchanged, got %v, %v", resp, err)
+	}
+}
+
+func TestApplyRequest(t *testing.T) {
+	p := New(config.Transform{Request: config.MessageTransform{
+		Headers: []config.FieldSource{
+			{Name: "X-Size", Field: "response_size"},
+			{Name: "X-Code", Field: "response_status.code"},
+		},
+		Query:    []config.FieldSource{{Name: "type", Field: "responseType"}},
+		Rename:   []config.FieldRename{{From: "fill_username", To: "fill_oauth_scope"}},
+		Defaults: []config.FieldValue{{Field: "response_status.message", Value: "none"}},
+		Drop:     []string{"payload"},
+	}})
+
+	msg := newMessage(t, "testing.SimpleRequest", `{"fill_username":true,"payload":{"body":"AQI="}}`)
+	header := http.Header{"X-Size": {"10"}, "X-Code": {"3"}}
+	query := url.Values{"type": {"UNCOMPRESSABLE"}}
+	if err := p.ApplyRequest(msg, header, query); err != nil {
+		t.Fatalf("failed to transform request: %v", err)
+	}
+	expected := `{"response_type":"UNCOMPRESSABLE","response_size":10,"fill_oauth_scope":true,"response_status":{"code":3,"message":"none"}}`
+	if js := toJSON(t, msg); js != expected {
+		t.Errorf("wrong request:\nexpected %s\ngot      %s", expected, js)
+	}
+
+	// defaults do not replace values that are set, and enums may be numbers
+	msg = newMessage(t, "testing.SimpleRequest", `{"response_status":{"message":"set"}}`)
+	if err := p.ApplyRequest(msg, nil, url.Values{"type": {"2"}}); err != nil {
+		t.Fatalf("failed to transform request: %v", err)
+	}
+	expected = `{"response_type":"RANDOM","response_status":{"message":"set"}}`
+	if js := toJSON(t, msg); js != expected {
+		t.Errorf("wrong request:\nexpected %s\ngot      %s", expected, js)
+	}
+
+	msg = newMessage(t, "testing.SimpleRequest", `{}`)
+	if err := p.ApplyRequest(msg, http.Header{"X-Size": {"big"}}, nil); err == nil {
+		t.Error("expecting error for invalid header value")
+	}
+
+	bad := New(config.Transform{Request: config.MessageTransform{Drop: []string{"payload.missing"}}})
+	if err := bad.ApplyRequest(msg, nil, nil); err == nil || !strings.Contains(err.Error(), `no field "missing"`) {
+		t.Errorf("expecting error for unknown field, got %v", err)
+	}
+}
+
+func TestRequestBody(t *testing.T) {
+	p := New(config.Transform{Request: config.MessageTransform{
+		Headers: []config.FieldSource{{Name: "X-Size", Field: "response_size"}},
+		Body:    "payload",
+	}})
+	req, _ := http.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("X-Size", "5")
+
+	next := func(m proto.Message) error {
+		if name := m.(*dynamic.Message).GetMessageDescriptor().GetFullyQualifiedName(); name != "testing.Payload" {
+			t.Errorf("expecting body to be parsed into testing.Payload, got %s", name)
+		}
+		return jsonpb.UnmarshalString(`{"type":"COMPRESSABLE","body":"AQI="}`, m)
+	}
+	msg := dynamic.NewMessage(messageType(t, "testing.SimpleRequest"))
+	if err := p.Request(next, req)(msg); err != nil {
+		t.Fatalf("failed to transform request: %v", err)
+	}
+	expected := `{"response_size":5,"payload":{"body":"AQI="}}`
+	if js := toJSON(t, msg); js != expected {
+		t.Errorf("wrong request:\nexpected %s\ngot      %s", expected, js)
+	}
+
+	// invalid values are reported as such
+	req.Header.Set("X-Size", "big")
+	err := p.Request(next, req)(dynamic.NewMessage(messageType(t, "testing.SimpleRequest")))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expecting InvalidArgument, got %v", err)
+	}
+}
+
+func TestResponse(t *testing.T) {
+	p := New(
+		config.Transform{Response: config.MessageTransform{Drop: []string{"oauth_scope"}}},
+		config.Transform{Response: config.MessageTransform{
+			Rename:   []config.FieldRename{{From: "username", To: "oauth_scope"}},
+			Defaults: []config.FieldValue{{Field: "payload.type", Value: "RANDOM"}},
+		}},
+	)
+	msg := newMessage(t, "testing.SimpleResponse", `{"username":"joe","oauth_scope":"secret"}`)
+	resp, err := p.Response(msg)
+	if err != nil {
+		t.Fatalf("failed to transform response: %v", err)
+	}
+	expected := `{"payload":{"type":"RANDOM"},"oauth_scope":"joe"}`
+	if js := toJSON(t, resp); js != expected {
+		t.Errorf("wrong response:\nexpected %s\ngot      %s", expected, js)
+	}
+
+	unwrap := New(config.Transform{Response: config.MessageTransform{Body: "payload"}})
+	msg = newMessage(t, "testing.SimpleResponse", `{"username":"joe","payload":{"body":"AQI="}}`)
+	if resp, err = unwrap.Response(msg); err != nil {
+		t.Fatalf("failed to transform response: %v", err)
+	}
+	if js := toJSON(t, resp); js != `{"body":"AQI="}` {
+		t.Errorf("wrong response body: %s", js)
+	}
+
+	// an unset body is empty rather than missing
+	if resp, err = unwrap.Response(newMessage(t, "testing.SimpleResponse", `{}`)); err != nil {
+		t.Fatalf("failed to transform response: %v", err)
+	}
+	if js := toJSON(t, resp); js != `{}` {
+		t.Errorf("wrong response body: %s", js)
+	}
+
+	notMessage := New(config.Transform{Response: config.MessageTransform{Body: "username"}})
+	if _, err := notMessage.Response(msg); err == nil {
+		t.Error("expecting error for body that is not a message")
+	}
+}
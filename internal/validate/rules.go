@@ -0,0 +1,338 @@
+package validate
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ordering compares and prints values of one kind, such as numbers or
+// durations, for the rules shared by all ordered kinds.
+type ordering struct {
+	compare func(a, b protoreflect.Value) int
+	show    func(v protoreflect.Value) string
+}
+
+var numbers = ordering{
+	compare: func(a, b protoreflect.Value) int {
+		switch x := a.Interface().(type) {
+		case int32, int64:
+			return cmp(a.Int(), b.Int())
+		case uint32, uint64:
+			return cmp(a.Uint(), b.Uint())
+		case float32, float64:
+			return cmp(a.Float(), b.Float())
+		default:
+			panic(fmt.Sprintf("not a number: %T", x))
+		}
+	},
+	show: func(v protoreflect.Value) string {
+		return fmt.Sprint(v.Interface())
+	},
+}
+
+var durations = ordering{
+	compare: func(a, b protoreflect.Value) int {
+		return cmp(nanos(a.Message()), nanos(b.Message()))
+	},
+	show: func(v protoreflect.Value) string {
+		return time.Duration(nanos(v.Message())).String()
+	},
+}
+
+var timestamps = ordering{
+	compare: durations.compare,
+	show: func(v protoreflect.Value) string {
+		return time.Unix(0, nanos(v.Message())).UTC().Format(time.RFC3339Nano)
+	},
+}
+
+var texts = ordering{
+	compare: func(a, b protoreflect.Value) int {
+		return strings.Compare(a.String(), b.String())
+	},
+	show: func(v protoreflect.Value) string {
+		return strconv.Quote(v.String())
+	},
+}
+
+func cmp[T int64 | uint64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// nanos converts a google.protobuf.Duration or Timestamp to nanoseconds.
+func nanos(msg protoreflect.Message) int64 {
+	fields := msg.Descriptor().Fields()
+	var seconds, ns int64
+	if fd := fields.ByName("seconds"); fd != nil {
+		seconds = msg.Get(fd).Int()
+	}
+	if fd := fields.ByName("nanos"); fd != nil {
+		ns = msg.Get(fd).Int()
+	}
+	return seconds*int64(time.Second) + ns
+}
+
+// ordered applies the const, lt, lte, gt, gte, in and not_in rules. As with
+// protoc-gen-validate, a lower bound that is greater than the upper bound
+// requires the value to lie outside of the range instead of inside.
+func (v *validator) ordered(path string, val protoreflect.Value, tr protoreflect.Message, o ordering) {
+	if c, ok := get(tr, "const"); ok && o.compare(val, c) != 0 {
+		v.add(path, "value must equal %s", o.show(c))
+	}
+
+	var lower, upper string
+	var lowerOK, upperOK = true, true
+	var lowerVal, upperVal protoreflect.Value
+	if gt, ok := get(tr, "gt"); ok {
+		lower, lowerVal, lowerOK = "greater than "+o.show(gt), gt, o.compare(val, gt) > 0
+	} else if gte, ok := get(tr, "gte"); ok {
+		lower, lowerVal, lowerOK = "greater than or equal to "+o.show(gte), gte, o.compare(val, gte) >= 0
+	}
+	if lt, ok := get(tr, "lt"); ok {
+		upper, upperVal, upperOK = "less than "+o.show(lt), lt, o.compare(val, lt) < 0
+	} else if lte, ok := get(tr, "lte"); ok {
+		upper, upperVal, upperOK = "less than or equal to "+o.show(lte), lte, o.compare(val, lte) <= 0
+	}
+	switch {
+	case lower != "" && upper != "" && o.compare(lowerVal, upperVal) > 0:
+		if !lowerOK && !upperOK {
+			v.add(path, "value must be %s or %s", lower, upper)
+		}
+	case !lowerOK && !upperOK:
+		v.add(path, "value must be %s and %s", lower, upper)
+	case !lowerOK:
+		v.add(path, "value must be %s", lower)
+	case !upperOK:
+		v.add(path, "value must be %s", upper)
+	}
+
+	if in, ok := get(tr, "in"); ok && in.List().Len() > 0 && !contains(in.List(), val, o) {
+		v.add(path, "value must be in list %s", listString(in.List(), o))
+	}
+	if notIn, ok := get(tr, "not_in"); ok && contains(notIn.List(), val, o) {
+		v.add(path, "value must not be in list %s", listString(notIn.List(), o))
+	}
+}
+
+func (v *validator) timestampRules(path string, msg protoreflect.Message, tr protoreflect.Message) {
+	v.ordered(path, protoreflect.ValueOfMessage(msg), tr, timestamps)
+
+	t := time.Unix(0, nanos(msg))
+	now := time.Now()
+	if boolField(tr, "lt_now") && !t.Before(now) {
+		v.add(path, "value must be in the past")
+	}
+	if boolField(tr, "gt_now") && !t.After(now) {
+		v.add(path, "value must be in the future")
+	}
+	if within, ok := get(tr, "within"); ok {
+		d := time.Duration(nanos(within.Message()))
+		if t.Before(now.Add(-d)) || t.After(now.Add(d)) {
+			v.add(path, "value must be within %v of now", d)
+		}
+	}
+}
+
+func (v *validator) stringRules(path string, s string, tr protoreflect.Message) {
+	runes := uint64(utf8.RuneCountInString(s))
+	size := uint64(len(s))
+	if c, ok := get(tr, "const"); ok && c.String() != s {
+		v.add(path, "value must equal %q", c.String())
+	}
+	if n, ok := get(tr, "len"); ok && runes != n.Uint() {
+		v.add(path, "value length must be %d characters", n.Uint())
+	}
+	if n, ok := get(tr, "min_len"); ok && runes < n.Uint() {
+		v.add(path, "value length must be at least %d characters", n.Uint())
+	}
+	if n, ok := get(tr, "max_len"); ok && runes > n.Uint() {
+		v.add(path, "value length must be at most %d characters", n.Uint())
+	}
+	if n, ok := get(tr, "len_bytes"); ok && size != n.Uint() {
+		v.add(path, "value length must be %d bytes", n.Uint())
+	}
+	if n, ok := get(tr, "min_bytes"); ok && size < n.Uint() {
+		v.add(path, "value length must be at least %d bytes", n.Uint())
+	}
+	if n, ok := get(tr, "max_bytes"); ok && size > n.Uint() {
+		v.add(path, "value length must be at most %d bytes", n.Uint())
+	}
+	v.pattern(path, s, tr)
+	if p, ok := get(tr, "prefix"); ok && !strings.HasPrefix(s, p.String()) {
+		v.add(path, "value does not have prefix %q", p.String())
+	}
+	if p, ok := get(tr, "suffix"); ok && !strings.HasSuffix(s, p.String()) {
+		v.add(path, "value does not have suffix %q", p.String())
+	}
+	if p, ok := get(tr, "contains"); ok && !strings.Contains(s, p.String()) {
+		v.add(path, "value does not contain substring %q", p.String())
+	}
+	if p, ok := get(tr, "not_contains"); ok && strings.Contains(s, p.String()) {
+		v.add(path, "value contains substring %q", p.String())
+	}
+	val := protoreflect.ValueOfString(s)
+	if in, ok := get(tr, "in"); ok && in.List().Len() > 0 && !contains(in.List(), val, texts) {
+		v.add(path, "value must be in list %s", listString(in.List(), texts))
+	}
+	if notIn, ok := get(tr, "not_in"); ok && contains(notIn.List(), val, texts) {
+		v.add(path, "value must not be in list %s", listString(notIn.List(), texts))
+	}
+
+	for _, wk := range wellKnownStrings {
+		if boolField(tr, wk.name) && !wk.valid(s) {
+			v.add(path, "value must be a valid %s", wk.desc)
+		}
+	}
+}
+
+func (v *validator) bytesRules(path string, b []byte, tr protoreflect.Message) {
+	size := uint64(len(b))
+	if c, ok := get(tr, "const"); ok && !bytes.Equal(c.Bytes(), b) {
+		v.add(path, "value must equal %x", c.Bytes())
+	}
+	if n, ok := get(tr, "len"); ok && size != n.Uint() {
+		v.add(path, "value length must be %d bytes", n.Uint())
+	}
+	if n, ok := get(tr, "min_len"); ok && size < n.Uint() {
+		v.add(path, "value length must be at least %d bytes", n.Uint())
+	}
+	if n, ok := get(tr, "max_len"); ok && size > n.Uint() {
+		v.add(path, "value length must be at most %d bytes", n.Uint())
+	}
+	v.pattern(path, string(b), tr)
+	if p, ok := get(tr, "prefix"); ok && !bytes.HasPrefix(b, p.Bytes()) {
+		v.add(path, "value does not have prefix %x", p.Bytes())
+	}
+	if p, ok := get(tr, "suffix"); ok && !bytes.HasSuffix(b, p.Bytes()) {
+		v.add(path, "value does not have suffix %x", p.Bytes())
+	}
+	if p, ok := get(tr, "contains"); ok && !bytes.Contains(b, p.Bytes()) {
+		v.add(path, "value does not contain %x", p.Bytes())
+	}
+	val := protoreflect.ValueOfBytes(b)
+	if in, ok := get(tr, "in"); ok && in.List().Len() > 0 && !containsBytes(in.List(), val) {
+		v.add(path, "value must be in the list of allowed values")
+	}
+	if notIn, ok := get(tr, "not_in"); ok && containsBytes(notIn.List(), val) {
+		v.add(path, "value must not be in the list of disallowed values")
+	}
+	if boolField(tr, "ip") && len(b) != net.IPv4len && len(b) != net.IPv6len {
+		v.add(path, "value must be a valid IP address in byte format")
+	}
+	if boolField(tr, "ipv4") && len(b) != net.IPv4len {
+		v.add(path, "value must be a valid IPv4 address in byte format")
+	}
+	if boolField(tr, "ipv6") && len(b) != net.IPv6len {
+		v.add(path, "value must be a valid IPv6 address in byte format")
+	}
+}
+
+// patterns caches compiled regular expressions, since the same rules are
+// evaluated for every request.
+var patterns sync.Map
+
+func (v *validator) pattern(path, s string, tr protoreflect.Message) {
+	p, ok := get(tr, "pattern")
+	if !ok {
+		return
+	}
+	var re *regexp.Regexp
+	if cached, ok := patterns.Load(p.String()); ok {
+		re = cached.(*regexp.Regexp)
+	} else {
+		var err error
+		if re, err = regexp.Compile(p.String()); err != nil {
+			v.add(path, "invalid pattern %q in validation rules: %v", p.String(), err)
+			return
+		}
+		patterns.Store(p.String(), re)
+	}
+	if !re.MatchString(s) {
+		v.add(path, "value does not match regex pattern %q", p.String())
+	}
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+var hostnamePattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.?$`)
+
+// wellKnownStrings are the string formats that can be required by setting a
+// boolean rule.
+var wellKnownStrings = []struct {
+	name  protoreflect.Name
+	desc  string
+	valid func(s string) bool
+}{
+	{"email", "email address", func(s string) bool {
+		addr, err := mail.ParseAddress(s)
+		return err == nil && addr.Name == "" && addr.Address == s
+	}},
+	{"hostname", "hostname", isHostname},
+	{"ip", "IP address", func(s string) bool { return net.ParseIP(s) != nil }},
+	{"ipv4", "IPv4 address", func(s string) bool {
+		ip := net.ParseIP(s)
+		return ip != nil && ip.To4() != nil && !strings.Contains(s, ":")
+	}},
+	{"ipv6", "IPv6 address", func(s string) bool {
+		return net.ParseIP(s) != nil && strings.Contains(s, ":")
+	}},
+	{"uri", "absolute URI", func(s string) bool {
+		u, err := url.Parse(s)
+		return err == nil && u.IsAbs()
+	}},
+	{"uri_ref", "URI reference", func(s string) bool {
+		_, err := url.Parse(s)
+		return err == nil
+	}},
+	{"address", "hostname or IP address", func(s string) bool {
+		return net.ParseIP(s) != nil || isHostname(s)
+	}},
+	{"uuid", "UUID", uuidPattern.MatchString},
+}
+
+func isHostname(s string) bool {
+	return len(s) <= 253 && hostnamePattern.MatchString(s)
+}
+
+func contains(l protoreflect.List, val protoreflect.Value, o ordering) bool {
+	for i := 0; i < l.Len(); i++ {
+		if o.compare(val, l.Get(i)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsBytes(l protoreflect.List, val protoreflect.Value) bool {
+	for i := 0; i < l.Len(); i++ {
+		if bytes.Equal(val.Bytes(), l.Get(i).Bytes()) {
+			return true
+		}
+	}
+	return false
+}
+
+func listString(l protoreflect.List, o ordering) string {
+	items := make([]string, l.Len())
+	for i := range items {
+		items[i] = o.show(l.Get(i))
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
@@ -0,0 +1,477 @@
+// Package validate checks messages against the validation rules declared in
+// their descriptors, so that the gateway can reject malformed requests before
+// they reach a backend.
+//
+// Both protoc-gen-validate rules (the validate.rules field option) and
+// protovalidate rules (the buf.validate.field field option) are supported.
+// Rules are read from the options of descriptors obtained from any
+// DescriptorSource, so backends need not be compiled with either plugin. CEL
+// expressions of protovalidate are not evaluated.
+package validate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/envoyproxy/protoc-gen-validate/validate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	// pgvExtension is the field number of the protoc-gen-validate options.
+	pgvExtension protowire.Number = 1071
+	// pgvIgnored is the field number of the validate.ignored message option.
+	pgvIgnored protowire.Number = 1072
+	// protovalidateExtension is the field number of the protovalidate
+	// options.
+	protovalidateExtension protowire.Number = 1159
+)
+
+var pgvFieldRules = (&validate.FieldRules{}).ProtoReflect().Descriptor()
+
+// Message checks msg and, recursively, the messages it contains against
+// their validation rules. It returns the violations found, if any.
+func Message(msg protoreflect.Message) []*errdetails.BadRequest_FieldViolation {
+	var v validator
+	v.message("", msg)
+	return v.violations
+}
+
+// Error returns an InvalidArgument status error that carries the given
+// violations as a google.rpc.BadRequest detail, or nil if there are none.
+func Error(violations []*errdetails.BadRequest_FieldViolation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(violations))
+	for i, fv := range violations {
+		msgs[i] = fv.Field + ": " + fv.Description
+	}
+	stat := status.New(codes.InvalidArgument, "invalid request: "+strings.Join(msgs, "; "))
+	if withDetails, err := stat.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); err == nil {
+		stat = withDetails
+	}
+	return stat.Err()
+}
+
+type validator struct {
+	violations []*errdetails.BadRequest_FieldViolation
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.violations = append(v.violations, &errdetails.BadRequest_FieldViolation{
+		Field:       path,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) message(path string, msg protoreflect.Message) {
+	md := msg.Descriptor()
+	if messageDisabled(md) {
+		return
+	}
+	oneofs := md.Oneofs()
+	for i := 0; i < oneofs.Len(); i++ {
+		od := oneofs.Get(i)
+		if !od.IsSynthetic() && oneofRequired(od) && msg.WhichOneof(od) == nil {
+			v.add(join(path, string(od.Name())), "exactly one field is required in oneof")
+		}
+	}
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		v.field(join(path, string(fd.Name())), msg, fd)
+	}
+}
+
+func (v *validator) field(path string, msg protoreflect.Message, fd protoreflect.FieldDescriptor) {
+	rules := fieldRules(fd)
+	has := msg.Has(fd)
+	skip := false
+	for _, r := range rules {
+		tr := typeRules(r)
+		required := boolField(r, "required") || boolField(subRules(r, "message"), "required") || boolField(tr, "required")
+		if !has && required {
+			v.add(path, "value is required")
+			continue
+		}
+		if boolField(subRules(r, "message"), "skip") || boolField(r, "skipped") || intField(r, "ignore") == 3 {
+			// protoc-gen-validate's skip and protovalidate's IGNORE_ALWAYS
+			skip = true
+			continue
+		}
+		if !has && (fd.HasPresence() || intField(r, "ignore") != 0 || boolField(r, "ignore_empty")) {
+			continue
+		}
+		v.value(path, fd, msg.Get(fd), tr, false)
+	}
+
+	if fd.Message() == nil || !has || skip {
+		return
+	}
+	switch {
+	case fd.IsList():
+		l := msg.Get(fd).List()
+		for i := 0; i < l.Len(); i++ {
+			v.message(fmt.Sprintf("%s[%d]", path, i), l.Get(i).Message())
+		}
+	case fd.IsMap():
+		if fd.MapValue().Message() == nil {
+			return
+		}
+		msg.Get(fd).Map().Range(func(k protoreflect.MapKey, val protoreflect.Value) bool {
+			v.message(fmt.Sprintf("%s[%q]", path, k.String()), val.Message())
+			return true
+		})
+	default:
+		v.message(path, msg.Get(fd).Message())
+	}
+}
+
+// value applies the rules for a kind of value, like StringRules, to val. If
+// elem is true, val is an element of the list fd rather than its value.
+func (v *validator) value(path string, fd protoreflect.FieldDescriptor, val protoreflect.Value, tr protoreflect.Message, elem bool) {
+	if tr == nil {
+		return
+	}
+	if boolField(tr, "ignore_empty") && isZero(fd, val, elem) {
+		return
+	}
+	switch name := tr.Descriptor().Name(); {
+	case name == "RepeatedRules":
+		if fd.IsList() && !elem {
+			v.repeated(path, fd, val.List(), tr)
+		}
+	case name == "MapRules":
+		if fd.IsMap() {
+			v.mapRules(path, fd, val.Map(), tr)
+		}
+	case fd.IsList() && !elem, fd.IsMap():
+		// rules for a single value cannot apply to a collection
+	case name == "StringRules":
+		v.stringRules(path, val.String(), tr)
+	case name == "BytesRules":
+		v.bytesRules(path, val.Bytes(), tr)
+	case name == "BoolRules":
+		if c, ok := get(tr, "const"); ok && c.Bool() != val.Bool() {
+			v.add(path, "value must equal %v", c.Bool())
+		}
+	case name == "EnumRules":
+		v.enumRules(path, fd.Enum(), val.Enum(), tr)
+	case name == "AnyRules":
+		if fd.Message() != nil && val.Message().IsValid() {
+			v.anyRules(path, val.Message(), tr)
+		}
+	case name == "DurationRules":
+		if fd.Message() != nil && val.Message().IsValid() {
+			v.ordered(path, val, tr, durations)
+		}
+	case name == "TimestampRules":
+		if fd.Message() != nil && val.Message().IsValid() {
+			v.timestampRules(path, val.Message(), tr)
+		}
+	default:
+		v.ordered(path, val, tr, numbers)
+	}
+}
+
+func (v *validator) repeated(path string, fd protoreflect.FieldDescriptor, l protoreflect.List, tr protoreflect.Message) {
+	n := uint64(l.Len())
+	if min, ok := get(tr, "min_items"); ok && n < min.Uint() {
+		v.add(path, "value must contain at least %d item(s)", min.Uint())
+	}
+	if max, ok := get(tr, "max_items"); ok && n > max.Uint() {
+		v.add(path, "value must contain no more than %d item(s)", max.Uint())
+	}
+	if boolField(tr, "unique") && fd.Message() == nil {
+		seen := map[interface{}]bool{}
+		for i := 0; i < l.Len(); i++ {
+			key := l.Get(i).Interface()
+			if b, ok := key.([]byte); ok {
+				key = string(b)
+			}
+			if seen[key] {
+				v.add(path, "repeated value must contain unique items")
+				break
+			}
+			seen[key] = true
+		}
+	}
+	if items := subRules(tr, "items"); items != nil {
+		for i := 0; i < l.Len(); i++ {
+			v.value(fmt.Sprintf("%s[%d]", path, i), fd, l.Get(i), typeRules(items), true)
+		}
+	}
+}
+
+func (v *validator) mapRules(path string, fd protoreflect.FieldDescriptor, m protoreflect.Map, tr protoreflect.Message) {
+	n := uint64(m.Len())
+	if min, ok := get(tr, "min_pairs"); ok && n < min.Uint() {
+		v.add(path, "value must contain at least %d pair(s)", min.Uint())
+	}
+	if max, ok := get(tr, "max_pairs"); ok && n > max.Uint() {
+		v.add(path, "value must contain no more than %d pair(s)", max.Uint())
+	}
+	keys, values := typeRules(subRules(tr, "keys")), typeRules(subRules(tr, "values"))
+	if keys == nil && values == nil {
+		return
+	}
+	m.Range(func(k protoreflect.MapKey, val protoreflect.Value) bool {
+		p := fmt.Sprintf("%s[%q]", path, k.String())
+		v.value(p, fd.MapKey(), k.Value(), keys, false)
+		v.value(p, fd.MapValue(), val, values, false)
+		return true
+	})
+}
+
+func (v *validator) enumRules(path string, ed protoreflect.EnumDescriptor, n protoreflect.EnumNumber, tr protoreflect.Message) {
+	if c, ok := get(tr, "const"); ok && protoreflect.EnumNumber(c.Int()) != n {
+		v.add(path, "value must equal %d", c.Int())
+	}
+	if boolField(tr, "defined_only") && ed.Values().ByNumber(n) == nil {
+		v.add(path, "value must be one of the defined enum values")
+	}
+	if in, ok := get(tr, "in"); ok && in.List().Len() > 0 && !contains(in.List(), protoreflect.ValueOfInt32(int32(n)), numbers) {
+		v.add(path, "value must be in list %s", listString(in.List(), numbers))
+	}
+	if notIn, ok := get(tr, "not_in"); ok && contains(notIn.List(), protoreflect.ValueOfInt32(int32(n)), numbers) {
+		v.add(path, "value must not be in list %s", listString(notIn.List(), numbers))
+	}
+}
+
+func (v *validator) anyRules(path string, msg protoreflect.Message, tr protoreflect.Message) {
+	fd := msg.Descriptor().Fields().ByName("type_url")
+	if fd == nil {
+		return
+	}
+	typeURL := protoreflect.ValueOfString(msg.Get(fd).String())
+	if in, ok := get(tr, "in"); ok && in.List().Len() > 0 && !contains(in.List(), typeURL, texts) {
+		v.add(path, "type URL must be in list %s", listString(in.List(), texts))
+	}
+	if notIn, ok := get(tr, "not_in"); ok && contains(notIn.List(), typeURL, texts) {
+		v.add(path, "type URL must not be in list %s", listString(notIn.List(), texts))
+	}
+}
+
+// typeRules returns the rules for the kind of value set in the type oneof of
+// a FieldRules (protoc-gen-validate) or FieldConstraints (protovalidate).
+func typeRules(r protoreflect.Message) protoreflect.Message {
+	if r == nil {
+		return nil
+	}
+	od := r.Descriptor().Oneofs().ByName("type")
+	if od == nil {
+		return nil
+	}
+	fd := r.WhichOneof(od)
+	if fd == nil || fd.Message() == nil {
+		return nil
+	}
+	return r.Get(fd).Message()
+}
+
+// fieldRules returns the rules declared in the options of fd, of both
+// protoc-gen-validate and protovalidate.
+func fieldRules(fd protoreflect.FieldDescriptor) []protoreflect.Message {
+	var rules []protoreflect.Message
+	if _, b, ok := option(fd.Options(), pgvExtension); ok {
+		if r := unmarshal(pgvFieldRules, b); r != nil {
+			rules = append(rules, r)
+		}
+	}
+	if _, b, ok := option(fd.Options(), protovalidateExtension); ok {
+		if r := unmarshal(findExtension(fd.ParentFile(), "buf.validate.field"), b); r != nil {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+func messageDisabled(md protoreflect.MessageDescriptor) bool {
+	if n, _, ok := option(md.Options(), pgvExtension); ok && n != 0 {
+		return true
+	}
+	if n, _, ok := option(md.Options(), pgvIgnored); ok && n != 0 {
+		return true
+	}
+	if _, b, ok := option(md.Options(), protovalidateExtension); ok {
+		return boolField(unmarshal(findExtension(md.ParentFile(), "buf.validate.message"), b), "disabled")
+	}
+	return false
+}
+
+func oneofRequired(od protoreflect.OneofDescriptor) bool {
+	if n, _, ok := option(od.Options(), pgvExtension); ok && n != 0 {
+		return true
+	}
+	if _, b, ok := option(od.Options(), protovalidateExtension); ok {
+		return boolField(unmarshal(findExtension(od.ParentFile(), "buf.validate.oneof"), b), "required")
+	}
+	return false
+}
+
+// option returns the value of the extension with the given field number in
+// opts, whether the extension is known to the options message or not. Varints
+// are returned as an integer and length-delimited values as bytes, with
+// repeated occurrences concatenated so that messages are merged.
+func option(opts protoreflect.ProtoMessage, num protowire.Number) (n uint64, b []byte, ok bool) {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return 0, nil, false
+	}
+	data, err := proto.Marshal(opts)
+	if err != nil {
+		return 0, nil, false
+	}
+	for len(data) > 0 {
+		fieldNum, typ, l := protowire.ConsumeTag(data)
+		if l < 0 {
+			return 0, nil, false
+		}
+		data = data[l:]
+		switch {
+		case fieldNum == num && typ == protowire.VarintType:
+			n, l = protowire.ConsumeVarint(data)
+			ok = true
+		case fieldNum == num && typ == protowire.BytesType:
+			var v []byte
+			v, l = protowire.ConsumeBytes(data)
+			b = append(b, v...)
+			ok = true
+		default:
+			l = protowire.ConsumeFieldValue(fieldNum, typ, data)
+		}
+		if l < 0 {
+			return 0, nil, false
+		}
+		data = data[l:]
+	}
+	return n, b, ok
+}
+
+// findExtension returns the message type of the named extension, declared in
+// file or one of its transitive imports, or nil if there is none.
+func findExtension(file protoreflect.FileDescriptor, name protoreflect.FullName) protoreflect.MessageDescriptor {
+	seen := map[string]bool{}
+	var find func(fd protoreflect.FileDescriptor) protoreflect.MessageDescriptor
+	find = func(fd protoreflect.FileDescriptor) protoreflect.MessageDescriptor {
+		if fd == nil || seen[fd.Path()] {
+			return nil
+		}
+		seen[fd.Path()] = true
+		if fd.Package() == name.Parent() {
+			if xd := fd.Extensions().ByName(name.Name()); xd != nil {
+				return xd.Message()
+			}
+		}
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			if md := find(imports.Get(i).FileDescriptor); md != nil {
+				return md
+			}
+		}
+		return nil
+	}
+	return find(file)
+}
+
+func unmarshal(md protoreflect.MessageDescriptor, b []byte) protoreflect.Message {
+	if md == nil {
+		return nil
+	}
+	msg := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(b, msg); err != nil {
+		return nil
+	}
+	return msg
+}
+
+// get returns the named field of msg, if it is set.
+func get(msg protoreflect.Message, name protoreflect.Name) (protoreflect.Value, bool) {
+	if msg == nil {
+		return protoreflect.Value{}, false
+	}
+	fd := msg.Descriptor().Fields().ByName(name)
+	if fd == nil || !msg.Has(fd) {
+		return protoreflect.Value{}, false
+	}
+	return msg.Get(fd), true
+}
+
+func subRules(msg protoreflect.Message, name protoreflect.Name) protoreflect.Message {
+	if v, ok := get(msg, name); ok {
+		if m, ok := v.Interface().(protoreflect.Message); ok {
+			return m
+		}
+	}
+	return nil
+}
+
+func boolField(msg protoreflect.Message, name protoreflect.Name) bool {
+	v, ok := get(msg, name)
+	if !ok {
+		return false
+	}
+	b, _ := v.Interface().(bool)
+	return b
+}
+
+func intField(msg protoreflect.Message, name protoreflect.Name) int64 {
+	v, ok := get(msg, name)
+	if !ok {
+		return 0
+	}
+	switch i := v.Interface().(type) {
+	case protoreflect.EnumNumber:
+		return int64(i)
+	case int32:
+		return int64(i)
+	}
+	return 0
+}
+
+func isZero(fd protoreflect.FieldDescriptor, val protoreflect.Value, elem bool) bool {
+	switch {
+	case fd.IsList() && !elem:
+		return val.List().Len() == 0
+	case fd.IsMap():
+		return val.Map().Len() == 0
+	case fd.Message() != nil:
+		return !val.Message().IsValid()
+	}
+	switch v := val.Interface().(type) {
+	case string:
+		return v == ""
+	case []byte:
+		return len(v) == 0
+	case bool:
+		return !v
+	case protoreflect.EnumNumber:
+		return v == 0
+	case int32:
+		return v == 0
+	case int64:
+		return v == 0
+	case uint32:
+		return v == 0
+	case uint64:
+		return v == 0
+	case float32:
+		return v == 0
+	case float64:
+		return v == 0
+	}
+	return false
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,171 @@
+package validate
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	_ "github.com/envoyproxy/protoc-gen-validate/validate"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const pgvProto = `
+syntax = "proto3";
+package test;
+import "validate/validate.proto";
+
+message Request {
+  string name = 1 [(validate.rules).string = {min_len: 3, max_len: 8}];
+  int32 age = 2 [(validate.rules).int32 = {gte: 0, lt: 150}];
+  string email = 3 [(validate.rules).string = {email: true, ignore_empty: true}];
+  repeated string tags = 4 [(validate.rules).repeated = {max_items: 2, unique: true, items: {string: {pattern: "^[a-z]+$"}}}];
+  Child child = 5 [(validate.rules).message.required = true];
+  map<string, Child> children = 6;
+  Kind kind = 7 [(validate.rules).enum.defined_only = true];
+  oneof id {
+    option (validate.required) = true;
+    string uuid = 8 [(validate.rules).string.uuid = true];
+    int64 number = 9;
+  }
+}
+
+message Child {
+  uint32 weight = 1 [(validate.rules).uint32 = {in: [1, 2, 3]}];
+}
+
+enum Kind {
+  KIND_UNKNOWN = 0;
+  KIND_SMALL = 1;
+}
+`
+
+// protovalidateProto declares the subset of buf/validate/validate.proto used
+// by the tests.
+const protovalidateProto = `
+syntax = "proto3";
+package buf.validate;
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.FieldOptions {
+  FieldConstraints field = 1159;
+}
+
+message FieldConstraints {
+  bool required = 25;
+  oneof type {
+    Int32Rules int32 = 3;
+    StringRules string = 14;
+  }
+}
+
+message Int32Rules {
+  optional int32 gt = 4;
+  optional int32 lte = 3;
+}
+
+message StringRules {
+  optional uint64 max_len = 3;
+  optional string prefix = 7;
+}
+`
+
+const protovalidateUserProto = `
+syntax = "proto3";
+package test;
+import "buf/validate/validate.proto";
+
+message Item {
+  string sku = 1 [(buf.validate.field).string = {prefix: "SKU-", max_len: 8}];
+  int32 count = 2 [(buf.validate.field).int32 = {gt: 0, lte: 10}];
+  Item parent = 3 [(buf.validate.field).required = true];
+}
+`
+
+func parse(t *testing.T, file, name string) protoreflect.MessageDescriptor {
+	t.Helper()
+	p := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{
+			"test.proto":                  pgvProto,
+			"item.proto":                  protovalidateUserProto,
+			"buf/validate/validate.proto": protovalidateProto,
+		}),
+		LookupImport: desc.LoadFileDescriptor,
+	}
+	fds, err := p.ParseFiles(file)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", file, err)
+	}
+	md := fds[0].FindMessage(name)
+	if md == nil {
+		t.Fatalf("message %s not found", name)
+	}
+	return md.UnwrapMessage()
+}
+
+func check(t *testing.T, md protoreflect.MessageDescriptor, js string, expected ...string) {
+	t.Helper()
+	msg := dynamicpb.NewMessage(md)
+	if err := protojson.Unmarshal([]byte(js), msg); err != nil {
+		t.Fatalf("failed to parse %s: %v", js, err)
+	}
+	var fields []string
+	for _, fv := range Message(msg) {
+		fields = append(fields, fv.Field)
+	}
+	sort.Strings(fields)
+	sort.Strings(expected)
+	if strings.Join(fields, ",") != strings.Join(expected, ",") {
+		t.Errorf("%s: expecting violations for %v, got %v", js, expected, Message(msg))
+	}
+}
+
+func TestProtocGenValidate(t *testing.T) {
+	md := parse(t, "test.proto", "test.Request")
+	valid := `"name": "abc", "age": 30, "child": {"weight": 1}, "uuid": "123e4567-e89b-12d3-a456-426614174000"`
+
+	check(t, md, `{`+valid+`}`)
+	check(t, md, `{"name": "ab", "child": {"weight": 2}, "number": 1}`, "name")
+	check(t, md, `{"name": "abcdefghi", "age": 150, "child": {"weight": 2}, "number": 1}`, "name", "age")
+	check(t, md, `{`+valid+`, "email": "not an email"}`, "email")
+	check(t, md, `{`+valid+`, "email": "someone@example.com"}`)
+	check(t, md, `{`+valid+`, "tags": ["a", "b", "c"]}`, "tags")
+	check(t, md, `{`+valid+`, "tags": ["a", "a"]}`, "tags")
+	check(t, md, `{`+valid+`, "tags": ["a", "B"]}`, "tags[1]")
+	check(t, md, `{"name": "abc"}`, "child", "id")
+	check(t, md, `{"name": "abc", "child": {"weight": 4}, "uuid": "nope"}`, "child.weight", "uuid")
+	check(t, md, `{`+valid+`, "children": {"x": {"weight": 9}}}`, `children["x"].weight`)
+	check(t, md, `{`+valid+`, "kind": 7}`, "kind")
+}
+
+func TestProtovalidate(t *testing.T) {
+	md := parse(t, "item.proto", "test.Item")
+
+	check(t, md, `{"sku": "SKU-1", "count": 1, "parent": {"sku": "SKU-2", "count": 10, "parent": {}}}`, "parent.parent.sku", "parent.parent.count", "parent.parent.parent")
+	check(t, md, `{"sku": "ABC-1", "count": 11}`, "sku", "count", "parent")
+	check(t, md, `{"sku": "SKU-123456", "count": 5, "parent": {"sku": "SKU-1", "count": 1, "parent": {"sku": "SKU-1", "count": 1}}}`, "sku", "parent.parent.parent")
+}
+
+func TestError(t *testing.T) {
+	if err := Error(nil); err != nil {
+		t.Errorf("expecting no error without violations, got %v", err)
+	}
+	err := Error([]*errdetails.BadRequest_FieldViolation{{Field: "name", Description: "value is required"}})
+	stat := status.Convert(err)
+	if stat.Code() != codes.InvalidArgument {
+		t.Errorf("expecting InvalidArgument, got %v", stat.Code())
+	}
+	if len(stat.Details()) != 1 {
+		t.Fatalf("expecting one detail, got %v", stat.Details())
+	}
+	br, ok := stat.Details()[0].(*errdetails.BadRequest)
+	if !ok || len(br.GetFieldViolations()) != 1 || br.GetFieldViolations()[0].GetField() != "name" {
+		t.Errorf("unexpected detail: %v", stat.Details()[0])
+	}
+}
@@ -385,6 +385,12 @@ func (e notFoundError) Error() string {
 	return string(e)
 }
 
+// IsNotFound reports whether err means that a symbol, service or method is
+// not known to a DescriptorSource.
+func IsNotFound(err error) bool {
+	return isNotFoundError(err)
+}
+
 func isNotFoundError(err error) bool {
 	if grpcreflect.IsElementNotFoundError(err) {
 		return true
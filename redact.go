@@ -0,0 +1,131 @@
+package gateway
+
+import (
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Redacted replaces the value of redacted string fields.
+const Redacted = "[REDACTED]"
+
+// NewRedactingFormatter returns a formatter that formats messages with f after
+// redacting their sensitive fields: those with the debug_redact option, and
+// those with the given fully-qualified names, e.g. "pkg.Message.field". It is
+// meant for messages written to logs, not for the output of RPCs.
+func NewRedactingFormatter(f Formatter, fields ...string) Formatter {
+	names := make(map[string]bool, len(fields))
+	for _, name := range fields {
+		names[name] = true
+	}
+	return func(msg proto.Message) (string, error) {
+		redacted, err := Redact(msg, names)
+		if err != nil {
+			return "", err
+		}
+		return f(redacted)
+	}
+}
+
+// Redact returns a copy of msg with its sensitive fields redacted: those with
+// the debug_redact option, and those whose fully-qualified names are in
+// fields. String fields are set to Redacted and other fields are cleared.
+func Redact(msg proto.Message, fields map[string]bool) (proto.Message, error) {
+	md, err := desc.LoadMessageDescriptorForMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	// copied through the wire format, since copies of dynamic messages share
+	// their nested messages
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	dm := dynamic.NewMessage(md)
+	if err := dm.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	if err := redact(dm, fields); err != nil {
+		return nil, err
+	}
+	return dm, nil
+}
+
+func redact(dm *dynamic.Message, fields map[string]bool) error {
+	for _, fd := range dm.GetMessageDescriptor().GetFields() {
+		if !dm.HasField(fd) {
+			continue
+		}
+		if isRedacted(fd, fields) {
+			redactField(dm, fd)
+			continue
+		}
+		if fd.GetMessageType() == nil {
+			continue
+		}
+		switch {
+		case fd.IsMap():
+			if fd.GetMapValueType().GetMessageType() == nil {
+				continue
+			}
+			var err error
+			dm.ForEachMapFieldEntry(fd, func(k, v interface{}) bool {
+				var sub *dynamic.Message
+				if sub, err = redactMessage(v.(proto.Message), fields); err == nil {
+					dm.PutMapField(fd, k, sub)
+				}
+				return err == nil
+			})
+			if err != nil {
+				return err
+			}
+		case fd.IsRepeated():
+			for i := 0; i < dm.FieldLength(fd); i++ {
+				sub, err := redactMessage(dm.GetRepeatedField(fd, i).(proto.Message), fields)
+				if err != nil {
+					return err
+				}
+				dm.SetRepeatedField(fd, i, sub)
+			}
+		default:
+			sub, err := redactMessage(dm.GetField(fd).(proto.Message), fields)
+			if err != nil {
+				return err
+			}
+			dm.SetField(fd, sub)
+		}
+	}
+	return nil
+}
+
+// redactMessage redacts a nested message, converting it to a dynamic message
+// if it is not one already.
+func redactMessage(msg proto.Message, fields map[string]bool) (*dynamic.Message, error) {
+	dm, ok := msg.(*dynamic.Message)
+	if !ok {
+		var err error
+		if dm, err = dynamic.AsDynamicMessage(msg); err != nil {
+			return nil, err
+		}
+	}
+	return dm, redact(dm, fields)
+}
+
+func isRedacted(fd *desc.FieldDescriptor, fields map[string]bool) bool {
+	return fd.GetFieldOptions().GetDebugRedact() || fields[fd.GetFullyQualifiedName()]
+}
+
+func redactField(dm *dynamic.Message, fd *desc.FieldDescriptor) {
+	if fd.GetType() != descriptorpb.FieldDescriptorProto_TYPE_STRING || fd.IsMap() {
+		dm.ClearField(fd)
+		return
+	}
+	if !fd.IsRepeated() {
+		dm.SetField(fd, Redacted)
+		return
+	}
+	for i := 0; i < dm.FieldLength(fd); i++ {
+		dm.SetRepeatedField(fd, i, Redacted)
+	}
+}
@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+const redactProto = `
+syntax = "proto3";
+package bank;
+
+message Card {
+  string number = 1 [debug_redact = true];
+  string holder = 2;
+  int32 cvv = 3;
+}
+
+message Account {
+  string id = 1;
+  Card card = 2;
+  repeated Card cards = 3;
+  map<string, Card> by_name = 4;
+  repeated string pins = 5 [debug_redact = true];
+}
+`
+
+func TestRedactingFormatter(t *testing.T) {
+	p := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(map[string]string{"bank.proto": redactProto})}
+	fds, err := p.ParseFiles("bank.proto")
+	if err != nil {
+		t.Fatalf("failed to parse proto: %v", err)
+	}
+	msg := dynamic.NewMessage(fds[0].FindMessage("bank.Account"))
+	const js = `{"id":"a1","card":{"number":"4111","holder":"joe","cvv":123},` +
+		`"cards":[{"number":"5500","cvv":456}],"byName":{"joe":{"number":"3400","holder":"joe"}},"pins":["1234","0000"]}`
+	if err := jsonpb.UnmarshalString(js, msg); err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+
+	formatter := NewRedactingFormatter((&jsonpb.Marshaler{}).MarshalToString, "bank.Card.cvv")
+	str, err := formatter(msg)
+	if err != nil {
+		t.Fatalf("failed to format message: %v", err)
+	}
+	expected := `{"id":"a1","card":{"number":"[REDACTED]","holder":"joe"},` +
+		`"cards":[{"number":"[REDACTED]"}],"byName":{"joe":{"number":"[REDACTED]","holder":"joe"}},"pins":["[REDACTED]","[REDACTED]"]}`
+	if str != expected {
+		t.Errorf("wrong redacted message:\nexpected %s\ngot      %s", expected, str)
+	}
+
+	// the message itself is left as it was
+	if str, _ := (&jsonpb.Marshaler{}).MarshalToString(msg); str != js {
+		t.Errorf("message was modified: %s", str)
+	}
+}
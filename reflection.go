@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jhump/protoreflect/grpcreflect"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	reflectpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// ReflectionVersion is a version of the server reflection API.
+type ReflectionVersion string
+
+const (
+	ReflectionV1      ReflectionVersion = "v1"
+	ReflectionV1Alpha ReflectionVersion = "v1alpha"
+)
+
+const reflectionV1Method = "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo"
+
+// reflectionVersions remembers the version of the reflection API supported by
+// each target, so that servers are only probed once, until they no longer
+// implement it.
+var reflectionVersions sync.Map
+
+// NewReflectionClient returns a server reflection client for cc, along with
+// the version of the reflection API it uses. The v1 API is used if the server
+// supports it; otherwise, if it responds with Unimplemented, the v1alpha API
+// is used. The outcome is remembered for the target of cc, until the v1alpha
+// API turns out to be unimplemented, e.g. because the server was upgraded,
+// so that the next client probes the server again. The context is
+// used both for probing the server and by the returned client, so it should
+// carry the metadata to send with reflection requests.
+func NewReflectionClient(ctx context.Context, cc *grpc.ClientConn) (*grpcreflect.Client, ReflectionVersion) {
+	version := reflectionVersion(ctx, cc)
+	if version == ReflectionV1Alpha {
+		stub := reflectionStub{reflectpb.NewServerReflectionClient(cc), cc.Target()}
+		return grpcreflect.NewClientV1Alpha(ctx, stub), version
+	}
+	// the v1 client falls back to v1alpha itself if the server turns out not to
+	// support v1 after all
+	return grpcreflect.NewClientAuto(ctx, cc), version
+}
+
+func reflectionVersion(ctx context.Context, cc *grpc.ClientConn) ReflectionVersion {
+	if v, ok := reflectionVersions.Load(cc.Target()); ok {
+		return v.(ReflectionVersion)
+	}
+	version, err := probeReflection(ctx, cc)
+	if err != nil {
+		// the server could not tell, so try again next time
+		return ReflectionV1
+	}
+	reflectionVersions.Store(cc.Target(), version)
+	return version
+}
+
+// probeReflection lists the services of the server with the v1 reflection API.
+// The messages of the v1 and v1alpha APIs are identical on the wire, so the
+// request is built with the v1alpha types.
+func probeReflection(ctx context.Context, cc *grpc.ClientConn) (ReflectionVersion, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := cc.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, reflectionV1Method)
+	if err == nil {
+		// errors sending are reported by RecvMsg, with the status of the RPC
+		_ = stream.SendMsg(&reflectpb.ServerReflectionRequest{
+			MessageRequest: &reflectpb.ServerReflectionRequest_ListServices{ListServices: "*"},
+		})
+		_ = stream.CloseSend()
+		err = stream.RecvMsg(&reflectpb.ServerReflectionResponse{})
+	}
+	switch status.Code(err) {
+	case codes.OK:
+		return ReflectionV1, nil
+	case codes.Unimplemented:
+		return ReflectionV1Alpha, nil
+	}
+	return "", err
+}
+
+// reflectionStub forgets the version remembered for target when the server
+// does not implement it.
+type reflectionStub struct {
+	reflectpb.ServerReflectionClient
+	target string
+}
+
+func (s reflectionStub) ServerReflectionInfo(ctx context.Context, opts ...grpc.CallOption) (reflectpb.ServerReflection_ServerReflectionInfoClient, error) {
+	stream, err := s.ServerReflectionClient.ServerReflectionInfo(ctx, opts...)
+	if err != nil {
+		forgetUnimplemented(s.target, err)
+		return nil, err
+	}
+	return reflectionStream{stream, s.target}, nil
+}
+
+type reflectionStream struct {
+	reflectpb.ServerReflection_ServerReflectionInfoClient
+	target string
+}
+
+func (s reflectionStream) Recv() (*reflectpb.ServerReflectionResponse, error) {
+	resp, err := s.ServerReflection_ServerReflectionInfoClient.Recv()
+	forgetUnimplemented(s.target, err)
+	return resp, err
+}
+
+func forgetUnimplemented(target string, err error) {
+	if status.Code(err) == codes.Unimplemented {
+		reflectionVersions.Delete(target)
+	}
+}
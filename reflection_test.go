@@ -0,0 +1,131 @@
+package gateway_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
+	reflectpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+
+	. "github.com/LCY2013/http-to-grpc-gateway"
+	grpcurl_testing "github.com/LCY2013/http-to-grpc-gateway/internal/testing"
+)
+
+func TestReflectionVersion(t *testing.T) {
+	testCases := []struct {
+		name     string
+		register func(*grpc.Server)
+		expected ReflectionVersion
+	}{
+		{"v1alpha", func(svr *grpc.Server) { reflection.Register(svr) }, ReflectionV1Alpha},
+		{"v1", registerReflectionV1, ReflectionV1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// targets of their own, as versions are remembered for the
+			// whole process
+			l, err := net.Listen("unix", filepath.Join(t.TempDir(), "server.sock"))
+			if err != nil {
+				t.Fatalf("failed to listen: %v", err)
+			}
+			svr := grpc.NewServer()
+			grpcurl_testing.RegisterTestServiceServer(svr, grpcurl_testing.TestServer{})
+			tc.register(svr)
+			go svr.Serve(l)
+			defer svr.Stop()
+
+			cc, err := grpc.Dial("unix://"+l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer cc.Close()
+
+			// the second client reuses the version found by the first
+			for i := 0; i < 2; i++ {
+				client, version := NewReflectionClient(context.Background(), cc)
+				if version != tc.expected {
+					t.Errorf("expecting reflection %s, got %s", tc.expected, version)
+				}
+				services, err := ListServices(DescriptorSourceFromServer(context.Background(), client))
+				client.Reset()
+				if err != nil {
+					t.Fatalf("failed to list services: %v", err)
+				}
+				if len(services) == 0 {
+					t.Error("expecting services to be listed")
+				}
+			}
+		})
+	}
+}
+
+// registerReflectionV1 registers the v1 reflection API alone, which has the
+// same messages as v1alpha under another name.
+func registerReflectionV1(svr *grpc.Server) {
+	sd := reflectpb.ServerReflection_ServiceDesc
+	sd.ServiceName = "grpc.reflection.v1.ServerReflection"
+	svr.RegisterService(&sd, reflection.NewServer(reflection.ServerOptions{Services: svr}))
+}
+
+func TestReflectionVersionUpgrade(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "server.sock")
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	serve := func(l net.Listener, register func(*grpc.Server)) *grpc.Server {
+		svr := grpc.NewServer()
+		grpcurl_testing.RegisterTestServiceServer(svr, grpcurl_testing.TestServer{})
+		register(svr)
+		go svr.Serve(l)
+		return svr
+	}
+	svr := serve(l, func(svr *grpc.Server) { reflection.Register(svr) })
+
+	cc, err := grpc.Dial("unix://"+addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer cc.Close()
+	listServices := func() (ReflectionVersion, error) {
+		client, version := NewReflectionClient(context.Background(), cc)
+		defer client.Reset()
+		_, err := ListServices(DescriptorSourceFromServer(context.Background(), client))
+		return version, err
+	}
+	if version, err := listServices(); version != ReflectionV1Alpha || err != nil {
+		t.Fatalf("expecting reflection v1alpha, got %s %v", version, err)
+	}
+
+	// the server is replaced by one that only supports v1
+	svr.Stop()
+	if l, err = net.Listen("unix", addr); err != nil {
+		t.Fatalf("failed to listen again: %v", err)
+	}
+	defer serve(l, registerReflectionV1).Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for state := cc.GetState(); state == connectivity.Ready; state = cc.GetState() {
+		cc.WaitForStateChange(ctx, state)
+	}
+	for state := cc.GetState(); state != connectivity.Ready; state = cc.GetState() {
+		cc.Connect()
+		if !cc.WaitForStateChange(ctx, state) {
+			t.Fatalf("failed to connect to the new server: %s", state)
+		}
+	}
+
+	// the remembered version fails, after which the server is probed again
+	if version, err := listServices(); version != ReflectionV1Alpha || err == nil {
+		t.Errorf("expecting the remembered v1alpha to fail, got %s %v", version, err)
+	}
+	if version, err := listServices(); version != ReflectionV1 || err != nil {
+		t.Errorf("expecting reflection v1, got %s %v", version, err)
+	}
+}
@@ -1,4 +1,4 @@
-package gateway
+package gateway_test
 
 import (
 	"context"
@@ -11,6 +11,8 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+
+	. "github.com/LCY2013/http-to-grpc-gateway"
 )
 
 func TestPlainText(t *testing.T) {